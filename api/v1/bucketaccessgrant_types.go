@@ -0,0 +1,79 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// BucketAccessGrantSpec gives another workspace, or a single user, access to folders
+// of the bucket of the workspace whose namespace holds the grant
+type BucketAccessGrantSpec struct {
+	// workspace given access, it needs an s3 identity
+	Workspace string `json:"workspace,omitempty"`
+	// user of the object store given access, instead of a workspace. It must be the identity
+	// of a workspace or one of the grantUsers of the operator configuration
+	User string `json:"user,omitempty"`
+	// granted folders of the bucket, relative to the folder of the workspace in a shared bucket
+	//+kubebuilder:validation:MinItems=1
+	Paths []string `json:"paths"`
+	//+kubebuilder:validation:Enum=read;read-write
+	//+kubebuilder:default=read
+	Access string `json:"access,omitempty"`
+	// the access is revoked at this time, never if empty
+	ExpiresAt *metav1.Time `json:"expiresAt,omitempty"`
+}
+
+// BucketAccessGrantStatus defines the observed state of BucketAccessGrant
+type BucketAccessGrantStatus struct {
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// Conditions represent the latest available observations of the grant state
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+	// workspace whose bucket is shared
+	SourceWorkspace string `json:"sourceWorkspace,omitempty"`
+	// s3 identity given access
+	Identity string `json:"identity,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Workspace",type=string,JSONPath=`.spec.workspace`
+//+kubebuilder:printcolumn:name="User",type=string,JSONPath=`.spec.user`
+//+kubebuilder:printcolumn:name="Access",type=string,JSONPath=`.spec.access`
+//+kubebuilder:printcolumn:name="Expires",type=string,format=date-time,JSONPath=`.spec.expiresAt`
+
+// BucketAccessGrant is the Schema for the bucketaccessgrants API
+type BucketAccessGrant struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   BucketAccessGrantSpec   `json:"spec,omitempty"`
+	Status BucketAccessGrantStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// BucketAccessGrantList contains a list of BucketAccessGrant
+type BucketAccessGrantList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []BucketAccessGrant `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&BucketAccessGrant{}, &BucketAccessGrantList{})
+}
@@ -0,0 +1,83 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// S3BackendAccessKeyKey is the key of the access key in the credentials secret
+	S3BackendAccessKeyKey = "accessKey"
+	// S3BackendSecretKeyKey is the key of the secret key in the credentials secret
+	S3BackendSecretKeyKey = "secretKey"
+)
+
+// S3BackendSpec defines the object store a workspace bucket is provisioned into
+type S3BackendSpec struct {
+	// provider of the object store
+	//+kubebuilder:validation:Enum=minio;aws;ceph-rgw
+	Provider string `json:"provider"`
+	// host[:port] of the s3 api
+	Endpoint string `json:"endpoint,omitempty"`
+	Region   string `json:"region,omitempty"`
+	TLS      TLS    `json:"tls,omitempty"`
+	// secret holding the accessKey and secretKey of the admin account
+	CredentialsSecretRef corev1.SecretReference `json:"credentialsSecretRef"`
+	// path of the Admin Ops API, ceph-rgw only
+	RgwAdminPath string `json:"rgwAdminPath,omitempty"`
+}
+
+// TLS defines how the operator connects to the object store
+type TLS struct {
+	Enabled bool `json:"enabled,omitempty"`
+	// secret holding, under ca.crt, the certificate authorities to trust in addition to the system ones
+	CASecretRef *corev1.SecretReference `json:"caSecretRef,omitempty"`
+	// kubernetes.io/tls secret holding the client certificate presented to the object store
+	ClientCertSecretRef *corev1.SecretReference `json:"clientCertSecretRef,omitempty"`
+	// name used for SNI and certificate verification, the endpoint host if empty
+	ServerName string `json:"serverName,omitempty"`
+	// disables the verification of the server certificate, for tests only
+	InsecureSkipVerify bool `json:"insecureSkipVerify,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:resource:scope=Cluster
+//+kubebuilder:printcolumn:name="Provider",type=string,JSONPath=`.spec.provider`
+//+kubebuilder:printcolumn:name="Endpoint",type=string,JSONPath=`.spec.endpoint`
+
+// S3Backend is the Schema for the s3backends API
+type S3Backend struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec S3BackendSpec `json:"spec,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// S3BackendList contains a list of S3Backend
+type S3BackendList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []S3Backend `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&S3Backend{}, &S3BackendList{})
+}
@@ -17,6 +17,7 @@ limitations under the License.
 package v1
 
 import (
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -31,6 +32,76 @@ type WorkspaceSpec struct {
 	Namespace string `json:"namespace,omitempty"`
 	Quota     Quota  `json:"quota,omitempty"`
 	Bucket    Bucket `json:"bucket,omitempty"`
+	// owners of the workspace, admins of its namespace
+	Owners []Subject `json:"owners,omitempty"`
+	// members of the workspace, given a role in its namespace
+	Members []Member `json:"members,omitempty"`
+	// network policies of the namespace, on top of the templates of the operator configuration
+	NetworkPolicies NetworkPolicies `json:"networkPolicies,omitempty"`
+	// Pod Security Standard enforced, audited and warned about in the namespace,
+	// the podSecurity of the operator configuration if empty
+	PodSecurity *PodSecurity `json:"podSecurity,omitempty"`
+	// labels and annotations of the namespace
+	NamespaceMetadata NamespaceMetadata `json:"namespaceMetadata,omitempty"`
+}
+
+// NamespaceMetadata defines labels and annotations of the namespace. Keys removed from
+// the workspace are removed from the namespace, keys set by other tools are kept.
+type NamespaceMetadata struct {
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// PodSecurity defines the Pod Security Standard of the namespace
+type PodSecurity struct {
+	// privileged needs the workspace in the privilegedWorkspaces of the operator configuration.
+	// The approval is not an annotation of the workspace, which anyone editing it could set.
+	//+kubebuilder:validation:Enum=privileged;baseline;restricted
+	Level string `json:"level"`
+	// version of the standard, e.g. v1.26, latest by default
+	Version string `json:"version,omitempty"`
+}
+
+// NetworkPolicies turns the network policy templates on or off and allows extra traffic
+type NetworkPolicies struct {
+	// templates of the operator configuration turned on (true) or off (false) by name,
+	// the others are installed unless they are optional
+	Toggles map[string]bool `json:"toggles,omitempty"`
+	// extra ingress traffic allowed into the namespace
+	AllowIngress []NetworkAllowRule `json:"allowIngress,omitempty"`
+	// extra egress traffic allowed out of the namespace, when an egress template restricts it
+	AllowEgress []NetworkAllowRule `json:"allowEgress,omitempty"`
+}
+
+// NetworkAllowRule allows traffic with namespaces or IP blocks, at least one of them
+type NetworkAllowRule struct {
+	// namespaces, by name
+	Namespaces []string `json:"namespaces,omitempty"`
+	// namespaces, by labels
+	NamespaceLabels map[string]string `json:"namespaceLabels,omitempty"`
+	// IP blocks, e.g. 10.0.0.0/8
+	CIDRs []string `json:"cidrs,omitempty"`
+	// TCP ports allowed, any port if empty
+	Ports []int32 `json:"ports,omitempty"`
+}
+
+// Subject is a user, an OIDC group or a service account
+type Subject struct {
+	//+kubebuilder:validation:Enum=User;Group;ServiceAccount
+	Kind string `json:"kind"`
+	// name of the user or group, as authenticated by the api server (OIDC prefix included), or of the service account
+	Name string `json:"name"`
+	// namespace of the service account, the workspace namespace if empty
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// Member is a subject given a role in the workspace namespace
+type Member struct {
+	Subject `json:",inline"`
+	// role of the member, bound to the ClusterRole the operator configuration maps it to
+	//+kubebuilder:validation:Enum=admin;editor;viewer
+	//+kubebuilder:default=viewer
+	Role string `json:"role,omitempty"`
 }
 
 // WorkspaceStatus defines the observed state of Workspace
@@ -40,10 +111,106 @@ type WorkspaceStatus struct {
 	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
 	// Conditions represent the latest available observations of an object's state
 	Conditions []metav1.Condition `json:"conditions"`
+	// observed state of the bucket
+	Bucket BucketStatus `json:"bucket,omitempty"`
+	// observed state of the namespace
+	Namespace NamespaceStatus `json:"namespace,omitempty"`
+	// observed state of the WorkspaceAddons installed in the namespace
+	Addons []AddonStatus `json:"addons,omitempty"`
+	// S3 identity of the workspace, set while it exists
+	Identity *IdentityStatus `json:"identity,omitempty"`
+}
+
+// IdentityStatus defines the observed state of the S3 identity of the workspace
+type IdentityStatus struct {
+	// name of the identity
+	Name string `json:"name"`
+	// bucket the identity is allowed on
+	Bucket string `json:"bucket"`
+	// folder of the shared bucket the identity is allowed on, empty when it is allowed on the whole bucket
+	Prefix string `json:"prefix,omitempty"`
+	// secret of the workspace namespace holding the keys of the identity
+	SecretName string `json:"secretName"`
+}
+
+// AddonStatus defines the observed state of a WorkspaceAddon of the workspace
+type AddonStatus struct {
+	// name of the WorkspaceAddon
+	Name string `json:"name"`
+	// objects of the addon installed in the namespace
+	Objects []AddonObject `json:"objects,omitempty"`
+	// why the addon could not be rendered or installed, empty if it is installed
+	Error string `json:"error,omitempty"`
+}
+
+// AddonObject identifies an object installed in the namespace by a WorkspaceAddon
+type AddonObject struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Name       string `json:"name"`
+}
+
+// NamespaceStatus defines the observed state of the namespace
+type NamespaceStatus struct {
+	// keys of the labels of namespaceMetadata the operator set on the namespace
+	ManagedLabels []string `json:"managedLabels,omitempty"`
+	// keys of the annotations of namespaceMetadata the operator set on the namespace
+	ManagedAnnotations []string `json:"managedAnnotations,omitempty"`
+	// whether the operator set the Pod Security Admission labels of the namespace
+	PodSecurityApplied bool `json:"podSecurityApplied,omitempty"`
+}
+
+// BucketStatus defines the observed state of the bucket
+type BucketStatus struct {
+	// bucket holding the data of the workspace
+	Name string `json:"name,omitempty"`
+	// folder of the workspace in a shared bucket, empty when it has its own bucket
+	Prefix string `json:"prefix,omitempty"`
+	// effective default encryption of the bucket, SSE-S3, SSE-KMS or none
+	Encryption string `json:"encryption,omitempty"`
+	// KMS key of the bucket, SSE-KMS only
+	KMSKeyID string `json:"kmsKeyId,omitempty"`
+	// legal hold last applied to the objects of the bucket
+	LegalHold string `json:"legalHold,omitempty"`
+	// ids of the lifecycle rules the operator set on the bucket
+	LifecycleRules []string `json:"lifecycleRules,omitempty"`
+	// whether the CORS rules of the bucket were set by the operator
+	CORSRulesApplied bool `json:"corsRulesApplied,omitempty"`
+	// whether the default encryption of the bucket was set by the operator
+	EncryptionApplied bool `json:"encryptionApplied,omitempty"`
+	// ids of the event notifications the operator set on the bucket
+	Notifications []string `json:"notifications,omitempty"`
+	// bytes stored in the bucket
+	UsedBytes int64 `json:"usedBytes,omitempty"`
+	// objects stored in the bucket
+	ObjectCount int64 `json:"objectCount,omitempty"`
+	// used bytes in percent of the quota, 0 without quota
+	PercentOfQuota int32 `json:"percentOfQuota,omitempty"`
+	// last refresh of the usage
+	UsageUpdateTime *metav1.Time `json:"usageUpdateTime,omitempty"`
+	// progress of the copy of the seed objects, set when seedFrom is first set
+	Seed *SeedStatus `json:"seed,omitempty"`
+}
+
+// SeedStatus defines the progress of the copy of the seed objects
+type SeedStatus struct {
+	// Copying until every object is copied, then Completed. A completed seed is never copied again.
+	//+kubebuilder:validation:Enum=Copying;Completed
+	Phase string `json:"phase"`
+	// key of the last copied source object, the copy resumes after it
+	LastCopiedKey string `json:"lastCopiedKey,omitempty"`
+	// objects copied so far
+	CopiedObjects int64 `json:"copiedObjects,omitempty"`
+	// bytes copied so far
+	CopiedBytes int64 `json:"copiedBytes,omitempty"`
+	// end of the copy
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
 }
 
 //+kubebuilder:object:root=true
 //+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Bucket",type=string,JSONPath=`.spec.bucket.name`
+//+kubebuilder:printcolumn:name="Quota %",type=integer,JSONPath=`.status.bucket.percentOfQuota`
 
 // Workspace is the Schema for the workspaces API
 type Workspace struct {
@@ -72,10 +239,161 @@ type Bucket struct {
 	// string
 	//should respect s3 patterns
 	Name string `json:"name,omitempty"`
-	// quota in Go
-	Quota int64 `json:"quota,omitempty"`
-	//default paths
+	// quota of the bucket, e.g. 50Gi. A plain integer, as in earlier versions, is a number of bytes.
+	Quota *resource.Quantity `json:"quota,omitempty"`
+	// hard quotas are enforced by the object store, soft quotas are only reported in the status
+	//+kubebuilder:validation:Enum=hard;soft
+	//+kubebuilder:default=hard
+	QuotaType string `json:"quotaType,omitempty"`
+	// maximum number of objects of the bucket, unlimited if 0. Not supported by minio, reported in the BucketSettingsApplied condition.
+	//+kubebuilder:validation:Minimum=0
+	ObjectQuota int64 `json:"objectQuota,omitempty"`
+	// folders created in the bucket, nested folders are separated by slashes, e.g. diffusion/2023
 	Paths []string `json:"paths,omitempty"`
+	// content of a README.md object seeded in each new path. It is a Go template
+	// given .Workspace, .Namespace, .Bucket and .Path.
+	Readme string `json:"readme,omitempty"`
+	// bucket shared with other workspaces, in which the workspace gets the folder
+	// named after the bucket name instead of its own bucket. Defaults to the
+	// sharedBucket of the operator configuration, and must be one of its shared buckets.
+	// The folder is claimed by the first workspace using it.
+	SharedBucket string `json:"sharedBucket,omitempty"`
+	// name of the S3Backend hosting the bucket, the operator default backend if empty
+	BackendRef string `json:"backendRef,omitempty"`
+	// when set, the workspace gets its own S3 identity, onyxia-<bucket name>, only allowed
+	// on the bucket, whose keys are written in a secret of the workspace namespace.
+	// An existing identity of that name is never taken over.
+	Credentials *BucketCredentials `json:"credentials,omitempty"`
+	// access modes of the paths of the bucket, turned into its bucket policy.
+	// Paths not listed are private.
+	Access []PathAccess `json:"access,omitempty"`
+	// versioning of the objects of the bucket, left untouched if empty
+	//+kubebuilder:validation:Enum=Enabled;Suspended
+	Versioning string `json:"versioning,omitempty"`
+	// WORM retention of the objects, object lock can only be enabled when the bucket is created
+	ObjectLock *ObjectLock `json:"objectLock,omitempty"`
+	// puts (ON) or releases (OFF) the legal hold of the objects of the bucket, needs object lock.
+	// Applied to the objects present when the value changes. Left untouched if empty.
+	//+kubebuilder:validation:Enum=ON;OFF
+	LegalHold string `json:"legalHold,omitempty"`
+	// lifecycle rules of the bucket, in addition to the default rules of the operator configuration.
+	// A rule overrides the default rule with the same id.
+	Lifecycle []LifecycleRule `json:"lifecycle,omitempty"`
+	// CORS rules of the bucket, after the default rules of the operator configuration,
+	// e.g. to let the Onyxia UI browse and upload files from the browser
+	CORS []CORSRule `json:"cors,omitempty"`
+	// event notifications of the bucket, e.g. to trigger pipelines when files land in diffusion/
+	Notifications []BucketNotification `json:"notifications,omitempty"`
+	// default server-side encryption of the objects of the bucket
+	Encryption *Encryption `json:"encryption,omitempty"`
+	// the bucket holds sensitive data, the workspace is reported degraded while it is unencrypted
+	Sensitive bool `json:"sensitive,omitempty"`
+	// objects copied into the bucket once, when seedFrom is first set, e.g. the datasets of
+	// a course. The source must be one of the seedSources of the operator configuration.
+	SeedFrom *BucketSeed `json:"seedFrom,omitempty"`
+}
+
+// BucketSeed defines the objects a new bucket is seeded with
+type BucketSeed struct {
+	// bucket holding the objects to copy
+	Bucket string `json:"bucket"`
+	// prefix of the objects to copy, the whole bucket if empty
+	Prefix string `json:"prefix,omitempty"`
+	// name of the S3Backend hosting the source bucket, the backend of the workspace if empty.
+	// Objects are copied server-side on the same backend, downloaded and uploaded again otherwise.
+	BackendRef string `json:"backendRef,omitempty"`
+	// folder of the bucket the objects are copied to, e.g. diffusion. The objects
+	// keep their key relative to the source prefix.
+	Path string `json:"path,omitempty"`
+}
+
+// CORSRule allows web pages of the origins to call the bucket from the browser
+type CORSRule struct {
+	// origins allowed, e.g. https://datalab.example.com, * allows any origin
+	//+kubebuilder:validation:MinItems=1
+	AllowedOrigins []string `json:"allowedOrigins"`
+	// methods allowed, among GET, PUT, POST, DELETE and HEAD
+	//+kubebuilder:validation:MinItems=1
+	AllowedMethods []string `json:"allowedMethods"`
+	// request headers allowed in preflight requests, * allows any header
+	AllowedHeaders []string `json:"allowedHeaders,omitempty"`
+	// response headers the browser lets scripts read, e.g. ETag for multipart uploads
+	ExposeHeaders []string `json:"exposeHeaders,omitempty"`
+	// seconds the browser caches the preflight response
+	//+kubebuilder:validation:Minimum=0
+	MaxAgeSeconds int32 `json:"maxAgeSeconds,omitempty"`
+}
+
+// BucketNotification sends the events of the objects matching the filters to a target
+type BucketNotification struct {
+	ID string `json:"id"`
+	// name of a notification target registered by the administrators in the operator configuration
+	Target string `json:"target"`
+	// event types, e.g. s3:ObjectCreated:*
+	//+kubebuilder:validation:MinItems=1
+	Events []string `json:"events"`
+	// notifies only the objects whose key starts with the prefix, e.g. diffusion/
+	Prefix string `json:"prefix,omitempty"`
+	// notifies only the objects whose key ends with the suffix, e.g. .csv
+	Suffix string `json:"suffix,omitempty"`
+}
+
+// Encryption defines the default server-side encryption of the bucket
+type Encryption struct {
+	//+kubebuilder:validation:Enum=SSE-S3;SSE-KMS
+	Type string `json:"type"`
+	// KMS key encrypting the objects, SSE-KMS only
+	KMSKeyID string `json:"kmsKeyId,omitempty"`
+	// creates the KMS key when it does not exist, SSE-KMS only
+	CreateKey bool `json:"createKey,omitempty"`
+}
+
+// LifecycleRule expires the objects under a prefix
+type LifecycleRule struct {
+	ID string `json:"id"`
+	// objects the rule applies to, the whole bucket if empty
+	Prefix string `json:"prefix,omitempty"`
+	// days after which current objects expire
+	//+kubebuilder:validation:Minimum=0
+	ExpirationDays int32 `json:"expirationDays,omitempty"`
+	// days after which noncurrent versions expire
+	//+kubebuilder:validation:Minimum=0
+	NoncurrentVersionExpirationDays int32 `json:"noncurrentVersionExpirationDays,omitempty"`
+	// days after which incomplete multipart uploads are aborted
+	//+kubebuilder:validation:Minimum=0
+	AbortIncompleteMultipartUploadDays int32 `json:"abortIncompleteMultipartUploadDays,omitempty"`
+}
+
+// ObjectLock defines the default retention of the objects of the bucket
+type ObjectLock struct {
+	// retention mode applied by default to new objects, no default retention if empty
+	//+kubebuilder:validation:Enum=GOVERNANCE;COMPLIANCE
+	Mode string `json:"mode,omitempty"`
+	// default retention period in days, exclusive with years
+	//+kubebuilder:validation:Minimum=0
+	Days int32 `json:"days,omitempty"`
+	// default retention period in years, exclusive with days
+	//+kubebuilder:validation:Minimum=0
+	Years int32 `json:"years,omitempty"`
+}
+
+// PathAccess defines who can read or write a path of the bucket
+type PathAccess struct {
+	// path in the bucket, the whole bucket if empty
+	Path string `json:"path"`
+	//+kubebuilder:validation:Enum=private;public-read;shared
+	//+kubebuilder:default=private
+	Mode string `json:"mode,omitempty"`
+	// principals the path is shared with, in the object store syntax (e.g. role ARNs on aws), shared mode only.
+	// MinIO bucket policies can't match groups, they are reported in the BucketSettingsApplied condition
+	Groups []string `json:"groups,omitempty"`
+	// workspaces, in the same namespace, whose S3 identity the path is shared with, shared mode only
+	Workspaces []string `json:"workspaces,omitempty"`
+}
+
+type BucketCredentials struct {
+	// name of the secret holding the keys, onyxia-s3 by default
+	SecretName string `json:"secretName,omitempty"`
 }
 
 type Quota struct {
@@ -0,0 +1,58 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// WorkspaceAddonSpec defines Kubernetes manifests installed in the namespace of the
+// matching workspaces. The manifests may hold ConfigMaps, Roles, RoleBindings and
+// PodDisruptionBudgets, the kinds the operator has RBAC on. Roles can't grant more
+// than the operator holds, RoleBindings may only bind the Roles of the addon and the
+// addonClusterRoles of the operator configuration.
+type WorkspaceAddonSpec struct {
+	// labels of the workspaces the addon is installed for, every workspace if empty
+	WorkspaceSelector *metav1.LabelSelector `json:"workspaceSelector,omitempty"`
+	// Go template of namespaced manifests, YAML documents separated by ---, rendered
+	// with the .Name, .Namespace, .Bucket, .BucketPrefix and .Owners of the workspace
+	Manifests string `json:"manifests"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:resource:scope=Cluster
+
+// WorkspaceAddon is the Schema for the workspaceaddons API
+type WorkspaceAddon struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec WorkspaceAddonSpec `json:"spec,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// WorkspaceAddonList contains a list of WorkspaceAddon
+type WorkspaceAddonList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []WorkspaceAddon `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&WorkspaceAddon{}, &WorkspaceAddonList{})
+}
@@ -0,0 +1,301 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	onyxiav1 "github.com/inseefrlab/onyxia-onboarding-operator/api/v1"
+	"github.com/inseefrlab/onyxia-onboarding-operator/controllers/s3/factory"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+// finalizer revoking the access given by a grant
+const bucketAccessGrantFinalizer = "onyxia.sh/bucket-access-grant"
+
+// BucketAccessGrantReconciler turns the BucketAccessGrants into policy statements
+// on the identities they give access to. The grants of an identity are always
+// applied all together, so that deleted and expired grants are revoked.
+type BucketAccessGrantReconciler struct {
+	client.Client
+	Scheme    *runtime.Scheme
+	S3Clients *S3ClientPool
+	Config    OperatorConfig
+}
+
+// resolvedGrant is a grant with the workspace whose bucket it shares and the identity given access
+type resolvedGrant struct {
+	source   *onyxiav1.Workspace
+	identity string
+}
+
+//+kubebuilder:rbac:groups=onyxia.onyxia.sh,resources=bucketaccessgrants,verbs=get;list;watch;update;patch
+//+kubebuilder:rbac:groups=onyxia.onyxia.sh,resources=bucketaccessgrants/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=onyxia.onyxia.sh,resources=bucketaccessgrants/finalizers,verbs=update
+
+func (r *BucketAccessGrantReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	grant := &onyxiav1.BucketAccessGrant{}
+	err := r.Get(ctx, req.NamespacedName, grant)
+	if err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	if !grant.DeletionTimestamp.IsZero() {
+		if !controllerutil.ContainsFinalizer(grant, bucketAccessGrantFinalizer) {
+			return ctrl.Result{}, nil
+		}
+		err = r.revoke(ctx, grant)
+		if err != nil {
+			log.Log.Error(err, err.Error())
+			return ctrl.Result{}, err
+		}
+		controllerutil.RemoveFinalizer(grant, bucketAccessGrantFinalizer)
+		return ctrl.Result{}, r.Update(ctx, grant)
+	}
+	if !controllerutil.ContainsFinalizer(grant, bucketAccessGrantFinalizer) {
+		controllerutil.AddFinalizer(grant, bucketAccessGrantFinalizer)
+		err = r.Update(ctx, grant)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	resolved, err := r.resolve(ctx, grant)
+	if err != nil {
+		// the grant doesn't resolve anymore, revoke the access it gave
+		err = utilerrors.NewAggregate([]error{err, r.revoke(ctx, grant)})
+	} else {
+		grant.Status.SourceWorkspace = resolved.source.Name
+		if grant.Status.Identity != "" && grant.Status.Identity != resolved.identity {
+			// the grant moved to another identity, revoke it from the previous one
+			err = r.applyGrants(ctx, grant.Status.Identity, resolved.source.Spec.Bucket.BackendRef)
+		}
+	}
+	if err == nil {
+		grant.Status.Identity = resolved.identity
+		err = r.applyGrants(ctx, resolved.identity, resolved.source.Spec.Bucket.BackendRef)
+	}
+	if err != nil {
+		log.Log.Error(err, err.Error())
+		meta.SetStatusCondition(&grant.Status.Conditions,
+			metav1.Condition{
+				Type:               "Ready",
+				Status:             metav1.ConditionFalse,
+				Reason:             "ReasonFailed",
+				LastTransitionTime: metav1.NewTime(time.Now()),
+				Message:            err.Error(),
+				ObservedGeneration: grant.GetGeneration(),
+			})
+		return ctrl.Result{}, utilerrors.NewAggregate([]error{err, r.Status().Update(ctx, grant)})
+	}
+
+	grant.Status.ObservedGeneration = grant.GetGeneration()
+	result := ctrl.Result{}
+	if expired(grant) {
+		meta.SetStatusCondition(&grant.Status.Conditions, metav1.Condition{
+			Type:               "Ready",
+			Status:             metav1.ConditionFalse,
+			Reason:             "Expired",
+			LastTransitionTime: metav1.NewTime(time.Now()),
+			Message:            "access revoked at " + grant.Spec.ExpiresAt.String(),
+			ObservedGeneration: grant.GetGeneration(),
+		})
+	} else {
+		meta.SetStatusCondition(&grant.Status.Conditions, metav1.Condition{
+			Type:               "Ready",
+			Status:             metav1.ConditionTrue,
+			Reason:             "ReasonSucceeded",
+			LastTransitionTime: metav1.NewTime(time.Now()),
+			Message:            "access granted to " + resolved.identity,
+			ObservedGeneration: grant.GetGeneration(),
+		})
+		if grant.Spec.ExpiresAt != nil {
+			// come back to revoke the access
+			result.RequeueAfter = time.Until(grant.Spec.ExpiresAt.Time) + time.Second
+		}
+	}
+	return result, r.Status().Update(ctx, grant)
+}
+
+// revoke applies again the grants of the identity the grant gave access to, without it
+func (r *BucketAccessGrantReconciler) revoke(ctx context.Context, grant *onyxiav1.BucketAccessGrant) error {
+	if grant.Status.Identity == "" {
+		return nil
+	}
+	// the source workspace may be gone, its backend is then the default one
+	backendRef := ""
+	if source, err := r.sourceWorkspace(ctx, grant); err == nil {
+		backendRef = source.Spec.Bucket.BackendRef
+	}
+	err := r.applyGrants(ctx, grant.Status.Identity, backendRef)
+	if err != nil {
+		return err
+	}
+	grant.Status.Identity = ""
+	return nil
+}
+
+// expired reports whether the access given by the grant must be revoked
+func expired(grant *onyxiav1.BucketAccessGrant) bool {
+	return grant.Spec.ExpiresAt != nil && !grant.Spec.ExpiresAt.After(time.Now())
+}
+
+// sourceWorkspace returns the workspace whose namespace holds the grant
+func (r *BucketAccessGrantReconciler) sourceWorkspace(ctx context.Context, grant *onyxiav1.BucketAccessGrant) (*onyxiav1.Workspace, error) {
+	workspaces := &onyxiav1.WorkspaceList{}
+	err := r.List(ctx, workspaces)
+	if err != nil {
+		return nil, err
+	}
+	for i := range workspaces.Items {
+		if workspaces.Items[i].Spec.Namespace == grant.Namespace {
+			return &workspaces.Items[i], nil
+		}
+	}
+	return nil, fmt.Errorf("no workspace owns namespace %s", grant.Namespace)
+}
+
+// resolve finds the workspace whose bucket the grant shares and the identity it gives access to
+func (r *BucketAccessGrantReconciler) resolve(ctx context.Context, grant *onyxiav1.BucketAccessGrant) (resolvedGrant, error) {
+	source, err := r.sourceWorkspace(ctx, grant)
+	if err != nil {
+		return resolvedGrant{}, err
+	}
+	if (grant.Spec.Workspace == "") == (grant.Spec.User == "") {
+		return resolvedGrant{}, fmt.Errorf("grant %s must name either a workspace or a user", grant.Name)
+	}
+	if grant.Spec.User != "" {
+		err = r.checkUser(ctx, grant.Spec.User, source.Spec.Bucket.BackendRef)
+		if err != nil {
+			return resolvedGrant{}, err
+		}
+		return resolvedGrant{source: source, identity: grant.Spec.User}, nil
+	}
+	target := &onyxiav1.Workspace{}
+	err = r.Get(ctx, client.ObjectKey{Namespace: source.Namespace, Name: grant.Spec.Workspace}, target)
+	if err != nil {
+		return resolvedGrant{}, fmt.Errorf("can't get workspace %s: %w", grant.Spec.Workspace, err)
+	}
+	if target.Spec.Bucket.Credentials == nil || target.Status.Identity == nil {
+		return resolvedGrant{}, fmt.Errorf("can't give access to workspace %s, it has no s3 identity", target.Name)
+	}
+	if target.Spec.Bucket.BackendRef != source.Spec.Bucket.BackendRef {
+		return resolvedGrant{}, fmt.Errorf("can't give access to workspace %s, its bucket is on another s3 backend", target.Name)
+	}
+	return resolvedGrant{source: source, identity: target.Status.Identity.Name}, nil
+}
+
+// checkUser refuses the users a grant can't give access to: only the identities of
+// workspaces on the same backend and the grantUsers of the operator configuration
+// can be named, so that grants never rewrite the policies of the operator or of
+// administrators.
+func (r *BucketAccessGrantReconciler) checkUser(ctx context.Context, user string, backendRef string) error {
+	for _, grantUser := range r.Config.GrantUsers {
+		if grantUser == user {
+			return nil
+		}
+	}
+	workspaces := &onyxiav1.WorkspaceList{}
+	err := r.List(ctx, workspaces)
+	if err != nil {
+		return err
+	}
+	for _, workspace := range workspaces.Items {
+		if workspace.Spec.Bucket.BackendRef == backendRef && workspace.Status.Identity != nil && workspace.Status.Identity.Name == user {
+			return nil
+		}
+	}
+	return fmt.Errorf("can't give access to user %s, it is neither a workspace identity nor in the grantUsers of the operator configuration", user)
+}
+
+// applyGrants sets, on the identity, the grants of every live grant giving it access
+func (r *BucketAccessGrantReconciler) applyGrants(ctx context.Context, identity string, backendRef string) error {
+	grants := &onyxiav1.BucketAccessGrantList{}
+	err := r.List(ctx, grants)
+	if err != nil {
+		return err
+	}
+	s3Grants := []factory.Grant{}
+	for i := range grants.Items {
+		grant := &grants.Items[i]
+		if !grant.DeletionTimestamp.IsZero() || expired(grant) {
+			continue
+		}
+		resolved, err := r.resolve(ctx, grant)
+		if err != nil || resolved.identity != identity || resolved.source.Spec.Bucket.BackendRef != backendRef {
+			continue
+		}
+		bucketname, prefix := bucketLocation(resolved.source)
+		if bucketname == "" {
+			return fmt.Errorf("bucket of workspace %s is not provisioned yet", resolved.source.Name)
+		}
+		s3Grant := factory.Grant{Bucketname: bucketname, Access: grant.Spec.Access}
+		for _, path := range grant.Spec.Paths {
+			key, err := factory.FolderKey(prefix + path)
+			if err != nil {
+				return fmt.Errorf("invalid path of grant %s/%s: %w", grant.Namespace, grant.Name, err)
+			}
+			s3Grant.Paths = append(s3Grant.Paths, key)
+		}
+		s3Grants = append(s3Grants, s3Grant)
+	}
+	s3Client, err := r.S3Clients.Get(ctx, backendRef)
+	if err != nil {
+		return err
+	}
+	err = s3Client.SetGrants(identity, s3Grants)
+	if err != nil {
+		return fmt.Errorf("can't set grants of s3 identity %s: %w", identity, err)
+	}
+	return nil
+}
+
+// grantsForWorkspace requeues the grants of the namespace of the workspace, whose
+// bucket may just have been provisioned
+func (r *BucketAccessGrantReconciler) grantsForWorkspace(workspace client.Object) []reconcile.Request {
+	grants := &onyxiav1.BucketAccessGrantList{}
+	err := r.List(context.Background(), grants, client.InNamespace(workspace.(*onyxiav1.Workspace).Spec.Namespace))
+	if err != nil {
+		log.Log.Error(err, err.Error())
+		return nil
+	}
+	requests := []reconcile.Request{}
+	for _, grant := range grants.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&grant)})
+	}
+	return requests
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *BucketAccessGrantReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&onyxiav1.BucketAccessGrant{}).
+		Watches(&source.Kind{Type: &onyxiav1.Workspace{}}, handler.EnqueueRequestsFromMapFunc(r.grantsForWorkspace)).
+		Complete(r)
+}
@@ -0,0 +1,153 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	onyxiav1 "github.com/inseefrlab/onyxia-onboarding-operator/api/v1"
+	"github.com/inseefrlab/onyxia-onboarding-operator/controllers/s3/factory"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var _ = Describe("BucketAccessGrantReconciler", func() {
+	var k8sClient client.Client
+	var s3Client *factory.MockedS3Client
+	var reconciler *BucketAccessGrantReconciler
+	var grant *onyxiav1.BucketAccessGrant
+
+	BeforeEach(func() {
+		titi := newWorkspace("titi")
+		titi.Status.Bucket.Name = "bucket-titi"
+		tata := newWorkspace("tata")
+		tata.Spec.Bucket.Credentials = &onyxiav1.BucketCredentials{}
+		tata.Status.Identity = &onyxiav1.IdentityStatus{Name: "onyxia-bucket-tata", Bucket: "bucket-tata", SecretName: defaultS3CredentialsSecretName}
+		grant = &onyxiav1.BucketAccessGrant{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "titi", Name: "diffusion"},
+			Spec:       onyxiav1.BucketAccessGrantSpec{Workspace: "tata", Paths: []string{"diffusion"}, Access: factory.GrantRead},
+		}
+		k8sClient = newFakeClient(titi, tata, grant)
+		pool := NewS3ClientPool(k8sClient, &factory.S3Config{S3Provider: "mockedS3Provider"}, nil)
+		s3, err := pool.Get(context.Background(), "")
+		Expect(err).NotTo(HaveOccurred())
+		s3Client = s3.(*factory.MockedS3Client)
+		for _, identity := range []string{"onyxia-bucket-tata", "spark-history"} {
+			_, err = s3Client.CreateIdentity(identity, "bucket-tata", "")
+			Expect(err).NotTo(HaveOccurred())
+		}
+		reconciler = &BucketAccessGrantReconciler{Client: k8sClient, Scheme: scheme.Scheme, S3Clients: pool}
+	})
+
+	reconcile := func() (ctrl.Result, *onyxiav1.BucketAccessGrant) {
+		result, _ := reconciler.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKeyFromObject(grant)})
+		reconciled := &onyxiav1.BucketAccessGrant{}
+		Expect(client.IgnoreNotFound(k8sClient.Get(context.Background(), client.ObjectKeyFromObject(grant), reconciled))).To(Succeed())
+		return result, reconciled
+	}
+
+	update := func(mutate func(*onyxiav1.BucketAccessGrant)) {
+		current := &onyxiav1.BucketAccessGrant{}
+		Expect(k8sClient.Get(context.Background(), client.ObjectKeyFromObject(grant), current)).To(Succeed())
+		mutate(current)
+		Expect(k8sClient.Update(context.Background(), current)).To(Succeed())
+	}
+
+	readGrant := []factory.Grant{{Bucketname: "bucket-titi", Paths: []string{"diffusion/"}, Access: factory.GrantRead}}
+
+	It("gives the identity of the workspace access to the paths", func() {
+		result, reconciled := reconcile()
+		Expect(result.RequeueAfter).To(BeZero())
+		Expect(s3Client.Grants("onyxia-bucket-tata")).To(Equal(readGrant))
+		Expect(reconciled.Status.Identity).To(Equal("onyxia-bucket-tata"))
+		Expect(meta.IsStatusConditionTrue(reconciled.Status.Conditions, "Ready")).To(BeTrue())
+	})
+
+	It("comes back at expiry and revokes the access", func() {
+		update(func(grant *onyxiav1.BucketAccessGrant) {
+			grant.Spec.ExpiresAt = &metav1.Time{Time: time.Now().Add(time.Hour)}
+		})
+		result, _ := reconcile()
+		Expect(result.RequeueAfter).To(BeNumerically("~", time.Hour, time.Minute))
+		Expect(s3Client.Grants("onyxia-bucket-tata")).To(Equal(readGrant))
+
+		update(func(grant *onyxiav1.BucketAccessGrant) {
+			grant.Spec.ExpiresAt = &metav1.Time{Time: time.Now().Add(-time.Minute)}
+		})
+		_, reconciled := reconcile()
+		Expect(s3Client.Grants("onyxia-bucket-tata")).To(BeEmpty())
+		Expect(meta.FindStatusCondition(reconciled.Status.Conditions, "Ready").Reason).To(Equal("Expired"))
+	})
+
+	It("revokes the access of a deleted grant", func() {
+		reconcile()
+		Expect(k8sClient.Delete(context.Background(), grant)).To(Succeed())
+		_, reconciled := reconcile()
+		Expect(s3Client.Grants("onyxia-bucket-tata")).To(BeEmpty())
+		Expect(reconciled.Name).To(BeEmpty())
+	})
+
+	It("lets a grant go once the identity it gave access to is deleted", func() {
+		reconcile()
+		Expect(s3Client.DeleteIdentity("onyxia-bucket-tata", "bucket-tata", "")).To(Succeed())
+		Expect(k8sClient.Delete(context.Background(), grant)).To(Succeed())
+		_, reconciled := reconcile()
+		Expect(reconciled.Name).To(BeEmpty())
+	})
+
+	It("refuses users that are neither workspace identities nor grant users", func() {
+		update(func(grant *onyxiav1.BucketAccessGrant) {
+			grant.Spec.Workspace = ""
+			grant.Spec.User = "admin"
+		})
+		_, reconciled := reconcile()
+		Expect(s3Client.Grants("admin")).To(BeEmpty())
+		Expect(meta.FindStatusCondition(reconciled.Status.Conditions, "Ready").Message).To(ContainSubstring("grantUsers"))
+
+		reconciler.Config.GrantUsers = []string{"spark-history"}
+		update(func(grant *onyxiav1.BucketAccessGrant) { grant.Spec.User = "spark-history" })
+		reconcile()
+		Expect(s3Client.Grants("spark-history")).To(Equal(readGrant))
+
+		update(func(grant *onyxiav1.BucketAccessGrant) { grant.Spec.User = "onyxia-bucket-tata" })
+		reconcile()
+		Expect(s3Client.Grants("onyxia-bucket-tata")).To(Equal(readGrant))
+		Expect(s3Client.Grants("spark-history")).To(BeEmpty())
+	})
+
+	It("revokes the access of a grant whose user is no longer allowed", func() {
+		reconciler.Config.GrantUsers = []string{"spark-history"}
+		update(func(grant *onyxiav1.BucketAccessGrant) {
+			grant.Spec.Workspace = ""
+			grant.Spec.User = "spark-history"
+		})
+		reconcile()
+		Expect(s3Client.Grants("spark-history")).To(Equal(readGrant))
+
+		reconciler.Config.GrantUsers = nil
+		_, reconciled := reconcile()
+		Expect(s3Client.Grants("spark-history")).To(BeEmpty())
+		Expect(reconciled.Status.Identity).To(BeEmpty())
+	})
+})
@@ -0,0 +1,173 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	onyxiav1 "github.com/inseefrlab/onyxia-onboarding-operator/api/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// OperatorConfig holds the defaults applied to every workspace, read from the
+// yaml file given by --config
+type OperatorConfig struct {
+	// lifecycle rules of every bucket, a workspace rule with the same id overrides them
+	DefaultLifecycleRules []onyxiav1.LifecycleRule `json:"defaultLifecycleRules,omitempty"`
+	// CORS rules of every bucket, before the rules of the workspace, e.g. allowing the Onyxia UI origin
+	DefaultCORSRules []onyxiav1.CORSRule `json:"defaultCORSRules,omitempty"`
+	// notification targets workspaces may send bucket events to, by name. The values are
+	// the ARNs of targets registered in the object store, e.g. arn:minio:sqs::pipelines:webhook
+	NotificationTargets map[string]string `json:"notificationTargets,omitempty"`
+	// ClusterRoles bound to the workspace members of each role, admin, editor and viewer,
+	// the admin, edit and view ClusterRoles of kubernetes by default
+	MemberClusterRoles map[string]string `json:"memberClusterRoles,omitempty"`
+	// NetworkPolicies installed in every workspace namespace, unless the workspace turns them off
+	NetworkPolicies []NetworkPolicyTemplate `json:"networkPolicies,omitempty"`
+	// Pod Security Standard of the workspace namespaces that don't set one, none if empty
+	PodSecurity *onyxiav1.PodSecurity `json:"podSecurity,omitempty"`
+	// workspaces, as namespace/name, allowed to ask for the privileged Pod Security Standard.
+	// The approval lives here rather than in an annotation of the workspace: the operator
+	// can't tell who set an annotation, and whoever may edit the workspace could set it.
+	PrivilegedWorkspaces []string `json:"privilegedWorkspaces,omitempty"`
+	// interval between two refreshes of the bucket usage, 5m by default
+	UsageRefreshInterval metav1.Duration `json:"usageRefreshInterval,omitempty"`
+	// percent of the bucket quota above which the QuotaWarning condition is set, 90 by default
+	UsageWarningThreshold int32 `json:"usageWarningThreshold,omitempty"`
+	// when set, workspaces get a folder in this bucket instead of their own bucket,
+	// unless they name another shared bucket
+	SharedBucket string `json:"sharedBucket,omitempty"`
+	// other shared buckets the workspaces may name in bucket.sharedBucket
+	SharedBuckets []string `json:"sharedBuckets,omitempty"`
+	// object store users, other than the workspace identities, BucketAccessGrants may
+	// give access to with spec.user. Never list the users of the operator or of administrators.
+	GrantUsers []string `json:"grantUsers,omitempty"`
+	// ClusterRoles the RoleBindings of WorkspaceAddons may bind, besides the Roles of the
+	// addon. Never list ClusterRoles granting more than the workspace members may get.
+	AddonClusterRoles []string `json:"addonClusterRoles,omitempty"`
+	// objects workspaces may be seeded from, no seeding is allowed if empty
+	SeedSources []SeedSource `json:"seedSources,omitempty"`
+}
+
+// SeedSource is a bucket, or a prefix of a bucket, workspaces may be seeded from
+type SeedSource struct {
+	// name of the S3Backend hosting the bucket, the default backend if empty
+	BackendRef string `json:"backendRef,omitempty"`
+	Bucket     string `json:"bucket"`
+	// prefix the seeded objects must be under, the whole bucket if empty
+	Prefix string `json:"prefix,omitempty"`
+}
+
+// NetworkPolicyTemplate is a NetworkPolicy installed in the workspace namespaces
+type NetworkPolicyTemplate struct {
+	// name of the NetworkPolicy, also used by the workspaces to turn it on or off
+	Name string `json:"name"`
+	// optional templates are only installed in the workspaces turning them on
+	Optional bool                           `json:"optional,omitempty"`
+	Spec     networkingv1.NetworkPolicySpec `json:"spec"`
+}
+
+func (config OperatorConfig) usageRefreshInterval() time.Duration {
+	if config.UsageRefreshInterval.Duration <= 0 {
+		return 5 * time.Minute
+	}
+	return config.UsageRefreshInterval.Duration
+}
+
+func (config OperatorConfig) usageWarningThreshold() int32 {
+	if config.UsageWarningThreshold <= 0 {
+		return 90
+	}
+	return config.UsageWarningThreshold
+}
+
+func (config OperatorConfig) memberClusterRole(role string) string {
+	if clusterRole := config.MemberClusterRoles[role]; clusterRole != "" {
+		return clusterRole
+	}
+	return map[string]string{"admin": "admin", "editor": "edit", "viewer": "view"}[role]
+}
+
+// sharedBucketAllowed reports whether the bucket is a shared bucket of the configuration
+func (config OperatorConfig) sharedBucketAllowed(bucketname string) bool {
+	if bucketname == config.SharedBucket {
+		return true
+	}
+	for _, sharedBucket := range config.SharedBuckets {
+		if bucketname == sharedBucket {
+			return true
+		}
+	}
+	return false
+}
+
+// addonClusterRoleAllowed reports whether the RoleBindings of WorkspaceAddons may bind the ClusterRole
+func (config OperatorConfig) addonClusterRoleAllowed(clusterRole string) bool {
+	for _, allowed := range config.AddonClusterRoles {
+		if clusterRole == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// seedSourceAllowed reports whether the workspace may be seeded from the objects of its seedFrom
+func (config OperatorConfig) seedSourceAllowed(onyxiaWorkspace *onyxiav1.Workspace) bool {
+	seedFrom := onyxiaWorkspace.Spec.Bucket.SeedFrom
+	backendRef := seedFrom.BackendRef
+	if backendRef == "" {
+		backendRef = onyxiaWorkspace.Spec.Bucket.BackendRef
+	}
+	for _, source := range config.SeedSources {
+		if source.BackendRef == backendRef && source.Bucket == seedFrom.Bucket && strings.HasPrefix(seedFrom.Prefix, source.Prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// privilegedWorkspace reports whether the workspace may ask for the privileged Pod Security Standard
+func (config OperatorConfig) privilegedWorkspace(onyxiaWorkspace *onyxiav1.Workspace) bool {
+	for _, workspace := range config.PrivilegedWorkspaces {
+		if workspace == onyxiaWorkspace.Namespace+"/"+onyxiaWorkspace.Name {
+			return true
+		}
+	}
+	return false
+}
+
+// LoadOperatorConfig reads the operator configuration, an empty path gives the empty configuration
+func LoadOperatorConfig(path string) (OperatorConfig, error) {
+	config := OperatorConfig{}
+	if path == "" {
+		return config, nil
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return config, fmt.Errorf("can't read operator configuration: %w", err)
+	}
+	err = yaml.UnmarshalStrict(content, &config)
+	if err != nil {
+		return config, fmt.Errorf("can't parse operator configuration %s: %w", path, err)
+	}
+	return config, nil
+}
@@ -0,0 +1,803 @@
+package factory
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// AWS S3 has no native bucket quota. The AWS provider therefore keeps the
+// configured quota, in bytes and in objects, in the bucket tags below and
+// computes usage by listing the bucket. The quota is not enforced on AWS:
+// uploads are never denied, the operator only reports the QuotaExceeded
+// condition of the workspace when the usage goes over it.
+const (
+	quotaTagKey       = "onyxia.sh/quota"
+	objectQuotaTagKey = "onyxia.sh/object-quota"
+)
+
+// Identities are IAM users with an inline policy restricted to the bucket.
+const identityPolicyName = "onyxia-bucket-access"
+
+// name of the inline policy holding the grants of an iam user
+const grantsPolicyName = "onyxia-grants"
+
+type AwsS3Client struct {
+	s3Config  S3Config
+	client    *s3.S3
+	iamClient *iam.IAM
+}
+
+func (awsS3Client *AwsS3Client) BucketExists(name string) (bool, error) {
+	log.Println("check if bucket " + name + " exists")
+	_, err := awsS3Client.client.HeadBucket(&s3.HeadBucketInput{Bucket: aws.String(name)})
+	if err != nil {
+		if isAwsErrorCode(err, "NotFound", s3.ErrCodeNoSuchBucket) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func (awsS3Client *AwsS3Client) CreateBucket(name string) error {
+	log.Println("create bucket " + name)
+	_, err := awsS3Client.client.CreateBucket(awsS3Client.createBucketInput(name))
+	return err
+}
+
+func (awsS3Client *AwsS3Client) CreateBucketWithObjectLock(name string) error {
+	log.Println("create bucket " + name + " with object lock")
+	input := awsS3Client.createBucketInput(name)
+	input.ObjectLockEnabledForBucket = aws.Bool(true)
+	_, err := awsS3Client.client.CreateBucket(input)
+	return err
+}
+
+func (awsS3Client *AwsS3Client) createBucketInput(name string) *s3.CreateBucketInput {
+	input := &s3.CreateBucketInput{Bucket: aws.String(name)}
+	// us-east-1 is the default location and must not be sent as a constraint
+	if awsS3Client.s3Config.Region != "" && awsS3Client.s3Config.Region != "us-east-1" {
+		input.CreateBucketConfiguration = &s3.CreateBucketConfiguration{
+			LocationConstraint: aws.String(awsS3Client.s3Config.Region),
+		}
+	}
+	return input
+}
+
+func (awsS3Client *AwsS3Client) DeleteBucket(name string) error {
+	log.Println("delete bucket " + name)
+	_, err := awsS3Client.client.DeleteBucket(&s3.DeleteBucketInput{Bucket: aws.String(name)})
+	return err
+}
+
+func (awsS3Client *AwsS3Client) SetQuota(name string, quota Quota) error {
+	log.Println("set quota " + fmt.Sprint(quota.Bytes) + " on bucket " + name)
+	tags, err := awsS3Client.GetBucketTags(name)
+	if err != nil {
+		return err
+	}
+	for key, limit := range map[string]int64{quotaTagKey: quota.Bytes, objectQuotaTagKey: quota.Objects} {
+		if limit > 0 {
+			tags[key] = strconv.FormatInt(limit, 10)
+		} else {
+			delete(tags, key)
+		}
+	}
+	return awsS3Client.SetBucketTags(name, tags)
+}
+
+func (awsS3Client *AwsS3Client) GetQuota(name string) (Quota, error) {
+	log.Println("bucket " + name + " get quota")
+	tags, err := awsS3Client.GetBucketTags(name)
+	if err != nil {
+		return Quota{}, err
+	}
+	quota := Quota{}
+	for key, limit := range map[string]*int64{quotaTagKey: &quota.Bytes, objectQuotaTagKey: &quota.Objects} {
+		value, found := tags[key]
+		if !found {
+			continue
+		}
+		*limit, err = strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return Quota{}, fmt.Errorf("invalid quota tag on bucket %s: %w", name, err)
+		}
+	}
+	return quota, nil
+}
+
+func (awsS3Client *AwsS3Client) CreatePath(bucketname string, name string) error {
+	log.Println("create path " + name + " in bucket " + bucketname)
+	key, err := FolderKey(name)
+	if err != nil {
+		return err
+	}
+	return awsS3Client.PutObject(bucketname, key, nil)
+}
+
+func (awsS3Client *AwsS3Client) PathExists(bucketname string, name string) (bool, error) {
+	log.Println("check if path " + name + " exists in bucket " + bucketname)
+	key, err := FolderKey(name)
+	if err != nil {
+		return false, err
+	}
+	output, err := awsS3Client.client.ListObjectsV2(&s3.ListObjectsV2Input{
+		Bucket:  aws.String(bucketname),
+		Prefix:  aws.String(key),
+		MaxKeys: aws.Int64(1),
+	})
+	if err != nil {
+		return false, err
+	}
+	return len(output.Contents) > 0, nil
+}
+
+func (awsS3Client *AwsS3Client) PutObject(bucketname string, key string, content []byte) error {
+	log.Println("put object " + key + " in bucket " + bucketname)
+	_, err := awsS3Client.client.PutObject(&s3.PutObjectInput{
+		Bucket: aws.String(bucketname),
+		Key:    aws.String(key),
+		Body:   bytes.NewReader(content),
+	})
+	if err != nil {
+		return fmt.Errorf("error on object creation %s %s: %w", bucketname, key, err)
+	}
+	return nil
+}
+
+func (awsS3Client *AwsS3Client) GetObject(bucketname string, key string) ([]byte, error) {
+	output, err := awsS3Client.client.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(bucketname),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("can't get object %s %s: %w", bucketname, key, err)
+	}
+	defer output.Body.Close()
+	content, err := io.ReadAll(output.Body)
+	if err != nil {
+		return nil, fmt.Errorf("can't read object %s %s: %w", bucketname, key, err)
+	}
+	return content, nil
+}
+
+func (awsS3Client *AwsS3Client) OpenObject(bucketname string, key string) (io.ReadCloser, error) {
+	output, err := awsS3Client.client.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(bucketname),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("can't get object %s %s: %w", bucketname, key, err)
+	}
+	return output.Body, nil
+}
+
+// UploadObject uploads the object in parts, PutObject needs a seekable body
+func (awsS3Client *AwsS3Client) UploadObject(bucketname string, key string, reader io.Reader, size int64) error {
+	log.Println("upload object " + key + " in bucket " + bucketname)
+	_, err := s3manager.NewUploaderWithClient(awsS3Client.client).Upload(&s3manager.UploadInput{
+		Bucket: aws.String(bucketname),
+		Key:    aws.String(key),
+		Body:   reader,
+	})
+	if err != nil {
+		return fmt.Errorf("can't upload object %s %s: %w", bucketname, key, err)
+	}
+	return nil
+}
+
+func (awsS3Client *AwsS3Client) ListObjects(bucketname string, prefix string, startAfter string, maxKeys int) ([]Object, error) {
+	objects := []Object{}
+	err := awsS3Client.client.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket:     aws.String(bucketname),
+		Prefix:     aws.String(prefix),
+		StartAfter: aws.String(startAfter),
+		MaxKeys:    aws.Int64(int64(maxKeys)),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, object := range page.Contents {
+			objects = append(objects, Object{Key: aws.StringValue(object.Key), Size: aws.Int64Value(object.Size)})
+			if len(objects) == maxKeys {
+				return false
+			}
+		}
+		return true
+	})
+	return objects, err
+}
+
+func (awsS3Client *AwsS3Client) CopyObject(srcBucketname string, srcKey string, bucketname string, key string) error {
+	log.Println("copy object " + srcBucketname + "/" + srcKey + " to " + bucketname + "/" + key)
+	_, err := awsS3Client.client.CopyObject(&s3.CopyObjectInput{
+		Bucket:     aws.String(bucketname),
+		Key:        aws.String(key),
+		CopySource: aws.String(url.PathEscape(srcBucketname + "/" + srcKey)),
+	})
+	if err != nil {
+		return fmt.Errorf("can't copy object %s %s: %w", srcBucketname, srcKey, err)
+	}
+	return nil
+}
+
+// GetBucketUsage lists the bucket to sum the size of its objects.
+func (awsS3Client *AwsS3Client) GetBucketUsage(name string) (Usage, error) {
+	return awsS3Client.GetPrefixUsage(name, "")
+}
+
+func (awsS3Client *AwsS3Client) GetPrefixUsage(name string, prefix string) (Usage, error) {
+	usage := Usage{}
+	err := awsS3Client.client.ListObjectsV2Pages(&s3.ListObjectsV2Input{Bucket: aws.String(name), Prefix: aws.String(prefix)},
+		func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+			for _, object := range page.Contents {
+				usage.Bytes += aws.Int64Value(object.Size)
+				usage.Objects++
+			}
+			return true
+		})
+	return usage, err
+}
+
+func (awsS3Client *AwsS3Client) GetBucketTags(name string) (map[string]string, error) {
+	tags := map[string]string{}
+	output, err := awsS3Client.client.GetBucketTagging(&s3.GetBucketTaggingInput{Bucket: aws.String(name)})
+	if err != nil {
+		if isAwsErrorCode(err, "NoSuchTagSet") {
+			return tags, nil
+		}
+		return nil, err
+	}
+	for _, tag := range output.TagSet {
+		tags[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+	}
+	return tags, nil
+}
+
+// SetBucketTags replaces the tags of the bucket, including the quota tag
+func (awsS3Client *AwsS3Client) SetBucketTags(name string, tags map[string]string) error {
+	if len(tags) == 0 {
+		_, err := awsS3Client.client.DeleteBucketTagging(&s3.DeleteBucketTaggingInput{Bucket: aws.String(name)})
+		return err
+	}
+	tagSet := []*s3.Tag{}
+	for k, v := range tags {
+		tagSet = append(tagSet, &s3.Tag{Key: aws.String(k), Value: aws.String(v)})
+	}
+	_, err := awsS3Client.client.PutBucketTagging(&s3.PutBucketTaggingInput{
+		Bucket:  aws.String(name),
+		Tagging: &s3.Tagging{TagSet: tagSet},
+	})
+	return err
+}
+
+func (awsS3Client *AwsS3Client) IdentityExists(name string) (bool, error) {
+	log.Println("check if iam user " + name + " exists")
+	_, err := awsS3Client.iamClient.GetUser(&iam.GetUserInput{UserName: aws.String(name)})
+	if err != nil {
+		if isAwsErrorCode(err, iam.ErrCodeNoSuchEntityException) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func (awsS3Client *AwsS3Client) CreateIdentity(name string, bucketname string, prefix string) (S3Credentials, error) {
+	log.Println("create iam user " + name + " for bucket " + bucketname)
+	_, err := awsS3Client.iamClient.CreateUser(&iam.CreateUserInput{UserName: aws.String(name)})
+	if err != nil {
+		return S3Credentials{}, err
+	}
+	_, err = awsS3Client.iamClient.PutUserPolicy(&iam.PutUserPolicyInput{
+		UserName:       aws.String(name),
+		PolicyName:     aws.String(identityPolicyName),
+		PolicyDocument: aws.String(string(identityPolicy(bucketname, prefix, nil))),
+	})
+	if err != nil {
+		return S3Credentials{}, err
+	}
+	return awsS3Client.createAccessKey(name)
+}
+
+// RotateIdentity creates a new access key and removes the previous ones.
+func (awsS3Client *AwsS3Client) RotateIdentity(name string) (S3Credentials, error) {
+	log.Println("rotate access key of iam user " + name)
+	previousKeys, err := awsS3Client.iamClient.ListAccessKeys(&iam.ListAccessKeysInput{UserName: aws.String(name)})
+	if err != nil {
+		return S3Credentials{}, err
+	}
+	oldKeys := previousKeys.AccessKeyMetadata
+	// iam allows two access keys per user, make room for the new one
+	if len(oldKeys) > 1 {
+		err = awsS3Client.deleteAccessKey(name, oldKeys[0].AccessKeyId)
+		if err != nil {
+			return S3Credentials{}, err
+		}
+		oldKeys = oldKeys[1:]
+	}
+	credentials, err := awsS3Client.createAccessKey(name)
+	if err != nil {
+		return S3Credentials{}, err
+	}
+	for _, key := range oldKeys {
+		err = awsS3Client.deleteAccessKey(name, key.AccessKeyId)
+		if err != nil {
+			return S3Credentials{}, err
+		}
+	}
+	return credentials, nil
+}
+
+// DeleteIdentity deletes the iam user, its inline policy goes with it.
+func (awsS3Client *AwsS3Client) DeleteIdentity(name string, bucketname string, prefix string) error {
+	log.Println("delete iam user " + name)
+	keys, err := awsS3Client.iamClient.ListAccessKeys(&iam.ListAccessKeysInput{UserName: aws.String(name)})
+	if err != nil {
+		return err
+	}
+	for _, key := range keys.AccessKeyMetadata {
+		err = awsS3Client.deleteAccessKey(name, key.AccessKeyId)
+		if err != nil {
+			return err
+		}
+	}
+	// the inline policies, the one of its grants included, must go before the user
+	policies, err := awsS3Client.iamClient.ListUserPolicies(&iam.ListUserPoliciesInput{UserName: aws.String(name)})
+	if err != nil {
+		return err
+	}
+	for _, policyName := range policies.PolicyNames {
+		_, err = awsS3Client.iamClient.DeleteUserPolicy(&iam.DeleteUserPolicyInput{
+			UserName:   aws.String(name),
+			PolicyName: policyName,
+		})
+		if err != nil && !isAwsErrorCode(err, iam.ErrCodeNoSuchEntityException) {
+			return err
+		}
+	}
+	_, err = awsS3Client.iamClient.DeleteUser(&iam.DeleteUserInput{UserName: aws.String(name)})
+	return err
+}
+
+// MoveIdentity replaces the inline policy of the iam user.
+func (awsS3Client *AwsS3Client) MoveIdentity(name string, previousBucketname string, previousPrefix string, bucketname string, prefix string) error {
+	log.Println("move iam user " + name + " to bucket " + bucketname + "/" + prefix)
+	_, err := awsS3Client.iamClient.PutUserPolicy(&iam.PutUserPolicyInput{
+		UserName:       aws.String(name),
+		PolicyName:     aws.String(identityPolicyName),
+		PolicyDocument: aws.String(string(identityPolicy(bucketname, prefix, nil))),
+	})
+	return err
+}
+
+func (awsS3Client *AwsS3Client) createAccessKey(name string) (S3Credentials, error) {
+	output, err := awsS3Client.iamClient.CreateAccessKey(&iam.CreateAccessKeyInput{UserName: aws.String(name)})
+	if err != nil {
+		return S3Credentials{}, err
+	}
+	endpoint := "https://s3." + awsS3Client.s3Config.Region + ".amazonaws.com"
+	if !isAwsEndpoint(awsS3Client.s3Config.S3UrlEndpoint) {
+		endpoint = awsS3Client.s3Config.endpointURL()
+	}
+	return S3Credentials{
+		AccessKey: aws.StringValue(output.AccessKey.AccessKeyId),
+		SecretKey: aws.StringValue(output.AccessKey.SecretAccessKey),
+		Endpoint:  endpoint,
+		Region:    awsS3Client.s3Config.Region,
+	}, nil
+}
+
+func (awsS3Client *AwsS3Client) deleteAccessKey(name string, accessKeyID *string) error {
+	_, err := awsS3Client.iamClient.DeleteAccessKey(&iam.DeleteAccessKeyInput{
+		UserName:    aws.String(name),
+		AccessKeyId: accessKeyID,
+	})
+	return err
+}
+
+// SetPathAccess rewrites the path access statements of the bucket policy when they
+// drifted. Identities are resolved to the ARN of their IAM user, groups are expected
+// to be principal ARNs, typically of the roles assumed by the members of a group.
+func (awsS3Client *AwsS3Client) SetPathAccess(bucketname string, prefix string, access []PathAccess) (bool, error) {
+	statements, err := pathAccessStatements(bucketname, prefix, access, func(pathAccess PathAccess) (PolicyPrincipal, error) {
+		principals := PolicyValues{}
+		for _, identity := range pathAccess.Identities {
+			user, err := awsS3Client.iamClient.GetUser(&iam.GetUserInput{UserName: aws.String(identity)})
+			if err != nil {
+				return nil, fmt.Errorf("can't get arn of identity %s: %w", identity, err)
+			}
+			principals = append(principals, aws.StringValue(user.User.Arn))
+		}
+		return PolicyPrincipal{"AWS": append(principals, pathAccess.Groups...)}, nil
+	})
+	if err != nil {
+		return false, err
+	}
+	current := ""
+	output, err := awsS3Client.client.GetBucketPolicy(&s3.GetBucketPolicyInput{Bucket: aws.String(bucketname)})
+	if err != nil && !isAwsErrorCode(err, "NoSuchBucketPolicy") {
+		return false, err
+	}
+	if err == nil {
+		current = aws.StringValue(output.Policy)
+	}
+	policy, changed, err := replaceStatements(current, pathAccessSid(prefix), statements)
+	if err != nil || !changed {
+		return false, err
+	}
+	log.Println("update policy of bucket " + bucketname)
+	if policy == "" {
+		_, err = awsS3Client.client.DeleteBucketPolicy(&s3.DeleteBucketPolicyInput{Bucket: aws.String(bucketname)})
+		return true, err
+	}
+	_, err = awsS3Client.client.PutBucketPolicy(&s3.PutBucketPolicyInput{Bucket: aws.String(bucketname), Policy: aws.String(policy)})
+	return true, err
+}
+
+func (awsS3Client *AwsS3Client) GetVersioning(bucketname string) (string, error) {
+	output, err := awsS3Client.client.GetBucketVersioning(&s3.GetBucketVersioningInput{Bucket: aws.String(bucketname)})
+	if err != nil {
+		return "", err
+	}
+	return aws.StringValue(output.Status), nil
+}
+
+func (awsS3Client *AwsS3Client) SetVersioning(bucketname string, status string) error {
+	log.Println("set versioning " + status + " on bucket " + bucketname)
+	_, err := awsS3Client.client.PutBucketVersioning(&s3.PutBucketVersioningInput{
+		Bucket:                  aws.String(bucketname),
+		VersioningConfiguration: &s3.VersioningConfiguration{Status: aws.String(status)},
+	})
+	return err
+}
+
+func (awsS3Client *AwsS3Client) GetObjectLock(bucketname string) (*ObjectLock, error) {
+	output, err := awsS3Client.client.GetObjectLockConfiguration(&s3.GetObjectLockConfigurationInput{Bucket: aws.String(bucketname)})
+	if err != nil {
+		if isAwsErrorCode(err, "ObjectLockConfigurationNotFoundError") {
+			return nil, nil
+		}
+		return nil, err
+	}
+	configuration := output.ObjectLockConfiguration
+	if configuration == nil || aws.StringValue(configuration.ObjectLockEnabled) != s3.ObjectLockEnabledEnabled {
+		return nil, nil
+	}
+	objectLock := &ObjectLock{}
+	if configuration.Rule != nil && configuration.Rule.DefaultRetention != nil {
+		objectLock.Mode = aws.StringValue(configuration.Rule.DefaultRetention.Mode)
+		objectLock.Days = uint(aws.Int64Value(configuration.Rule.DefaultRetention.Days))
+		objectLock.Years = uint(aws.Int64Value(configuration.Rule.DefaultRetention.Years))
+	}
+	return objectLock, nil
+}
+
+func (awsS3Client *AwsS3Client) SetObjectLock(bucketname string, objectLock ObjectLock) error {
+	log.Println("set object lock " + objectLock.Mode + " on bucket " + bucketname)
+	configuration := &s3.ObjectLockConfiguration{ObjectLockEnabled: aws.String(s3.ObjectLockEnabledEnabled)}
+	if objectLock.Mode != "" {
+		retention := &s3.DefaultRetention{Mode: aws.String(objectLock.Mode)}
+		if objectLock.Years > 0 {
+			retention.Years = aws.Int64(int64(objectLock.Years))
+		} else {
+			retention.Days = aws.Int64(int64(objectLock.Days))
+		}
+		configuration.Rule = &s3.ObjectLockRule{DefaultRetention: retention}
+	}
+	_, err := awsS3Client.client.PutObjectLockConfiguration(&s3.PutObjectLockConfigurationInput{
+		Bucket:                  aws.String(bucketname),
+		ObjectLockConfiguration: configuration,
+	})
+	return err
+}
+
+func (awsS3Client *AwsS3Client) SetLegalHold(bucketname string, status string) error {
+	log.Println("set legal hold " + status + " on objects of bucket " + bucketname)
+	var legalHoldErr error
+	err := awsS3Client.client.ListObjectsV2Pages(&s3.ListObjectsV2Input{Bucket: aws.String(bucketname)},
+		func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+			for _, object := range page.Contents {
+				_, legalHoldErr = awsS3Client.client.PutObjectLegalHold(&s3.PutObjectLegalHoldInput{
+					Bucket:    aws.String(bucketname),
+					Key:       object.Key,
+					LegalHold: &s3.ObjectLockLegalHold{Status: aws.String(status)},
+				})
+				if legalHoldErr != nil {
+					return false
+				}
+			}
+			return true
+		})
+	if err != nil {
+		return err
+	}
+	return legalHoldErr
+}
+
+func (awsS3Client *AwsS3Client) GetLifecycle(bucketname string) ([]LifecycleRule, error) {
+	output, err := awsS3Client.client.GetBucketLifecycleConfiguration(&s3.GetBucketLifecycleConfigurationInput{Bucket: aws.String(bucketname)})
+	if err != nil {
+		if isAwsErrorCode(err, "NoSuchLifecycleConfiguration") {
+			return []LifecycleRule{}, nil
+		}
+		return nil, err
+	}
+	rules := []LifecycleRule{}
+	for _, rule := range output.Rules {
+		lifecycleRule := LifecycleRule{ID: aws.StringValue(rule.ID), Prefix: aws.StringValue(rule.Prefix)}
+		if rule.Filter != nil && rule.Filter.Prefix != nil {
+			lifecycleRule.Prefix = aws.StringValue(rule.Filter.Prefix)
+		}
+		if rule.Expiration != nil {
+			lifecycleRule.ExpirationDays = int(aws.Int64Value(rule.Expiration.Days))
+		}
+		if rule.NoncurrentVersionExpiration != nil {
+			lifecycleRule.NoncurrentVersionExpirationDays = int(aws.Int64Value(rule.NoncurrentVersionExpiration.NoncurrentDays))
+		}
+		if rule.AbortIncompleteMultipartUpload != nil {
+			lifecycleRule.AbortIncompleteMultipartUploadDays = int(aws.Int64Value(rule.AbortIncompleteMultipartUpload.DaysAfterInitiation))
+		}
+		rules = append(rules, lifecycleRule)
+	}
+	return rules, nil
+}
+
+func (awsS3Client *AwsS3Client) SetLifecycle(bucketname string, rules []LifecycleRule) error {
+	log.Println("set lifecycle rules on bucket " + bucketname)
+	if len(rules) == 0 {
+		_, err := awsS3Client.client.DeleteBucketLifecycle(&s3.DeleteBucketLifecycleInput{Bucket: aws.String(bucketname)})
+		return err
+	}
+	configuration := &s3.BucketLifecycleConfiguration{}
+	for _, rule := range rules {
+		lifecycleRule := &s3.LifecycleRule{
+			ID:     aws.String(rule.ID),
+			Status: aws.String(s3.ExpirationStatusEnabled),
+			Filter: &s3.LifecycleRuleFilter{Prefix: aws.String(rule.Prefix)},
+		}
+		if rule.ExpirationDays > 0 {
+			lifecycleRule.Expiration = &s3.LifecycleExpiration{Days: aws.Int64(int64(rule.ExpirationDays))}
+		}
+		if rule.NoncurrentVersionExpirationDays > 0 {
+			lifecycleRule.NoncurrentVersionExpiration = &s3.NoncurrentVersionExpiration{
+				NoncurrentDays: aws.Int64(int64(rule.NoncurrentVersionExpirationDays)),
+			}
+		}
+		if rule.AbortIncompleteMultipartUploadDays > 0 {
+			lifecycleRule.AbortIncompleteMultipartUpload = &s3.AbortIncompleteMultipartUpload{
+				DaysAfterInitiation: aws.Int64(int64(rule.AbortIncompleteMultipartUploadDays)),
+			}
+		}
+		configuration.Rules = append(configuration.Rules, lifecycleRule)
+	}
+	_, err := awsS3Client.client.PutBucketLifecycleConfiguration(&s3.PutBucketLifecycleConfigurationInput{
+		Bucket:                 aws.String(bucketname),
+		LifecycleConfiguration: configuration,
+	})
+	return err
+}
+
+func (awsS3Client *AwsS3Client) GetCORS(bucketname string) ([]CORSRule, error) {
+	return getCORS(awsS3Client.client, bucketname)
+}
+
+func (awsS3Client *AwsS3Client) SetCORS(bucketname string, rules []CORSRule) error {
+	return setCORS(awsS3Client.client, bucketname, rules)
+}
+
+func (awsS3Client *AwsS3Client) GetNotifications(bucketname string) ([]Notification, error) {
+	output, err := awsS3Client.client.GetBucketNotificationConfiguration(&s3.GetBucketNotificationConfigurationRequest{Bucket: aws.String(bucketname)})
+	if err != nil {
+		return nil, err
+	}
+	notifications := []Notification{}
+	add := func(id *string, arn *string, events []*string, filter *s3.NotificationConfigurationFilter) {
+		n := Notification{ID: aws.StringValue(id), TargetARN: aws.StringValue(arn), Events: aws.StringValueSlice(events)}
+		if filter != nil && filter.Key != nil {
+			for _, rule := range filter.Key.FilterRules {
+				n.setFilterRule(aws.StringValue(rule.Name), aws.StringValue(rule.Value))
+			}
+		}
+		notifications = append(notifications, n)
+	}
+	for _, queue := range output.QueueConfigurations {
+		add(queue.Id, queue.QueueArn, queue.Events, queue.Filter)
+	}
+	for _, topic := range output.TopicConfigurations {
+		add(topic.Id, topic.TopicArn, topic.Events, topic.Filter)
+	}
+	for _, lambda := range output.LambdaFunctionConfigurations {
+		add(lambda.Id, lambda.LambdaFunctionArn, lambda.Events, lambda.Filter)
+	}
+	return notifications, nil
+}
+
+func (awsS3Client *AwsS3Client) SetNotifications(bucketname string, notifications []Notification) error {
+	log.Println("set " + fmt.Sprint(len(notifications)) + " notifications on bucket " + bucketname)
+	configuration := &s3.NotificationConfiguration{}
+	for _, n := range notifications {
+		var filter *s3.NotificationConfigurationFilter
+		if n.Prefix != "" || n.Suffix != "" {
+			filter = &s3.NotificationConfigurationFilter{Key: &s3.KeyFilter{}}
+			if n.Prefix != "" {
+				filter.Key.FilterRules = append(filter.Key.FilterRules, &s3.FilterRule{Name: aws.String(s3.FilterRuleNamePrefix), Value: aws.String(n.Prefix)})
+			}
+			if n.Suffix != "" {
+				filter.Key.FilterRules = append(filter.Key.FilterRules, &s3.FilterRule{Name: aws.String(s3.FilterRuleNameSuffix), Value: aws.String(n.Suffix)})
+			}
+		}
+		arn := strings.Split(n.TargetARN, ":")
+		if len(arn) != 6 || arn[0] != "arn" {
+			return fmt.Errorf("invalid target %s of notification %s", n.TargetARN, n.ID)
+		}
+		switch arn[2] {
+		case "sqs":
+			configuration.QueueConfigurations = append(configuration.QueueConfigurations, &s3.QueueConfiguration{
+				Id: aws.String(n.ID), QueueArn: aws.String(n.TargetARN), Events: aws.StringSlice(n.Events), Filter: filter,
+			})
+		case "sns":
+			configuration.TopicConfigurations = append(configuration.TopicConfigurations, &s3.TopicConfiguration{
+				Id: aws.String(n.ID), TopicArn: aws.String(n.TargetARN), Events: aws.StringSlice(n.Events), Filter: filter,
+			})
+		case "lambda":
+			configuration.LambdaFunctionConfigurations = append(configuration.LambdaFunctionConfigurations, &s3.LambdaFunctionConfiguration{
+				Id: aws.String(n.ID), LambdaFunctionArn: aws.String(n.TargetARN), Events: aws.StringSlice(n.Events), Filter: filter,
+			})
+		default:
+			return fmt.Errorf("target %s of notification %s is neither a queue, a topic nor a lambda", n.TargetARN, n.ID)
+		}
+	}
+	_, err := awsS3Client.client.PutBucketNotificationConfiguration(&s3.PutBucketNotificationConfigurationInput{
+		Bucket:                    aws.String(bucketname),
+		NotificationConfiguration: configuration,
+	})
+	return err
+}
+
+func (awsS3Client *AwsS3Client) GetEncryption(bucketname string) (*Encryption, error) {
+	output, err := awsS3Client.client.GetBucketEncryption(&s3.GetBucketEncryptionInput{Bucket: aws.String(bucketname)})
+	if err != nil {
+		if isAwsErrorCode(err, "ServerSideEncryptionConfigurationNotFoundError") {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if output.ServerSideEncryptionConfiguration == nil {
+		return nil, nil
+	}
+	for _, rule := range output.ServerSideEncryptionConfiguration.Rules {
+		if rule.ApplyServerSideEncryptionByDefault == nil {
+			continue
+		}
+		apply := rule.ApplyServerSideEncryptionByDefault
+		if encryption := encryptionOf(aws.StringValue(apply.SSEAlgorithm), aws.StringValue(apply.KMSMasterKeyID)); encryption != nil {
+			return encryption, nil
+		}
+	}
+	return nil, nil
+}
+
+func (awsS3Client *AwsS3Client) SetEncryption(bucketname string, encryption Encryption) error {
+	log.Println("set encryption " + encryption.Type + " on bucket " + bucketname)
+	apply := &s3.ServerSideEncryptionByDefault{SSEAlgorithm: aws.String(s3.ServerSideEncryptionAes256)}
+	if encryption.Type == EncryptionSSEKMS {
+		apply = &s3.ServerSideEncryptionByDefault{
+			SSEAlgorithm:   aws.String(s3.ServerSideEncryptionAwsKms),
+			KMSMasterKeyID: aws.String(encryption.KMSKeyID),
+		}
+	}
+	_, err := awsS3Client.client.PutBucketEncryption(&s3.PutBucketEncryptionInput{
+		Bucket: aws.String(bucketname),
+		ServerSideEncryptionConfiguration: &s3.ServerSideEncryptionConfiguration{
+			Rules: []*s3.ServerSideEncryptionRule{{ApplyServerSideEncryptionByDefault: apply}},
+		},
+	})
+	return err
+}
+
+func (awsS3Client *AwsS3Client) RemoveEncryption(bucketname string) error {
+	log.Println("remove encryption of bucket " + bucketname)
+	_, err := awsS3Client.client.DeleteBucketEncryption(&s3.DeleteBucketEncryptionInput{Bucket: aws.String(bucketname)})
+	return err
+}
+
+// SetGrants puts the grant statements in an inline policy of the IAM user.
+func (awsS3Client *AwsS3Client) SetGrants(identity string, grants []Grant) error {
+	log.Println("set " + fmt.Sprint(len(grants)) + " grants of iam user " + identity)
+	policy, err := grantPolicy(grants)
+	if err != nil {
+		return err
+	}
+	if policy == nil {
+		_, err = awsS3Client.iamClient.DeleteUserPolicy(&iam.DeleteUserPolicyInput{
+			UserName:   aws.String(identity),
+			PolicyName: aws.String(grantsPolicyName),
+		})
+		if err != nil && !isAwsErrorCode(err, iam.ErrCodeNoSuchEntityException) {
+			return err
+		}
+		return nil
+	}
+	_, err = awsS3Client.iamClient.PutUserPolicy(&iam.PutUserPolicyInput{
+		UserName:       aws.String(identity),
+		PolicyName:     aws.String(grantsPolicyName),
+		PolicyDocument: aws.String(string(policy)),
+	})
+	return err
+}
+
+// CreateKMSKey is not supported, AWS KMS generates the ids of its keys
+func (awsS3Client *AwsS3Client) CreateKMSKey(keyID string) error {
+	return fmt.Errorf("can't create kms key %s, aws kms keys must be created in aws kms", keyID)
+}
+
+func isAwsErrorCode(err error, codes ...string) bool {
+	if awsErr, ok := err.(awserr.Error); ok {
+		for _, code := range codes {
+			if awsErr.Code() == code {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isAwsEndpoint reports whether the endpoint is resolved by the SDK to the AWS one
+func isAwsEndpoint(endpoint string) bool {
+	return endpoint == "" || strings.HasSuffix(endpoint, "amazonaws.com")
+}
+
+func newAwsS3Client(S3Config *S3Config) (*AwsS3Client, error) {
+	log.Println("create aws clients")
+	awsSession, err := newAwsSession(S3Config)
+	if err != nil {
+		return nil, err
+	}
+	// iam is a global aws service, it never uses the s3 endpoint
+	iamClient := iam.New(awsSession, aws.NewConfig().WithEndpoint("").WithRegion("us-east-1"))
+	return &AwsS3Client{*S3Config, s3.New(awsSession), iamClient}, nil
+}
+
+// newAwsSession returns an aws sdk session on the s3 endpoint of the configuration
+func newAwsSession(S3Config *S3Config) (*session.Session, error) {
+	transport, err := newTransport(S3Config)
+	if err != nil {
+		return nil, err
+	}
+	awsConfig := aws.NewConfig().
+		WithRegion(S3Config.Region).
+		WithCredentials(credentials.NewStaticCredentials(S3Config.AccessKey, S3Config.SecretKey, ""))
+	// an empty endpoint lets the SDK resolve the regional AWS endpoint, always over TLS.
+	// Anything else (S3-compatible stand-in, VPC endpoint...) is addressed path-style,
+	// over TLS when UseSsl is set.
+	if !isAwsEndpoint(S3Config.S3UrlEndpoint) {
+		endpoint := S3Config.S3UrlEndpoint
+		if !strings.Contains(endpoint, "://") {
+			if S3Config.UseSsl {
+				endpoint = "https://" + endpoint
+			} else {
+				endpoint = "http://" + endpoint
+			}
+		}
+		awsConfig = awsConfig.WithEndpoint(endpoint).WithS3ForcePathStyle(true)
+	}
+	awsSession, err := session.NewSession(awsConfig)
+	if err != nil {
+		return nil, err
+	}
+	// set once the session is built, otherwise AWS_CA_BUNDLE would override the trusted CAs
+	awsSession.Config.HTTPClient = &http.Client{Transport: transport}
+	return awsSession, nil
+}
@@ -0,0 +1,81 @@
+package factory
+
+import (
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/s3"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("AwsS3Client", func() {
+	var standIn *s3StandIn
+	var s3Client S3Client
+
+	BeforeEach(func() {
+		standIn = newS3StandIn()
+		var err error
+		s3Client, err = GetS3Client("aws", &S3Config{
+			S3Provider:    "aws",
+			S3UrlEndpoint: standIn.endpoint(),
+			Region:        "us-east-1",
+			AccessKey:     "access",
+			SecretKey:     "secret",
+		})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		standIn.close()
+	})
+
+	It("creates, finds and deletes buckets", func() {
+		found, err := s3Client.BucketExists("bucket-titi")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(found).To(BeFalse())
+
+		Expect(s3Client.CreateBucket("bucket-titi")).To(Succeed())
+		found, err = s3Client.BucketExists("bucket-titi")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(found).To(BeTrue())
+
+		Expect(s3Client.DeleteBucket("bucket-titi")).To(Succeed())
+		found, err = s3Client.BucketExists("bucket-titi")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(found).To(BeFalse())
+	})
+
+	It("keeps the quota as a bucket tag without dropping other tags", func() {
+		Expect(s3Client.CreateBucket("bucket-titi")).To(Succeed())
+		standIn.buckets["bucket-titi"].tags["owner"] = "titi"
+
+		quota, err := s3Client.GetQuota("bucket-titi")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(quota).To(BeZero())
+
+		Expect(s3Client.SetQuota("bucket-titi", Quota{Bytes: 100000000, Objects: 1000})).To(Succeed())
+		quota, err = s3Client.GetQuota("bucket-titi")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(quota).To(Equal(Quota{Bytes: 100000000, Objects: 1000}))
+
+		Expect(s3Client.SetQuota("bucket-titi", Quota{Bytes: 100000000})).To(Succeed())
+		Expect(standIn.buckets["bucket-titi"].tags).NotTo(HaveKey(objectQuotaTagKey))
+		Expect(standIn.buckets["bucket-titi"].tags).To(HaveKeyWithValue("owner", "titi"))
+	})
+
+	It("tracks usage by listing the bucket", func() {
+		Expect(s3Client.CreateBucket("bucket-titi")).To(Succeed())
+		Expect(s3Client.CreatePath("bucket-titi", "diffusion")).To(Succeed())
+		standIn.buckets["bucket-titi"].objects["diffusion/data.csv"] = make([]byte, 20)
+
+		usage, err := s3Client.GetBucketUsage("bucket-titi")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(usage).To(Equal(Usage{Bytes: 20, Objects: 2}))
+	})
+
+	It("reaches the AWS endpoints over TLS whatever UseSsl", func() {
+		awsSession, err := newAwsSession(&S3Config{S3Provider: "aws", Region: "eu-west-3", AccessKey: "access", SecretKey: "secret"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(s3.New(awsSession).Endpoint).To(Equal("https://s3.eu-west-3.amazonaws.com"))
+		Expect(iam.New(awsSession).Endpoint).To(HavePrefix("https://"))
+	})
+})
@@ -0,0 +1,431 @@
+package factory
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// CephRgwS3Client uses the S3 API of the RADOS Gateway for bucket and object
+// operations and its Admin Ops API for quotas and users, which RGW
+// enforces natively in bytes and in objects.
+type CephRgwS3Client struct {
+	s3Config    S3Config
+	client      minio.Client
+	adminClient *rgwAdminClient
+	corsClient  *s3.S3
+}
+
+func (cephRgwS3Client *CephRgwS3Client) BucketExists(name string) (bool, error) {
+	log.Println("check if bucket " + name + " exists")
+	return cephRgwS3Client.client.BucketExists(context.Background(), name)
+}
+
+func (cephRgwS3Client *CephRgwS3Client) CreateBucket(name string) error {
+	log.Println("create bucket " + name)
+	return cephRgwS3Client.client.MakeBucket(context.Background(), name, minio.MakeBucketOptions{Region: cephRgwS3Client.s3Config.Region})
+}
+
+func (cephRgwS3Client *CephRgwS3Client) DeleteBucket(name string) error {
+	log.Println("delete bucket " + name)
+	return cephRgwS3Client.client.RemoveBucket(context.Background(), name)
+}
+
+// SetQuota sets the size and object count quota of the bucket.
+func (cephRgwS3Client *CephRgwS3Client) SetQuota(name string, quota Quota) error {
+	log.Println("set quota " + fmt.Sprint(quota.Bytes) + " on bucket " + name)
+	rgwQuota := RgwQuota{Enabled: quota.Bytes > 0 || quota.Objects > 0, MaxSize: -1, MaxObjects: -1}
+	if quota.Bytes > 0 {
+		rgwQuota.MaxSize = quota.Bytes
+	}
+	if quota.Objects > 0 {
+		rgwQuota.MaxObjects = quota.Objects
+	}
+	return cephRgwS3Client.SetBucketQuota(name, rgwQuota)
+}
+
+// SetBucketQuota sets the complete RGW quota, size and object count, on the bucket.
+func (cephRgwS3Client *CephRgwS3Client) SetBucketQuota(name string, quota RgwQuota) error {
+	// the admin api needs the owner of the bucket to update its quota
+	stats, err := cephRgwS3Client.adminClient.getBucket(name)
+	if err != nil {
+		return fmt.Errorf("can't get owner of bucket %s: %w", name, err)
+	}
+	return cephRgwS3Client.adminClient.setBucketQuota(stats.Owner, name, quota)
+}
+
+func (cephRgwS3Client *CephRgwS3Client) GetQuota(name string) (Quota, error) {
+	log.Println("bucket " + name + " get quota")
+	rgwQuota, err := cephRgwS3Client.GetBucketQuota(name)
+	if err != nil || !rgwQuota.Enabled {
+		return Quota{}, err
+	}
+	quota := Quota{}
+	if rgwQuota.MaxSize > 0 {
+		quota.Bytes = rgwQuota.MaxSize
+	}
+	if rgwQuota.MaxObjects > 0 {
+		quota.Objects = rgwQuota.MaxObjects
+	}
+	return quota, nil
+}
+
+// GetBucketQuota returns the complete RGW quota of the bucket.
+func (cephRgwS3Client *CephRgwS3Client) GetBucketQuota(name string) (RgwQuota, error) {
+	stats, err := cephRgwS3Client.adminClient.getBucket(name)
+	if err != nil {
+		return RgwQuota{}, err
+	}
+	return stats.BucketQuota, nil
+}
+
+// GetBucketUsage reads the bucket stats of the admin api.
+func (cephRgwS3Client *CephRgwS3Client) GetBucketUsage(bucketname string) (Usage, error) {
+	stats, err := cephRgwS3Client.adminClient.getBucket(bucketname)
+	if err != nil {
+		return Usage{}, err
+	}
+	usage := Usage{}
+	for _, category := range stats.Usage {
+		usage.Bytes += category.SizeActual
+		usage.Objects += category.NumObjects
+	}
+	return usage, nil
+}
+
+func (cephRgwS3Client *CephRgwS3Client) CreatePath(bucketname string, name string) error {
+	log.Println("create path " + name + " in bucket " + bucketname)
+	return createPath(&cephRgwS3Client.client, bucketname, name)
+}
+
+func (cephRgwS3Client *CephRgwS3Client) PathExists(bucketname string, name string) (bool, error) {
+	log.Println("check if path " + name + " exists in bucket " + bucketname)
+	return pathExists(&cephRgwS3Client.client, bucketname, name)
+}
+
+func (cephRgwS3Client *CephRgwS3Client) PutObject(bucketname string, key string, content []byte) error {
+	return putObject(&cephRgwS3Client.client, bucketname, key, content)
+}
+
+func (cephRgwS3Client *CephRgwS3Client) GetObject(bucketname string, key string) ([]byte, error) {
+	return getObject(&cephRgwS3Client.client, bucketname, key)
+}
+
+func (cephRgwS3Client *CephRgwS3Client) OpenObject(bucketname string, key string) (io.ReadCloser, error) {
+	return openObject(&cephRgwS3Client.client, bucketname, key)
+}
+
+func (cephRgwS3Client *CephRgwS3Client) UploadObject(bucketname string, key string, reader io.Reader, size int64) error {
+	return uploadObject(&cephRgwS3Client.client, bucketname, key, reader, size)
+}
+
+func (cephRgwS3Client *CephRgwS3Client) ListObjects(bucketname string, prefix string, startAfter string, maxKeys int) ([]Object, error) {
+	return listObjects(&cephRgwS3Client.client, bucketname, prefix, startAfter, maxKeys)
+}
+
+func (cephRgwS3Client *CephRgwS3Client) CopyObject(srcBucketname string, srcKey string, bucketname string, key string) error {
+	return copyObject(&cephRgwS3Client.client, srcBucketname, srcKey, bucketname, key)
+}
+
+func (cephRgwS3Client *CephRgwS3Client) GetPrefixUsage(bucketname string, prefix string) (Usage, error) {
+	return prefixUsage(&cephRgwS3Client.client, bucketname, prefix)
+}
+
+// UserExists reports whether the RGW user exists.
+func (cephRgwS3Client *CephRgwS3Client) UserExists(uid string) (bool, error) {
+	_, err := cephRgwS3Client.adminClient.getUser(uid)
+	if isRgwNotFound(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// CreateUser creates a RGW user and returns it with its generated keys.
+func (cephRgwS3Client *CephRgwS3Client) CreateUser(uid string, displayName string) (*RgwUser, error) {
+	log.Println("create rgw user " + uid)
+	return cephRgwS3Client.adminClient.createUser(uid, displayName)
+}
+
+// DeleteUser removes a RGW user, its buckets are kept.
+func (cephRgwS3Client *CephRgwS3Client) DeleteUser(uid string) error {
+	log.Println("delete rgw user " + uid)
+	return cephRgwS3Client.adminClient.deleteUser(uid)
+}
+
+// SetUserQuota sets the quota applying to all the buckets of a RGW user.
+func (cephRgwS3Client *CephRgwS3Client) SetUserQuota(uid string, quota RgwQuota) error {
+	log.Println("set quota on rgw user " + uid)
+	return cephRgwS3Client.adminClient.setUserQuota(uid, quota)
+}
+
+func (cephRgwS3Client *CephRgwS3Client) IdentityExists(name string) (bool, error) {
+	return cephRgwS3Client.UserExists(name)
+}
+
+// CreateIdentity creates a RGW user allowed on the bucket, or on its prefix, by the bucket policy.
+func (cephRgwS3Client *CephRgwS3Client) CreateIdentity(name string, bucketname string, prefix string) (S3Credentials, error) {
+	user, err := cephRgwS3Client.CreateUser(name, name)
+	if err != nil {
+		return S3Credentials{}, err
+	}
+	if len(user.Keys) == 0 {
+		return S3Credentials{}, fmt.Errorf("rgw user %s was created without key", name)
+	}
+	err = cephRgwS3Client.allowIdentity(name, bucketname, prefix)
+	if err != nil {
+		return S3Credentials{}, err
+	}
+	return cephRgwS3Client.credentials(user.Keys[0]), nil
+}
+
+// allowIdentity adds to the bucket policy the statements allowing the RGW user on
+// the bucket, or on its prefix
+func (cephRgwS3Client *CephRgwS3Client) allowIdentity(name string, bucketname string, prefix string) error {
+	log.Println("allow rgw user " + name + " on bucket " + bucketname + "/" + prefix)
+	// the identity statements share the bucket policy with the path access statements,
+	// and with the statements of the other identities in a shared bucket
+	sid := rgwIdentityStatementSid(prefix)
+	statements := identityStatements(bucketname, prefix, PolicyPrincipal{"AWS": {rgwUserArn(name)}})
+	for i := range statements {
+		statements[i].Sid = sid
+		if len(statements) > 1 {
+			statements[i].Sid = fmt.Sprintf("%s%d", sid, i)
+		}
+	}
+	_, err := cephRgwS3Client.replaceBucketPolicyStatements(bucketname, sid, statements)
+	return err
+}
+
+// RotateIdentity generates a new key pair and removes the previous ones.
+func (cephRgwS3Client *CephRgwS3Client) RotateIdentity(name string) (S3Credentials, error) {
+	log.Println("rotate keys of rgw user " + name)
+	user, err := cephRgwS3Client.adminClient.getUser(name)
+	if err != nil {
+		return S3Credentials{}, err
+	}
+	keys, err := cephRgwS3Client.adminClient.createKey(name)
+	if err != nil {
+		return S3Credentials{}, err
+	}
+	previous := map[string]bool{}
+	for _, key := range user.Keys {
+		previous[key.AccessKey] = true
+		err = cephRgwS3Client.adminClient.removeKey(name, key.AccessKey)
+		if err != nil {
+			return S3Credentials{}, err
+		}
+	}
+	for _, key := range keys {
+		if !previous[key.AccessKey] {
+			return cephRgwS3Client.credentials(key), nil
+		}
+	}
+	return S3Credentials{}, fmt.Errorf("no new key generated for rgw user %s", name)
+}
+
+// DeleteIdentity removes the statements allowing the RGW user from the bucket policy,
+// unless the bucket is already gone, then deletes the user.
+func (cephRgwS3Client *CephRgwS3Client) DeleteIdentity(name string, bucketname string, prefix string) error {
+	log.Println("remove rgw user " + name + " from bucket " + bucketname + "/" + prefix)
+	err := cephRgwS3Client.disallowIdentity(bucketname, prefix)
+	if err != nil {
+		return err
+	}
+	return cephRgwS3Client.DeleteUser(name)
+}
+
+// MoveIdentity moves the statements allowing the RGW user from the policy of its
+// previous bucket to the policy of its new bucket.
+func (cephRgwS3Client *CephRgwS3Client) MoveIdentity(name string, previousBucketname string, previousPrefix string, bucketname string, prefix string) error {
+	log.Println("remove rgw user " + name + " from bucket " + previousBucketname + "/" + previousPrefix)
+	err := cephRgwS3Client.disallowIdentity(previousBucketname, previousPrefix)
+	if err != nil {
+		return err
+	}
+	return cephRgwS3Client.allowIdentity(name, bucketname, prefix)
+}
+
+// disallowIdentity removes the identity statements of the bucket, or of its prefix,
+// from the bucket policy. A deleted bucket has nothing left to remove.
+func (cephRgwS3Client *CephRgwS3Client) disallowIdentity(bucketname string, prefix string) error {
+	_, err := cephRgwS3Client.replaceBucketPolicyStatements(bucketname, rgwIdentityStatementSid(prefix), nil)
+	if err != nil && minio.ToErrorResponse(err).Code != "NoSuchBucket" {
+		return err
+	}
+	return nil
+}
+
+// rgwIdentityStatementSid returns the Sid, or the prefix of the Sids, of the bucket policy
+// statements allowing the identity of the bucket, or of the prefix of a shared bucket
+func rgwIdentityStatementSid(prefix string) string {
+	if prefix == "" {
+		return rgwIdentitySid
+	}
+	return rgwIdentitySid + "/" + prefix
+}
+
+// SetPathAccess rewrites the path access statements of the bucket policy when they
+// drifted, the statement allowing the workspace identity is kept.
+func (cephRgwS3Client *CephRgwS3Client) SetPathAccess(bucketname string, prefix string, access []PathAccess) (bool, error) {
+	statements, err := pathAccessStatements(bucketname, prefix, access, func(pathAccess PathAccess) (PolicyPrincipal, error) {
+		principals := PolicyValues{}
+		for _, identity := range pathAccess.Identities {
+			principals = append(principals, rgwUserArn(identity))
+		}
+		return PolicyPrincipal{"AWS": append(principals, pathAccess.Groups...)}, nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return cephRgwS3Client.replaceBucketPolicyStatements(bucketname, pathAccessSid(prefix), statements)
+}
+
+func (cephRgwS3Client *CephRgwS3Client) replaceBucketPolicyStatements(bucketname string, sidPrefix string, statements []PolicyStatement) (bool, error) {
+	current, err := cephRgwS3Client.client.GetBucketPolicy(context.Background(), bucketname)
+	if err != nil {
+		return false, err
+	}
+	policy, changed, err := replaceStatements(current, sidPrefix, statements)
+	if err != nil || !changed {
+		return false, err
+	}
+	log.Println("update policy of bucket " + bucketname)
+	return true, cephRgwS3Client.client.SetBucketPolicy(context.Background(), bucketname, policy)
+}
+
+func (cephRgwS3Client *CephRgwS3Client) CreateBucketWithObjectLock(name string) error {
+	log.Println("create bucket " + name + " with object lock")
+	return cephRgwS3Client.client.MakeBucket(context.Background(), name, minio.MakeBucketOptions{Region: cephRgwS3Client.s3Config.Region, ObjectLocking: true})
+}
+
+func (cephRgwS3Client *CephRgwS3Client) GetVersioning(bucketname string) (string, error) {
+	return getVersioning(&cephRgwS3Client.client, bucketname)
+}
+
+func (cephRgwS3Client *CephRgwS3Client) SetVersioning(bucketname string, status string) error {
+	return setVersioning(&cephRgwS3Client.client, bucketname, status)
+}
+
+func (cephRgwS3Client *CephRgwS3Client) GetObjectLock(bucketname string) (*ObjectLock, error) {
+	return getObjectLock(&cephRgwS3Client.client, bucketname)
+}
+
+func (cephRgwS3Client *CephRgwS3Client) SetObjectLock(bucketname string, objectLock ObjectLock) error {
+	return setObjectLock(&cephRgwS3Client.client, bucketname, objectLock)
+}
+
+func (cephRgwS3Client *CephRgwS3Client) SetLegalHold(bucketname string, status string) error {
+	return setLegalHold(&cephRgwS3Client.client, bucketname, status)
+}
+
+func (cephRgwS3Client *CephRgwS3Client) GetLifecycle(bucketname string) ([]LifecycleRule, error) {
+	return getLifecycle(&cephRgwS3Client.client, bucketname)
+}
+
+func (cephRgwS3Client *CephRgwS3Client) SetLifecycle(bucketname string, rules []LifecycleRule) error {
+	return setLifecycle(&cephRgwS3Client.client, bucketname, rules)
+}
+
+func (cephRgwS3Client *CephRgwS3Client) GetCORS(bucketname string) ([]CORSRule, error) {
+	return getCORS(cephRgwS3Client.corsClient, bucketname)
+}
+
+func (cephRgwS3Client *CephRgwS3Client) SetCORS(bucketname string, rules []CORSRule) error {
+	return setCORS(cephRgwS3Client.corsClient, bucketname, rules)
+}
+
+func (cephRgwS3Client *CephRgwS3Client) GetNotifications(bucketname string) ([]Notification, error) {
+	return getNotifications(&cephRgwS3Client.client, bucketname)
+}
+
+func (cephRgwS3Client *CephRgwS3Client) SetNotifications(bucketname string, notifications []Notification) error {
+	return setNotifications(&cephRgwS3Client.client, bucketname, notifications)
+}
+
+func (cephRgwS3Client *CephRgwS3Client) GetEncryption(bucketname string) (*Encryption, error) {
+	return getEncryption(&cephRgwS3Client.client, bucketname)
+}
+
+func (cephRgwS3Client *CephRgwS3Client) SetEncryption(bucketname string, encryption Encryption) error {
+	return setEncryption(&cephRgwS3Client.client, bucketname, encryption)
+}
+
+func (cephRgwS3Client *CephRgwS3Client) RemoveEncryption(bucketname string) error {
+	return removeEncryption(&cephRgwS3Client.client, bucketname)
+}
+
+// SetGrants is not supported, RGW identities are only allowed by bucket policies
+func (cephRgwS3Client *CephRgwS3Client) SetGrants(identity string, grants []Grant) error {
+	if len(grants) == 0 {
+		return nil
+	}
+	return fmt.Errorf("can't grant access to rgw user %s, ceph-rgw has no identity policies", identity)
+}
+
+// CreateKMSKey is not supported, RGW keys live in the KMS configured for the gateway (Vault, KMIP...)
+func (cephRgwS3Client *CephRgwS3Client) CreateKMSKey(keyID string) error {
+	return fmt.Errorf("can't create kms key %s, ceph-rgw keys must be created in its kms", keyID)
+}
+
+func (cephRgwS3Client *CephRgwS3Client) GetBucketTags(bucketname string) (map[string]string, error) {
+	return getBucketTags(&cephRgwS3Client.client, bucketname)
+}
+
+func (cephRgwS3Client *CephRgwS3Client) SetBucketTags(bucketname string, tags map[string]string) error {
+	return setBucketTags(&cephRgwS3Client.client, bucketname, tags)
+}
+
+func (cephRgwS3Client *CephRgwS3Client) credentials(key rgwKey) S3Credentials {
+	return S3Credentials{
+		AccessKey: key.AccessKey,
+		SecretKey: key.SecretKey,
+		Endpoint:  cephRgwS3Client.s3Config.endpointURL(),
+		Region:    cephRgwS3Client.s3Config.Region,
+	}
+}
+
+// Sid of the bucket policy statement allowing the workspace identity, followed
+// by the prefix of the workspace in a shared bucket
+const rgwIdentitySid = "OnyxiaIdentity"
+
+func rgwUserArn(uid string) string {
+	return "arn:aws:iam:::user/" + uid
+}
+
+func newCephRgwS3Client(S3Config *S3Config) (*CephRgwS3Client, error) {
+	log.Println("create ceph rgw clients")
+	transport, err := newTransport(S3Config)
+	if err != nil {
+		return nil, err
+	}
+	client, err := minio.New(S3Config.S3UrlEndpoint, &minio.Options{
+		Creds:     credentials.NewStaticV4(S3Config.AccessKey, S3Config.SecretKey, ""),
+		Region:    S3Config.Region,
+		Secure:    S3Config.UseSsl,
+		Transport: transport,
+	})
+	if err != nil {
+		return nil, err
+	}
+	scheme := "http://"
+	if S3Config.UseSsl {
+		scheme = "https://"
+	}
+	adminPath := S3Config.RgwAdminPath
+	if adminPath == "" {
+		adminPath = "admin"
+	}
+	adminClient := newRgwAdminClient(scheme+S3Config.S3UrlEndpoint, adminPath, S3Config.Region,
+		S3Config.AccessKey, S3Config.SecretKey, &http.Client{Transport: transport})
+	corsClient, err := newCORSClient(S3Config)
+	if err != nil {
+		return nil, err
+	}
+	return &CephRgwS3Client{*S3Config, *client, adminClient, corsClient}, nil
+}
@@ -0,0 +1,285 @@
+package factory
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	v4 "github.com/aws/aws-sdk-go/aws/signer/v4"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// rgwStandIn serves the Admin Ops API endpoints used by the ceph-rgw provider
+// and forwards anything else to the S3 stand-in. Every admin request must
+// carry a valid SigV4 signature for the access/secret pair.
+type rgwStandIn struct {
+	s3         *s3StandIn
+	server     *httptest.Server
+	owners     map[string]string
+	quotas     map[string]RgwQuota
+	users      map[string]*RgwUser
+	userQuotas map[string]RgwQuota
+	requests   []*http.Request
+}
+
+func newRgwStandIn() *rgwStandIn {
+	standIn := &rgwStandIn{
+		s3:         newS3StandIn(),
+		owners:     map[string]string{},
+		quotas:     map[string]RgwQuota{},
+		users:      map[string]*RgwUser{},
+		userQuotas: map[string]RgwQuota{},
+	}
+	standIn.server = httptest.NewServer(standIn)
+	return standIn
+}
+
+func (standIn *rgwStandIn) close() {
+	standIn.server.Close()
+	standIn.s3.close()
+}
+
+func (standIn *rgwStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.URL.Path, "/admin/") {
+		standIn.s3.ServeHTTP(w, r)
+		// buckets are owned by the user creating them, the admin account here
+		bucket := strings.Trim(r.URL.Path, "/")
+		if !strings.Contains(bucket, "/") && len(r.URL.RawQuery) == 0 {
+			_, found := standIn.s3.buckets[bucket]
+			if _, owned := standIn.owners[bucket]; found && !owned {
+				standIn.owners[bucket] = "access"
+			} else if !found {
+				delete(standIn.owners, bucket)
+				delete(standIn.quotas, bucket)
+			}
+		}
+		return
+	}
+	body, _ := io.ReadAll(r.Body)
+	if !validRgwSignature(r, body) {
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = io.WriteString(w, `{"Code":"SignatureDoesNotMatch"}`)
+		return
+	}
+	standIn.requests = append(standIn.requests, r)
+	query := r.URL.Query()
+	switch {
+	case r.URL.Path == "/admin/bucket" && r.Method == http.MethodGet:
+		owner, found := standIn.owners[query.Get("bucket")]
+		if !found {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = io.WriteString(w, `{"Code":"NoSuchBucket"}`)
+			return
+		}
+		usage := map[string]int64{"size_actual": 0, "num_objects": 0}
+		if bucket, found := standIn.s3.buckets[query.Get("bucket")]; found {
+			for _, content := range bucket.objects {
+				usage["size_actual"] += int64(len(content))
+				usage["num_objects"]++
+			}
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"bucket":       query.Get("bucket"),
+			"owner":        owner,
+			"bucket_quota": standIn.quotas[query.Get("bucket")],
+			"usage":        map[string]interface{}{"rgw.main": usage},
+		})
+	case r.URL.Path == "/admin/bucket" && r.Method == http.MethodPut && query.Has("quota"):
+		if standIn.owners[query.Get("bucket")] != query.Get("uid") {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		quota := RgwQuota{}
+		_ = json.Unmarshal(body, &quota)
+		standIn.quotas[query.Get("bucket")] = quota
+	case r.URL.Path == "/admin/user" && r.Method == http.MethodPut && query.Has("quota"):
+		quota := RgwQuota{}
+		_ = json.Unmarshal(body, &quota)
+		standIn.userQuotas[query.Get("uid")] = quota
+	case r.URL.Path == "/admin/user" && r.Method == http.MethodPut:
+		user := &RgwUser{UserID: query.Get("uid"), DisplayName: query.Get("display-name")}
+		user.Keys = append(user.Keys, rgwKey{user.UserID, "ak-" + user.UserID, "sk-" + user.UserID})
+		standIn.users[user.UserID] = user
+		_ = json.NewEncoder(w).Encode(user)
+	case r.URL.Path == "/admin/user" && r.Method == http.MethodGet:
+		user, found := standIn.users[query.Get("uid")]
+		if !found {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = io.WriteString(w, `{"Code":"NoSuchUser"}`)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(user)
+	case r.URL.Path == "/admin/user" && r.Method == http.MethodDelete:
+		delete(standIn.users, query.Get("uid"))
+	default:
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+// validRgwSignature signs again the received request with the expected
+// credentials at the received date and compares both signatures.
+func validRgwSignature(r *http.Request, body []byte) bool {
+	authorization := r.Header.Get("Authorization")
+	if !strings.HasPrefix(authorization, "AWS4-HMAC-SHA256 Credential=access/") {
+		return false
+	}
+	signTime, err := time.Parse("20060102T150405Z", r.Header.Get("X-Amz-Date"))
+	if err != nil {
+		return false
+	}
+	resigned, _ := http.NewRequest(r.Method, "http://"+r.Host+r.URL.RequestURI(), nil)
+	var bodyReader io.ReadSeeker
+	if len(body) > 0 {
+		resigned.Header.Set("Content-Type", r.Header.Get("Content-Type"))
+		bodyReader = bytes.NewReader(body)
+	}
+	signer := v4.NewSigner(credentials.NewStaticCredentials("access", "secret", ""))
+	_, err = signer.Sign(resigned, bodyReader, "s3", "us-east-1", signTime)
+	return err == nil && resigned.Header.Get("Authorization") == authorization
+}
+
+var _ = Describe("CephRgwS3Client", func() {
+	var standIn *rgwStandIn
+	var cephRgwS3Client *CephRgwS3Client
+
+	BeforeEach(func() {
+		standIn = newRgwStandIn()
+		s3Client, err := GetS3Client("ceph-rgw", &S3Config{
+			S3Provider:    "ceph-rgw",
+			S3UrlEndpoint: strings.TrimPrefix(standIn.server.URL, "http://"),
+			Region:        "us-east-1",
+			AccessKey:     "access",
+			SecretKey:     "secret",
+		})
+		Expect(err).NotTo(HaveOccurred())
+		cephRgwS3Client = s3Client.(*CephRgwS3Client)
+	})
+
+	AfterEach(func() {
+		standIn.close()
+	})
+
+	It("rejects admin requests signed with other credentials", func() {
+		other := newRgwAdminClient(standIn.server.URL, "admin", "us-east-1", "access", "wrong", http.DefaultClient)
+		_, err := other.getUser("titi")
+		Expect(err).To(HaveOccurred())
+		Expect(err.(*rgwError).StatusCode).To(Equal(http.StatusForbidden))
+	})
+
+	It("sets and gets bucket quotas through the admin api", func() {
+		Expect(cephRgwS3Client.CreateBucket("bucket-titi")).To(Succeed())
+		standIn.owners["bucket-titi"] = "titi"
+
+		Expect(cephRgwS3Client.SetQuota("bucket-titi", Quota{Bytes: 100000000})).To(Succeed())
+		quota, err := cephRgwS3Client.GetQuota("bucket-titi")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(quota).To(Equal(Quota{Bytes: 100000000}))
+
+		Expect(cephRgwS3Client.SetQuota("bucket-titi", Quota{Bytes: 100000000, Objects: 1000})).To(Succeed())
+		Expect(standIn.quotas["bucket-titi"]).To(Equal(RgwQuota{Enabled: true, MaxSize: 100000000, MaxObjects: 1000}))
+
+		Expect(cephRgwS3Client.SetBucketQuota("bucket-titi", RgwQuota{Enabled: true, MaxSize: 2048, MaxObjects: 10})).To(Succeed())
+		Expect(standIn.quotas["bucket-titi"]).To(Equal(RgwQuota{Enabled: true, MaxSize: 2048, MaxObjects: 10}))
+		Expect(standIn.requests[len(standIn.requests)-1].URL.RawQuery).To(HavePrefix("bucket=bucket-titi&format=json&quota=&uid=titi"))
+	})
+
+	It("reports unlimited quotas as zero", func() {
+		standIn.owners["bucket-titi"] = "titi"
+		standIn.quotas["bucket-titi"] = RgwQuota{Enabled: false, MaxSize: -1, MaxObjects: -1}
+		quota, err := cephRgwS3Client.GetQuota("bucket-titi")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(quota).To(BeZero())
+	})
+
+	It("reports bucket usage from the bucket stats", func() {
+		Expect(cephRgwS3Client.CreateBucket("bucket-titi")).To(Succeed())
+		standIn.owners["bucket-titi"] = "titi"
+		standIn.s3.buckets["bucket-titi"].objects["diffusion/data.csv"] = make([]byte, 20)
+
+		usage, err := cephRgwS3Client.GetBucketUsage("bucket-titi")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(usage).To(Equal(Usage{Bytes: 20, Objects: 1}))
+	})
+
+	It("allows identities on their prefix of a shared bucket", func() {
+		Expect(cephRgwS3Client.CreateBucket("bucket-shared")).To(Succeed())
+		_, err := cephRgwS3Client.CreateIdentity("titi", "bucket-shared", "titi/")
+		Expect(err).NotTo(HaveOccurred())
+		_, err = cephRgwS3Client.CreateIdentity("toto", "bucket-shared", "toto/")
+		Expect(err).NotTo(HaveOccurred())
+
+		document := PolicyDocument{}
+		Expect(json.Unmarshal([]byte(standIn.s3.buckets["bucket-shared"].policy), &document)).To(Succeed())
+		sids := []string{}
+		for _, statement := range document.Statement {
+			sids = append(sids, statement.Sid)
+		}
+		Expect(sids).To(ConsistOf("OnyxiaIdentity/titi/0", "OnyxiaIdentity/titi/1", "OnyxiaIdentity/toto/0", "OnyxiaIdentity/toto/1"))
+		Expect(document.Statement[1].Principal).To(Equal(PolicyPrincipal{"AWS": {"arn:aws:iam:::user/titi"}}))
+	})
+
+	It("removes deleted identities from the bucket policy", func() {
+		Expect(cephRgwS3Client.CreateBucket("bucket-shared")).To(Succeed())
+		_, err := cephRgwS3Client.CreateIdentity("titi", "bucket-shared", "titi/")
+		Expect(err).NotTo(HaveOccurred())
+		_, err = cephRgwS3Client.CreateIdentity("toto", "bucket-shared", "toto/")
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(cephRgwS3Client.DeleteIdentity("titi", "bucket-shared", "titi/")).To(Succeed())
+		Expect(standIn.users).NotTo(HaveKey("titi"))
+		document := PolicyDocument{}
+		Expect(json.Unmarshal([]byte(standIn.s3.buckets["bucket-shared"].policy), &document)).To(Succeed())
+		for _, statement := range document.Statement {
+			Expect(statement.Sid).To(HavePrefix("OnyxiaIdentity/toto/"))
+		}
+
+		Expect(cephRgwS3Client.DeleteIdentity("toto", "bucket-shared", "toto/")).To(Succeed())
+		Expect(standIn.s3.buckets["bucket-shared"].policy).To(BeEmpty())
+
+		_, err = cephRgwS3Client.CreateUser("tata", "tata")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cephRgwS3Client.DeleteIdentity("tata", "bucket-gone", "")).To(Succeed())
+		Expect(standIn.users).NotTo(HaveKey("tata"))
+	})
+
+	It("moves identities to the policy of their new bucket", func() {
+		Expect(cephRgwS3Client.CreateBucket("bucket-titi")).To(Succeed())
+		Expect(cephRgwS3Client.CreateBucket("bucket-shared")).To(Succeed())
+		_, err := cephRgwS3Client.CreateIdentity("titi", "bucket-titi", "")
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(cephRgwS3Client.MoveIdentity("titi", "bucket-titi", "", "bucket-shared", "titi/")).To(Succeed())
+		Expect(standIn.s3.buckets["bucket-titi"].policy).To(BeEmpty())
+		document := PolicyDocument{}
+		Expect(json.Unmarshal([]byte(standIn.s3.buckets["bucket-shared"].policy), &document)).To(Succeed())
+		Expect(document.Statement).NotTo(BeEmpty())
+		for _, statement := range document.Statement {
+			Expect(statement.Sid).To(HavePrefix("OnyxiaIdentity/titi/"))
+		}
+	})
+
+	It("manages rgw users", func() {
+		found, err := cephRgwS3Client.UserExists("titi")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(found).To(BeFalse())
+
+		user, err := cephRgwS3Client.CreateUser("titi", "Titi")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(user.Keys).To(HaveLen(1))
+		Expect(user.Keys[0].AccessKey).To(Equal("ak-titi"))
+
+		Expect(cephRgwS3Client.SetUserQuota("titi", RgwQuota{Enabled: true, MaxSize: 4096, MaxObjects: -1})).To(Succeed())
+		Expect(standIn.userQuotas["titi"].MaxSize).To(Equal(int64(4096)))
+
+		Expect(cephRgwS3Client.DeleteUser("titi")).To(Succeed())
+		found, err = cephRgwS3Client.UserExists("titi")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(found).To(BeFalse())
+	})
+})
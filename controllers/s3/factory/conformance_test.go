@@ -0,0 +1,304 @@
+package factory
+
+import (
+	"errors"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// describeConformance checks the behaviour every S3Client implementation must
+// share. newS3Client returns a client on an empty object store and the
+// function releasing it.
+func describeConformance(provider string, newS3Client func() (S3Client, func())) bool {
+	return Describe("S3Client conformance of "+provider, func() {
+		var s3Client S3Client
+		var release func()
+
+		BeforeEach(func() {
+			s3Client, release = newS3Client()
+		})
+
+		AfterEach(func() {
+			release()
+		})
+
+		It("creates, finds and deletes buckets", func() {
+			found, err := s3Client.BucketExists("bucket-titi")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(found).To(BeFalse())
+
+			Expect(s3Client.CreateBucket("bucket-titi")).To(Succeed())
+			found, err = s3Client.BucketExists("bucket-titi")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(found).To(BeTrue())
+			Expect(s3Client.CreateBucket("bucket-titi")).NotTo(Succeed())
+
+			Expect(s3Client.DeleteBucket("bucket-titi")).To(Succeed())
+			found, err = s3Client.BucketExists("bucket-titi")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(found).To(BeFalse())
+			Expect(s3Client.DeleteBucket("bucket-titi")).NotTo(Succeed())
+		})
+
+		It("creates folders and keeps buckets holding them", func() {
+			Expect(s3Client.CreateBucket("bucket-titi")).To(Succeed())
+			found, err := s3Client.PathExists("bucket-titi", "diffusion")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(found).To(BeFalse())
+
+			Expect(s3Client.CreatePath("bucket-titi", "diffusion/2023")).To(Succeed())
+			for _, path := range []string{"diffusion", "diffusion/", "/diffusion/2023"} {
+				found, err = s3Client.PathExists("bucket-titi", path)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(found).To(BeTrue(), path)
+			}
+			found, err = s3Client.PathExists("bucket-titi", "diffusion/2024")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(found).To(BeFalse())
+			Expect(s3Client.DeleteBucket("bucket-titi")).NotTo(Succeed())
+
+			Expect(s3Client.CreatePath("bucket-titi", "diffusion/../sensible")).NotTo(Succeed())
+			Expect(s3Client.CreatePath("bucket-missing", "diffusion")).NotTo(Succeed())
+		})
+
+		It("sets and gets size quotas", func() {
+			Expect(s3Client.CreateBucket("bucket-titi")).To(Succeed())
+			Expect(s3Client.SetQuota("bucket-titi", Quota{Bytes: 53687091200})).To(Succeed())
+			quota, err := s3Client.GetQuota("bucket-titi")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(quota).To(Equal(Quota{Bytes: 53687091200}))
+
+			Expect(s3Client.SetQuota("bucket-titi", Quota{})).To(Succeed())
+			quota, err = s3Client.GetQuota("bucket-titi")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(quota).To(BeZero())
+
+			Expect(s3Client.SetQuota("bucket-missing", Quota{Bytes: 1024})).NotTo(Succeed())
+		})
+
+		It("replaces bucket tags", func() {
+			Expect(s3Client.CreateBucket("bucket-titi")).To(Succeed())
+			tags, err := s3Client.GetBucketTags("bucket-titi")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(tags).To(BeEmpty())
+
+			Expect(s3Client.SetBucketTags("bucket-titi", map[string]string{"onyxia.sh/workspace": "titi", "onyxia.sh/class": "gold"})).To(Succeed())
+			Expect(s3Client.SetBucketTags("bucket-titi", map[string]string{"onyxia.sh/workspace": "titi"})).To(Succeed())
+			tags, err = s3Client.GetBucketTags("bucket-titi")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(tags).To(Equal(map[string]string{"onyxia.sh/workspace": "titi"}))
+
+			Expect(s3Client.SetBucketTags("bucket-titi", map[string]string{})).To(Succeed())
+			tags, err = s3Client.GetBucketTags("bucket-titi")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(tags).To(BeEmpty())
+		})
+
+		It("rewrites the bucket policy only when the path access changed", func() {
+			access := []PathAccess{{Path: "diffusion", Mode: AccessPublicRead}, {Path: "sensible", Mode: AccessPrivate}}
+			Expect(s3Client.CreateBucket("bucket-titi")).To(Succeed())
+
+			changed, err := s3Client.SetPathAccess("bucket-titi", "", access)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(changed).To(BeTrue())
+			changed, err = s3Client.SetPathAccess("bucket-titi", "", access)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(changed).To(BeFalse())
+
+			changed, err = s3Client.SetPathAccess("bucket-titi", "", nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(changed).To(BeTrue())
+			changed, err = s3Client.SetPathAccess("bucket-titi", "", nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(changed).To(BeFalse())
+		})
+
+		It("keeps the path access of the workspaces sharing a bucket apart", func() {
+			Expect(s3Client.CreateBucket("bucket-shared")).To(Succeed())
+			titi := []PathAccess{{Path: "diffusion", Mode: AccessPublicRead}}
+			toto := []PathAccess{{Path: "", Mode: AccessPublicRead}}
+
+			changed, err := s3Client.SetPathAccess("bucket-shared", "titi/", titi)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(changed).To(BeTrue())
+			changed, err = s3Client.SetPathAccess("bucket-shared", "toto/", toto)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(changed).To(BeTrue())
+			changed, err = s3Client.SetPathAccess("bucket-shared", "titi/", titi)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(changed).To(BeFalse())
+		})
+
+		It("reports the usage of a prefix", func() {
+			Expect(s3Client.CreateBucket("bucket-shared")).To(Succeed())
+			Expect(s3Client.PutObject("bucket-shared", "titi/data.csv", make([]byte, 20))).To(Succeed())
+			Expect(s3Client.PutObject("bucket-shared", "toto/data.csv", make([]byte, 30))).To(Succeed())
+
+			usage, err := s3Client.GetPrefixUsage("bucket-shared", "titi/")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(usage).To(Equal(Usage{Bytes: 20, Objects: 1}))
+		})
+
+		It("lists, reads, streams and copies objects", func() {
+			Expect(s3Client.CreateBucket("bucket-datasets")).To(Succeed())
+			Expect(s3Client.CreateBucket("bucket-titi")).To(Succeed())
+			for _, key := range []string{"courses/a.csv", "courses/b.csv", "courses/c.csv", "other.csv"} {
+				Expect(s3Client.PutObject("bucket-datasets", key, []byte(key))).To(Succeed())
+			}
+
+			objects, err := s3Client.ListObjects("bucket-datasets", "courses/", "", 2)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(objects).To(Equal([]Object{{Key: "courses/a.csv", Size: 13}, {Key: "courses/b.csv", Size: 13}}))
+			objects, err = s3Client.ListObjects("bucket-datasets", "courses/", "courses/b.csv", 2)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(objects).To(Equal([]Object{{Key: "courses/c.csv", Size: 13}}))
+
+			Expect(s3Client.CopyObject("bucket-datasets", "courses/a.csv", "bucket-titi", "diffusion/a.csv")).To(Succeed())
+			content, err := s3Client.GetObject("bucket-titi", "diffusion/a.csv")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(content)).To(Equal("courses/a.csv"))
+
+			reader, err := s3Client.OpenObject("bucket-datasets", "courses/b.csv")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(s3Client.UploadObject("bucket-titi", "diffusion/b.csv", reader, 13)).To(Succeed())
+			Expect(reader.Close()).To(Succeed())
+			content, err = s3Client.GetObject("bucket-titi", "diffusion/b.csv")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(content)).To(Equal("courses/b.csv"))
+
+			Expect(s3Client.CopyObject("bucket-datasets", "courses/missing.csv", "bucket-titi", "diffusion/missing.csv")).NotTo(Succeed())
+			_, err = s3Client.GetObject("bucket-titi", "diffusion/missing.csv")
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("replaces lifecycle rules", func() {
+			Expect(s3Client.CreateBucket("bucket-titi")).To(Succeed())
+			rules := []LifecycleRule{{ID: "tmp", Prefix: "tmp/", ExpirationDays: 30}}
+			Expect(s3Client.SetLifecycle("bucket-titi", rules)).To(Succeed())
+			current, err := s3Client.GetLifecycle("bucket-titi")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(current).To(Equal(rules))
+
+			Expect(s3Client.SetLifecycle("bucket-titi", nil)).To(Succeed())
+			current, err = s3Client.GetLifecycle("bucket-titi")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(current).To(BeEmpty())
+		})
+
+		It("replaces event notifications", func() {
+			Expect(s3Client.CreateBucket("bucket-titi")).To(Succeed())
+			current, err := s3Client.GetNotifications("bucket-titi")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(current).To(BeEmpty())
+
+			notifications := []Notification{
+				{ID: "pipeline", TargetARN: "arn:minio:sqs::pipelines:webhook", Events: []string{"s3:ObjectCreated:*"},
+					Prefix: "diffusion/", Suffix: ".csv"},
+				{ID: "audit", TargetARN: "arn:aws:sns:us-east-1:123456789012:audit", Events: []string{"s3:ObjectRemoved:*", "s3:ObjectCreated:Put"}},
+			}
+			Expect(s3Client.SetNotifications("bucket-titi", notifications)).To(Succeed())
+			current, err = s3Client.GetNotifications("bucket-titi")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(SortNotifications(current)).To(Equal(SortNotifications(notifications)))
+
+			Expect(s3Client.SetNotifications("bucket-titi", nil)).To(Succeed())
+			current, err = s3Client.GetNotifications("bucket-titi")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(current).To(BeEmpty())
+		})
+
+		It("revokes the grants of an identity that no longer exists", func() {
+			if provider == "aws" {
+				Skip("iam is not served by the stand-in")
+			}
+			grants := []Grant{{Bucketname: "bucket-titi", Paths: []string{"diffusion/"}, Access: GrantRead}}
+			Expect(s3Client.SetGrants("tata", nil)).To(Succeed())
+			Expect(s3Client.SetGrants("tata", grants)).NotTo(Succeed())
+		})
+
+		It("replaces CORS rules", func() {
+			Expect(s3Client.CreateBucket("bucket-titi")).To(Succeed())
+			current, err := s3Client.GetCORS("bucket-titi")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(current).To(BeEmpty())
+
+			rules := []CORSRule{
+				{AllowedOrigins: []string{"https://datalab.example.com"}, AllowedMethods: []string{"GET", "PUT"},
+					AllowedHeaders: []string{"*"}, ExposeHeaders: []string{"ETag"}, MaxAgeSeconds: 3600},
+				{AllowedOrigins: []string{"*"}, AllowedMethods: []string{"GET"}},
+			}
+			Expect(s3Client.SetCORS("bucket-titi", rules)).To(Succeed())
+			current, err = s3Client.GetCORS("bucket-titi")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(current).To(Equal(rules))
+
+			Expect(s3Client.SetCORS("bucket-titi", nil)).To(Succeed())
+			current, err = s3Client.GetCORS("bucket-titi")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(current).To(BeEmpty())
+		})
+	})
+}
+
+var _ = describeConformance("the in-memory fake", func() (S3Client, func()) {
+	return newMockedS3Client(), func() {}
+})
+
+var _ = describeConformance("minio", func() (S3Client, func()) {
+	standIn := newS3StandIn()
+	s3Client, err := GetS3Client("minio", &S3Config{S3Provider: "minio", S3UrlEndpoint: standIn.endpoint(),
+		Region: "us-east-1", AccessKey: "access", SecretKey: "secret"})
+	Expect(err).NotTo(HaveOccurred())
+	return s3Client, standIn.close
+})
+
+var _ = describeConformance("aws", func() (S3Client, func()) {
+	standIn := newS3StandIn()
+	s3Client, err := GetS3Client("aws", &S3Config{S3Provider: "aws", S3UrlEndpoint: standIn.endpoint(),
+		Region: "us-east-1", AccessKey: "access", SecretKey: "secret"})
+	Expect(err).NotTo(HaveOccurred())
+	return s3Client, standIn.close
+})
+
+var _ = describeConformance("ceph-rgw", func() (S3Client, func()) {
+	standIn := newRgwStandIn()
+	s3Client, err := GetS3Client("ceph-rgw", &S3Config{S3Provider: "ceph-rgw", S3UrlEndpoint: strings.TrimPrefix(standIn.server.URL, "http://"),
+		Region: "us-east-1", AccessKey: "access", SecretKey: "secret"})
+	Expect(err).NotTo(HaveOccurred())
+	return s3Client, standIn.close
+})
+
+var _ = Describe("MockedS3Client", func() {
+	It("fails the methods given a failure", func() {
+		mockedS3Client := newMockedS3Client()
+		Expect(mockedS3Client.CreateBucket("bucket-titi")).To(Succeed())
+		mockedS3Client.Failures["SetQuota"] = errors.New("quota backend unavailable")
+
+		Expect(mockedS3Client.SetQuota("bucket-titi", Quota{Bytes: 1024})).To(MatchError("quota backend unavailable"))
+		quota, err := mockedS3Client.GetQuota("bucket-titi")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(quota).To(BeZero())
+
+		delete(mockedS3Client.Failures, "SetQuota")
+		Expect(mockedS3Client.SetQuota("bucket-titi", Quota{Bytes: 1024})).To(Succeed())
+	})
+
+	It("stores objects and the object lock of buckets", func() {
+		mockedS3Client := newMockedS3Client()
+		Expect(mockedS3Client.CreateBucketWithObjectLock("bucket-titi")).To(Succeed())
+		Expect(mockedS3Client.CreatePath("bucket-titi", "sensible")).To(Succeed())
+		Expect(mockedS3Client.PutObject("bucket-titi", "diffusion/README.md", []byte("# diffusion"))).To(Succeed())
+		Expect(mockedS3Client.Objects("bucket-titi")).To(Equal(map[string]string{"sensible/": "", "diffusion/README.md": "# diffusion"}))
+		usage, err := mockedS3Client.GetBucketUsage("bucket-titi")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(usage).To(Equal(Usage{Bytes: 11, Objects: 2}))
+
+		Expect(mockedS3Client.SetVersioning("bucket-titi", VersioningSuspended)).NotTo(Succeed())
+		Expect(mockedS3Client.SetObjectLock("bucket-titi", ObjectLock{Mode: "GOVERNANCE", Days: 7})).To(Succeed())
+		objectLock, err := mockedS3Client.GetObjectLock("bucket-titi")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(objectLock).To(Equal(&ObjectLock{Mode: "GOVERNANCE", Days: 7}))
+	})
+})
@@ -0,0 +1,87 @@
+package factory
+
+import (
+	"log"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// CORSRule allows web pages of the origins to send the methods to the bucket from the browser
+type CORSRule struct {
+	AllowedOrigins []string
+	// GET, PUT, POST, DELETE or HEAD
+	AllowedMethods []string
+	// request headers allowed in preflight requests, * allows any header
+	AllowedHeaders []string
+	// response headers the browser lets scripts read, e.g. ETag
+	ExposeHeaders []string
+	// seconds the browser caches the preflight response, not sent if 0
+	MaxAgeSeconds int
+}
+
+// minio-go has no bucket CORS API, the providers relying on it apply the rules
+// with an aws sdk s3 client on the same endpoint, built by newCORSClient.
+func newCORSClient(S3Config *S3Config) (*s3.S3, error) {
+	awsSession, err := newAwsSession(S3Config)
+	if err != nil {
+		return nil, err
+	}
+	return s3.New(awsSession), nil
+}
+
+func getCORS(client *s3.S3, bucketname string) ([]CORSRule, error) {
+	output, err := client.GetBucketCors(&s3.GetBucketCorsInput{Bucket: aws.String(bucketname)})
+	if err != nil {
+		if isAwsErrorCode(err, "NoSuchCORSConfiguration") {
+			return []CORSRule{}, nil
+		}
+		return nil, err
+	}
+	rules := []CORSRule{}
+	for _, rule := range output.CORSRules {
+		corsRule := CORSRule{
+			AllowedOrigins: aws.StringValueSlice(rule.AllowedOrigins),
+			AllowedMethods: aws.StringValueSlice(rule.AllowedMethods),
+			MaxAgeSeconds:  int(aws.Int64Value(rule.MaxAgeSeconds)),
+		}
+		if len(rule.AllowedHeaders) > 0 {
+			corsRule.AllowedHeaders = aws.StringValueSlice(rule.AllowedHeaders)
+		}
+		if len(rule.ExposeHeaders) > 0 {
+			corsRule.ExposeHeaders = aws.StringValueSlice(rule.ExposeHeaders)
+		}
+		rules = append(rules, corsRule)
+	}
+	return rules, nil
+}
+
+func setCORS(client *s3.S3, bucketname string, rules []CORSRule) error {
+	log.Println("set cors rules on bucket " + bucketname)
+	if len(rules) == 0 {
+		_, err := client.DeleteBucketCors(&s3.DeleteBucketCorsInput{Bucket: aws.String(bucketname)})
+		return err
+	}
+	configuration := &s3.CORSConfiguration{}
+	for _, rule := range rules {
+		corsRule := &s3.CORSRule{
+			AllowedOrigins: aws.StringSlice(rule.AllowedOrigins),
+			AllowedMethods: aws.StringSlice(rule.AllowedMethods),
+		}
+		if len(rule.AllowedHeaders) > 0 {
+			corsRule.AllowedHeaders = aws.StringSlice(rule.AllowedHeaders)
+		}
+		if len(rule.ExposeHeaders) > 0 {
+			corsRule.ExposeHeaders = aws.StringSlice(rule.ExposeHeaders)
+		}
+		if rule.MaxAgeSeconds > 0 {
+			corsRule.MaxAgeSeconds = aws.Int64(int64(rule.MaxAgeSeconds))
+		}
+		configuration.CORSRules = append(configuration.CORSRules, corsRule)
+	}
+	_, err := client.PutBucketCors(&s3.PutBucketCorsInput{
+		Bucket:            aws.String(bucketname),
+		CORSConfiguration: configuration,
+	})
+	return err
+}
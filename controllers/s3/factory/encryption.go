@@ -0,0 +1,67 @@
+package factory
+
+import (
+	"context"
+	"log"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/sse"
+)
+
+const (
+	// default encryption of the bucket objects, with keys managed by the object store
+	EncryptionSSES3 = "SSE-S3"
+	// default encryption of the bucket objects, with a key of the KMS
+	EncryptionSSEKMS = "SSE-KMS"
+
+	sseAlgorithmAES256 = "AES256"
+	sseAlgorithmKMS    = "aws:kms"
+)
+
+// Encryption is the default server-side encryption of a bucket
+type Encryption struct {
+	Type string
+	// KMS key, SSE-KMS only
+	KMSKeyID string
+}
+
+// encryptionOf returns the encryption matching a S3 SSE algorithm, nil if unknown
+func encryptionOf(algorithm string, kmsKeyID string) *Encryption {
+	switch algorithm {
+	case sseAlgorithmAES256:
+		return &Encryption{Type: EncryptionSSES3}
+	case sseAlgorithmKMS:
+		return &Encryption{Type: EncryptionSSEKMS, KMSKeyID: kmsKeyID}
+	}
+	return nil
+}
+
+func getEncryption(client *minio.Client, bucketname string) (*Encryption, error) {
+	configuration, err := client.GetBucketEncryption(context.Background(), bucketname)
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "ServerSideEncryptionConfigurationNotFoundError" {
+			return nil, nil
+		}
+		return nil, err
+	}
+	for _, rule := range configuration.Rules {
+		if encryption := encryptionOf(rule.Apply.SSEAlgorithm, rule.Apply.KmsMasterKeyID); encryption != nil {
+			return encryption, nil
+		}
+	}
+	return nil, nil
+}
+
+func setEncryption(client *minio.Client, bucketname string, encryption Encryption) error {
+	log.Println("set encryption " + encryption.Type + " on bucket " + bucketname)
+	configuration := sse.NewConfigurationSSES3()
+	if encryption.Type == EncryptionSSEKMS {
+		configuration = sse.NewConfigurationSSEKMS(encryption.KMSKeyID)
+	}
+	return client.SetBucketEncryption(context.Background(), bucketname, configuration)
+}
+
+func removeEncryption(client *minio.Client, bucketname string) error {
+	log.Println("remove encryption of bucket " + bucketname)
+	return client.RemoveBucketEncryption(context.Background(), bucketname)
+}
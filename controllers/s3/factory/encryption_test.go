@@ -0,0 +1,48 @@
+package factory
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Encryption", func() {
+	var standIn *s3StandIn
+
+	BeforeEach(func() {
+		standIn = newS3StandIn()
+	})
+
+	AfterEach(func() {
+		standIn.close()
+	})
+
+	for _, provider := range []string{"minio", "aws"} {
+		provider := provider
+
+		It("sets and reads the default encryption using "+provider, func() {
+			s3Client, err := GetS3Client(provider, &S3Config{S3Provider: provider, S3UrlEndpoint: standIn.endpoint(),
+				Region: "us-east-1", AccessKey: "access", SecretKey: "secret"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(s3Client.CreateBucket("bucket-titi")).To(Succeed())
+
+			encryption, err := s3Client.GetEncryption("bucket-titi")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(encryption).To(BeNil())
+
+			Expect(s3Client.SetEncryption("bucket-titi", Encryption{Type: EncryptionSSES3})).To(Succeed())
+			encryption, err = s3Client.GetEncryption("bucket-titi")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(encryption).To(Equal(&Encryption{Type: EncryptionSSES3}))
+
+			Expect(s3Client.SetEncryption("bucket-titi", Encryption{Type: EncryptionSSEKMS, KMSKeyID: "projet-titi"})).To(Succeed())
+			encryption, err = s3Client.GetEncryption("bucket-titi")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(encryption).To(Equal(&Encryption{Type: EncryptionSSEKMS, KMSKeyID: "projet-titi"}))
+
+			Expect(s3Client.RemoveEncryption("bucket-titi")).To(Succeed())
+			encryption, err = s3Client.GetEncryption("bucket-titi")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(encryption).To(BeNil())
+		})
+	}
+})
@@ -0,0 +1,68 @@
+package factory
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+const (
+	// access levels of a grant
+	GrantRead      = "read"
+	GrantReadWrite = "read-write"
+
+	// Sid prefix of the identity policy statements generated from the grants
+	grantSidPrefix = "OnyxiaGrant"
+)
+
+// Grant gives an identity access to folders of the bucket of another workspace
+type Grant struct {
+	Bucketname string
+	// keys of the granted folders, ending with a slash
+	Paths  []string
+	Access string
+}
+
+// grantPolicy turns the grants of an identity into an identity policy
+func grantPolicy(grants []Grant) ([]byte, error) {
+	statements := []PolicyStatement{}
+	for i, grant := range grants {
+		var actions PolicyValues
+		switch grant.Access {
+		case GrantRead, "":
+			actions = PolicyValues{"s3:GetObject"}
+		case GrantReadWrite:
+			actions = PolicyValues{"s3:DeleteObject", "s3:GetObject", "s3:PutObject"}
+		default:
+			return nil, fmt.Errorf("unknown access level %s for a grant on bucket %s", grant.Access, grant.Bucketname)
+		}
+		prefixes := PolicyValues{}
+		objects := PolicyValues{}
+		for _, path := range grant.Paths {
+			if !strings.HasSuffix(path, "/") {
+				return nil, fmt.Errorf("invalid granted folder %s of bucket %s", path, grant.Bucketname)
+			}
+			prefixes = append(prefixes, path+"*")
+			objects = append(objects, "arn:aws:s3:::"+grant.Bucketname+"/"+path+"*")
+		}
+		if len(objects) == 0 {
+			continue
+		}
+		statements = append(statements, PolicyStatement{
+			Sid:       fmt.Sprintf("%sList%d", grantSidPrefix, i),
+			Effect:    "Allow",
+			Action:    PolicyValues{"s3:ListBucket"},
+			Resource:  PolicyValues{"arn:aws:s3:::" + grant.Bucketname},
+			Condition: map[string]map[string]PolicyValues{"StringLike": {"s3:prefix": prefixes}},
+		}, PolicyStatement{
+			Sid:      fmt.Sprintf("%sObjects%d", grantSidPrefix, i),
+			Effect:   "Allow",
+			Action:   actions,
+			Resource: objects,
+		})
+	}
+	if len(statements) == 0 {
+		return nil, nil
+	}
+	return json.Marshal(PolicyDocument{Version: "2012-10-17", Statement: statements})
+}
@@ -1,17 +1,146 @@
 package factory
 
 import (
+	"errors"
 	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
 )
 
+const (
+	// names of the files read in S3Config.CredentialsPath, the keys of a mounted secret
+	AccessKeyFileName = "accessKey"
+	SecretKeyFileName = "secretKey"
+)
+
+// ErrUnsupported is wrapped by the errors of the settings the object store can't
+// apply. The other settings of the call are applied, so callers may report the
+// error instead of failing.
+var ErrUnsupported = errors.New("unsupported by the object store")
+
 type S3Client interface {
 	BucketExists(name string) (bool, error)
 	CreateBucket(name string) error
 	DeleteBucket(name string) error
-	SetQuota(name string, quota int64) error
-	GetQuota(name string) (int64, error)
+	// SetQuota sets the hard quota of the bucket, a zero limit is unlimited. Limits
+	// the object store doesn't have are reported with ErrUnsupported.
+	SetQuota(name string, quota Quota) error
+	GetQuota(name string) (Quota, error)
+	// CreatePath creates the folder marker of the path, nested folders are separated by slashes
 	CreatePath(bucketname string, name string) error
-	PathExists(bucketname string, name string) error
+	// PathExists reports whether the folder of the path holds any object, its marker included
+	PathExists(bucketname string, name string) (bool, error)
+	PutObject(bucketname string, key string, content []byte) error
+	GetObject(bucketname string, key string) ([]byte, error)
+	// OpenObject returns a reader of the object content, which the caller closes
+	OpenObject(bucketname string, key string) (io.ReadCloser, error)
+	// UploadObject writes the size bytes of the reader to the object, without
+	// holding the whole content in memory
+	UploadObject(bucketname string, key string, reader io.Reader, size int64) error
+	// ListObjects lists, in key order, at most maxKeys objects under the prefix
+	// whose key comes after startAfter
+	ListObjects(bucketname string, prefix string, startAfter string, maxKeys int) ([]Object, error)
+	// CopyObject copies the object server-side, within the object store
+	CopyObject(srcBucketname string, srcKey string, bucketname string, key string) error
+	// identities are users or service accounts only allowed on one bucket,
+	// or on one prefix of a shared bucket when the prefix is not empty
+	IdentityExists(name string) (bool, error)
+	CreateIdentity(name string, bucketname string, prefix string) (S3Credentials, error)
+	RotateIdentity(name string) (S3Credentials, error)
+	// DeleteIdentity deletes the identity and what allows it on its bucket, or on its
+	// prefix of a shared bucket
+	DeleteIdentity(name string, bucketname string, prefix string) error
+	// MoveIdentity allows the identity on its new bucket, or prefix of a shared bucket,
+	// instead of the previous one. Its keys are kept.
+	MoveIdentity(name string, previousBucketname string, previousPrefix string, bucketname string, prefix string) error
+	// SetGrants replaces the grants of the identity, which may also be a user not
+	// managed by the operator. No grant revokes them all, which succeeds when the
+	// identity no longer exists.
+	SetGrants(identity string, grants []Grant) error
+	// SetPathAccess turns the access modes of the paths into the bucket policy,
+	// rewriting it only when it drifted, which is reported. Paths are relative to
+	// the prefix of the workspace in a shared bucket, if any. Groups the object
+	// store can't allow are left out and reported with ErrUnsupported.
+	SetPathAccess(bucketname string, prefix string, access []PathAccess) (bool, error)
+	// versioning status is Enabled, Suspended, or empty when it was never enabled
+	GetVersioning(bucketname string) (string, error)
+	SetVersioning(bucketname string, status string) error
+	// object lock can only be enabled when the bucket is created
+	CreateBucketWithObjectLock(name string) error
+	// GetObjectLock returns nil when object lock is not enabled on the bucket
+	GetObjectLock(bucketname string) (*ObjectLock, error)
+	SetObjectLock(bucketname string, objectLock ObjectLock) error
+	// SetLegalHold puts (ON) or releases (OFF) the legal hold of every object of the bucket
+	SetLegalHold(bucketname string, status string) error
+	GetLifecycle(bucketname string) ([]LifecycleRule, error)
+	// SetLifecycle replaces the lifecycle rules of the bucket, no rule removes them
+	SetLifecycle(bucketname string, rules []LifecycleRule) error
+	GetCORS(bucketname string) ([]CORSRule, error)
+	// SetCORS replaces the CORS rules of the bucket, no rule removes them
+	SetCORS(bucketname string, rules []CORSRule) error
+	GetNotifications(bucketname string) ([]Notification, error)
+	// SetNotifications replaces the event notifications of the bucket, whose targets
+	// must be registered in the object store. No notification removes them.
+	SetNotifications(bucketname string, notifications []Notification) error
+	// GetEncryption returns nil when the bucket has no default encryption
+	GetEncryption(bucketname string) (*Encryption, error)
+	SetEncryption(bucketname string, encryption Encryption) error
+	// RemoveEncryption removes the default encryption of the bucket
+	RemoveEncryption(bucketname string) error
+	// CreateKMSKey creates the key in the KMS of the object store, unless it exists
+	CreateKMSKey(keyID string) error
+	GetBucketTags(bucketname string) (map[string]string, error)
+	// SetBucketTags replaces all the tags of the bucket
+	SetBucketTags(bucketname string, tags map[string]string) error
+	GetBucketUsage(bucketname string) (Usage, error)
+	// GetPrefixUsage lists the objects under the prefix to sum their size
+	GetPrefixUsage(bucketname string, prefix string) (Usage, error)
+}
+
+// Object is an object listed in a bucket
+type Object struct {
+	Key  string
+	Size int64
+}
+
+// Usage is the space used by a bucket
+type Usage struct {
+	Bytes   int64
+	Objects int64
+}
+
+const (
+	VersioningEnabled   = "Enabled"
+	VersioningSuspended = "Suspended"
+	LegalHoldOn         = "ON"
+	LegalHoldOff        = "OFF"
+)
+
+// ObjectLock is the object lock configuration of a bucket
+type ObjectLock struct {
+	// retention mode applied by default to new objects, GOVERNANCE or COMPLIANCE,
+	// no default retention if empty
+	Mode string
+	// default retention period, in days or in years
+	Days  uint
+	Years uint
+}
+
+// Quota limits the size and the number of objects of a bucket, zero is unlimited
+type Quota struct {
+	Bytes   int64
+	Objects int64
+}
+
+// S3Credentials are the keys of an identity and where to use them
+type S3Credentials struct {
+	AccessKey string
+	SecretKey string
+	// url of the s3 api
+	Endpoint string
+	Region   string
 }
 
 type S3Config struct {
@@ -21,6 +150,41 @@ type S3Config struct {
 	AccessKey     string
 	SecretKey     string
 	UseSsl        bool
+	TLS           TLSConfig
+	// path of the RADOS Gateway Admin Ops API, ceph-rgw only
+	RgwAdminPath string
+	// directory holding the accessKey and secretKey files, typically a mounted secret.
+	// When set, LoadCredentials overrides AccessKey and SecretKey with their content.
+	CredentialsPath string
+}
+
+// endpointURL returns the url of the s3 api, with its scheme
+func (s3Config *S3Config) endpointURL() string {
+	if strings.Contains(s3Config.S3UrlEndpoint, "://") {
+		return s3Config.S3UrlEndpoint
+	}
+	if s3Config.UseSsl {
+		return "https://" + s3Config.S3UrlEndpoint
+	}
+	return "http://" + s3Config.S3UrlEndpoint
+}
+
+// LoadCredentials reads AccessKey and SecretKey from CredentialsPath, if any.
+func (s3Config *S3Config) LoadCredentials() error {
+	if s3Config.CredentialsPath == "" {
+		return nil
+	}
+	accessKey, err := os.ReadFile(filepath.Join(s3Config.CredentialsPath, AccessKeyFileName))
+	if err != nil {
+		return fmt.Errorf("can't read s3 access key: %w", err)
+	}
+	secretKey, err := os.ReadFile(filepath.Join(s3Config.CredentialsPath, SecretKeyFileName))
+	if err != nil {
+		return fmt.Errorf("can't read s3 secret key: %w", err)
+	}
+	s3Config.AccessKey = strings.TrimSpace(string(accessKey))
+	s3Config.SecretKey = strings.TrimSpace(string(secretKey))
+	return nil
 }
 
 func GetS3Client(s3Provider string, S3Config *S3Config) (S3Client, error) {
@@ -28,7 +192,25 @@ func GetS3Client(s3Provider string, S3Config *S3Config) (S3Client, error) {
 		return newMockedS3Client(), nil
 	}
 	if s3Provider == "minio" {
-		return newMinioS3Client(S3Config), nil
+		minioS3Client, err := newMinioS3Client(S3Config)
+		if err != nil {
+			return nil, err
+		}
+		return minioS3Client, nil
+	}
+	if s3Provider == "aws" {
+		awsS3Client, err := newAwsS3Client(S3Config)
+		if err != nil {
+			return nil, err
+		}
+		return awsS3Client, nil
+	}
+	if s3Provider == "ceph-rgw" {
+		cephRgwS3Client, err := newCephRgwS3Client(S3Config)
+		if err != nil {
+			return nil, err
+		}
+		return cephRgwS3Client, nil
 	}
 	//todo others
 	return nil, fmt.Errorf("s3 provider " + s3Provider + "not supported")
@@ -0,0 +1,34 @@
+package factory
+
+import (
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("S3Config", func() {
+	It("keeps the given credentials without credentials path", func() {
+		s3Config := &S3Config{AccessKey: "access", SecretKey: "secret"}
+		Expect(s3Config.LoadCredentials()).To(Succeed())
+		Expect(s3Config.AccessKey).To(Equal("access"))
+		Expect(s3Config.SecretKey).To(Equal("secret"))
+	})
+
+	It("reads the credentials of a mounted secret", func() {
+		dir := GinkgoT().TempDir()
+		Expect(os.WriteFile(filepath.Join(dir, AccessKeyFileName), []byte("mounted-access\n"), 0600)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(dir, SecretKeyFileName), []byte("mounted-secret"), 0600)).To(Succeed())
+
+		s3Config := &S3Config{AccessKey: "access", SecretKey: "secret", CredentialsPath: dir}
+		Expect(s3Config.LoadCredentials()).To(Succeed())
+		Expect(s3Config.AccessKey).To(Equal("mounted-access"))
+		Expect(s3Config.SecretKey).To(Equal("mounted-secret"))
+	})
+
+	It("fails when a credentials file is missing", func() {
+		s3Config := &S3Config{CredentialsPath: GinkgoT().TempDir()}
+		Expect(s3Config.LoadCredentials()).NotTo(Succeed())
+	})
+})
@@ -0,0 +1,74 @@
+package factory
+
+import (
+	"context"
+	"log"
+	"sort"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/lifecycle"
+)
+
+// LifecycleRule expires the objects under a prefix, a zero number of days disables an action
+type LifecycleRule struct {
+	ID     string
+	Prefix string
+	// days after which current objects expire
+	ExpirationDays int
+	// days after which noncurrent versions expire
+	NoncurrentVersionExpirationDays int
+	// days after which incomplete multipart uploads are aborted
+	AbortIncompleteMultipartUploadDays int
+}
+
+// SortLifecycleRules sorts rules by ID, to compare rule sets
+func SortLifecycleRules(rules []LifecycleRule) []LifecycleRule {
+	sorted := append([]LifecycleRule{}, rules...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].ID < sorted[j].ID })
+	return sorted
+}
+
+func getLifecycle(client *minio.Client, bucketname string) ([]LifecycleRule, error) {
+	configuration, err := client.GetBucketLifecycle(context.Background(), bucketname)
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchLifecycleConfiguration" {
+			return []LifecycleRule{}, nil
+		}
+		return nil, err
+	}
+	rules := []LifecycleRule{}
+	for _, rule := range configuration.Rules {
+		prefix := rule.RuleFilter.Prefix
+		if prefix == "" {
+			prefix = rule.Prefix
+		}
+		rules = append(rules, LifecycleRule{
+			ID:                                 rule.ID,
+			Prefix:                             prefix,
+			ExpirationDays:                     int(rule.Expiration.Days),
+			NoncurrentVersionExpirationDays:    int(rule.NoncurrentVersionExpiration.NoncurrentDays),
+			AbortIncompleteMultipartUploadDays: int(rule.AbortIncompleteMultipartUpload.DaysAfterInitiation),
+		})
+	}
+	return rules, nil
+}
+
+func setLifecycle(client *minio.Client, bucketname string, rules []LifecycleRule) error {
+	log.Println("set lifecycle rules on bucket " + bucketname)
+	configuration := lifecycle.NewConfiguration()
+	for _, rule := range rules {
+		configuration.Rules = append(configuration.Rules, lifecycle.Rule{
+			ID:         rule.ID,
+			Status:     "Enabled",
+			RuleFilter: lifecycle.Filter{Prefix: rule.Prefix},
+			Expiration: lifecycle.Expiration{Days: lifecycle.ExpirationDays(rule.ExpirationDays)},
+			NoncurrentVersionExpiration: lifecycle.NoncurrentVersionExpiration{
+				NoncurrentDays: lifecycle.ExpirationDays(rule.NoncurrentVersionExpirationDays),
+			},
+			AbortIncompleteMultipartUpload: lifecycle.AbortIncompleteMultipartUpload{
+				DaysAfterInitiation: lifecycle.ExpirationDays(rule.AbortIncompleteMultipartUploadDays),
+			},
+		})
+	}
+	return client.SetBucketLifecycle(context.Background(), bucketname, configuration)
+}
@@ -0,0 +1,49 @@
+package factory
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Lifecycle", func() {
+	var standIn *s3StandIn
+
+	BeforeEach(func() {
+		standIn = newS3StandIn()
+	})
+
+	AfterEach(func() {
+		standIn.close()
+	})
+
+	rules := []LifecycleRule{
+		{ID: "tmp", Prefix: "tmp/", ExpirationDays: 30},
+		{ID: "versions", NoncurrentVersionExpirationDays: 90},
+		{ID: "uploads", AbortIncompleteMultipartUploadDays: 7},
+	}
+
+	for _, provider := range []string{"minio", "aws"} {
+		provider := provider
+
+		It("sets, reads and removes lifecycle rules using "+provider, func() {
+			s3Client, err := GetS3Client(provider, &S3Config{S3Provider: provider, S3UrlEndpoint: standIn.endpoint(),
+				Region: "us-east-1", AccessKey: "access", SecretKey: "secret"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(s3Client.CreateBucket("bucket-titi")).To(Succeed())
+
+			current, err := s3Client.GetLifecycle("bucket-titi")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(current).To(BeEmpty())
+
+			Expect(s3Client.SetLifecycle("bucket-titi", rules)).To(Succeed())
+			current, err = s3Client.GetLifecycle("bucket-titi")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(SortLifecycleRules(current)).To(Equal(SortLifecycleRules(rules)))
+
+			Expect(s3Client.SetLifecycle("bucket-titi", nil)).To(Succeed())
+			current, err = s3Client.GetLifecycle("bucket-titi")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(current).To(BeEmpty())
+		})
+	}
+})
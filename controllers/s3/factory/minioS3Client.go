@@ -1,11 +1,13 @@
 package factory
 
 import (
-	"bytes"
 	"context"
 	"fmt"
+	"io"
 	"log"
+	"strings"
 
+	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/minio/madmin-go/v2"
 	"github.com/minio/minio-go/v7"
 	"github.com/minio/minio-go/v7/pkg/credentials"
@@ -15,6 +17,7 @@ type MinioS3Client struct {
 	s3Config    S3Config
 	client      minio.Client
 	adminClient madmin.AdminClient
+	corsClient  *s3.S3
 }
 
 func (minioS3Client *MinioS3Client) BucketExists(name string) (bool, error) {
@@ -22,13 +25,13 @@ func (minioS3Client *MinioS3Client) BucketExists(name string) (bool, error) {
 	return minioS3Client.client.BucketExists(context.Background(), name)
 }
 
-func (minioS3Client *MinioS3Client) GetQuota(name string) (int64, error) {
+func (minioS3Client *MinioS3Client) GetQuota(name string) (Quota, error) {
 	log.Println("bucket " + name + " get quota")
 	bucketQuota, err := minioS3Client.adminClient.GetBucketQuota(context.Background(), name)
 	if err != nil {
-		log.Fatalln(err)
+		return Quota{}, err
 	}
-	return int64(bucketQuota.Quota), err
+	return Quota{Bytes: int64(bucketQuota.Quota)}, nil
 }
 
 func (minioS3Client *MinioS3Client) CreateBucket(name string) error {
@@ -37,56 +40,346 @@ func (minioS3Client *MinioS3Client) CreateBucket(name string) error {
 }
 
 func (minioS3Client *MinioS3Client) CreatePath(bucketname string, name string) error {
-	log.Println("create path " + name + "in bucket" + bucketname)
-	emptyReader := bytes.NewReader([]byte(""))
-	_, err := minioS3Client.client.PutObject(context.Background(), bucketname, name, emptyReader, 0, minio.PutObjectOptions{})
+	log.Println("create path " + name + " in bucket " + bucketname)
+	return createPath(&minioS3Client.client, bucketname, name)
+}
+
+func (minioS3Client *MinioS3Client) PathExists(bucketname string, name string) (bool, error) {
+	log.Println("check if path " + name + " exists in bucket " + bucketname)
+	return pathExists(&minioS3Client.client, bucketname, name)
+}
+
+func (minioS3Client *MinioS3Client) PutObject(bucketname string, key string, content []byte) error {
+	return putObject(&minioS3Client.client, bucketname, key, content)
+}
+
+func (minioS3Client *MinioS3Client) GetObject(bucketname string, key string) ([]byte, error) {
+	return getObject(&minioS3Client.client, bucketname, key)
+}
+
+func (minioS3Client *MinioS3Client) OpenObject(bucketname string, key string) (io.ReadCloser, error) {
+	return openObject(&minioS3Client.client, bucketname, key)
+}
+
+func (minioS3Client *MinioS3Client) UploadObject(bucketname string, key string, reader io.Reader, size int64) error {
+	return uploadObject(&minioS3Client.client, bucketname, key, reader, size)
+}
+
+func (minioS3Client *MinioS3Client) ListObjects(bucketname string, prefix string, startAfter string, maxKeys int) ([]Object, error) {
+	return listObjects(&minioS3Client.client, bucketname, prefix, startAfter, maxKeys)
+}
+
+func (minioS3Client *MinioS3Client) CopyObject(srcBucketname string, srcKey string, bucketname string, key string) error {
+	return copyObject(&minioS3Client.client, srcBucketname, srcKey, bucketname, key)
+}
+
+func (minioS3Client *MinioS3Client) DeleteBucket(name string) error {
+	log.Println("delete bucket " + name + "exists")
+	return minioS3Client.client.RemoveBucket(context.Background(), name)
+}
+
+// SetQuota sets a hard size quota when it differs. MinIO has no object count quota,
+// which is reported with ErrUnsupported once the size quota is set.
+func (minioS3Client *MinioS3Client) SetQuota(name string, quota Quota) error {
+	current, err := minioS3Client.GetQuota(name)
 	if err != nil {
-		fmt.Println(err)
-		return fmt.Errorf("error on path creation" + bucketname + " " + name)
+		return err
+	}
+	if current.Bytes != quota.Bytes {
+		log.Println("set quota " + fmt.Sprint(quota.Bytes) + " on bucket " + name)
+		err = minioS3Client.adminClient.SetBucketQuota(context.Background(), name, &madmin.BucketQuota{Quota: uint64(quota.Bytes), Type: madmin.HardQuota})
+		if err != nil {
+			return err
+		}
+	}
+	if quota.Objects > 0 {
+		return fmt.Errorf("object quota of bucket %s is not applied, minio only supports size quotas: %w", name, ErrUnsupported)
 	}
 	return nil
 }
 
-func (minioS3Client *MinioS3Client) PathExists(bucketname string, name string) error {
-	log.Println("check if path " + name + "exists in  bucket" + bucketname)
-	objectCh := minioS3Client.client.ListObjects(context.Background(),
-		bucketname,
-		minio.ListObjectsOptions{Prefix: name, MaxKeys: 1})
-
-	for object := range objectCh {
-		if object.Err != nil {
-			return fmt.Errorf(object.Err.Error())
+func (minioS3Client *MinioS3Client) IdentityExists(name string) (bool, error) {
+	log.Println("check if user " + name + " exists")
+	_, err := minioS3Client.adminClient.GetUserInfo(context.Background(), name)
+	if err != nil {
+		if madmin.ToErrorResponse(err).Code == "XMinioAdminNoSuchUser" {
+			return false, nil
 		}
+		return false, err
+	}
+	return true, nil
+}
+
+// CreateIdentity creates a user whose access key is the identity name,
+// with a canned policy of the same name restricted to the bucket or its prefix.
+func (minioS3Client *MinioS3Client) CreateIdentity(name string, bucketname string, prefix string) (S3Credentials, error) {
+	log.Println("create user " + name + " for bucket " + bucketname)
+	secretKey, err := generateSecretKey()
+	if err != nil {
+		return S3Credentials{}, err
+	}
+	err = minioS3Client.adminClient.AddUser(context.Background(), name, secretKey)
+	if err != nil {
+		return S3Credentials{}, err
+	}
+	err = minioS3Client.adminClient.AddCannedPolicy(context.Background(), name, identityPolicy(bucketname, prefix, nil))
+	if err != nil {
+		return S3Credentials{}, err
+	}
+	err = minioS3Client.adminClient.SetPolicy(context.Background(), name, name, false)
+	if err != nil {
+		return S3Credentials{}, err
+	}
+	return minioS3Client.credentials(name, secretKey), nil
+}
+
+func (minioS3Client *MinioS3Client) RotateIdentity(name string) (S3Credentials, error) {
+	log.Println("rotate secret key of user " + name)
+	secretKey, err := generateSecretKey()
+	if err != nil {
+		return S3Credentials{}, err
+	}
+	err = minioS3Client.adminClient.SetUser(context.Background(), name, secretKey, madmin.AccountEnabled)
+	if err != nil {
+		return S3Credentials{}, err
+	}
+	return minioS3Client.credentials(name, secretKey), nil
+}
+
+// DeleteIdentity deletes the user, its canned policy and the one of its grants,
+// the bucket policy doesn't name it.
+func (minioS3Client *MinioS3Client) DeleteIdentity(name string, bucketname string, prefix string) error {
+	log.Println("delete user " + name)
+	err := minioS3Client.adminClient.RemoveUser(context.Background(), name)
+	if err != nil {
+		return err
+	}
+	err = minioS3Client.adminClient.RemoveCannedPolicy(context.Background(), name)
+	if err != nil {
+		return err
+	}
+	err = minioS3Client.adminClient.RemoveCannedPolicy(context.Background(), name+"-grants")
+	if err != nil && madmin.ToErrorResponse(err).Code != "XMinioAdminNoSuchPolicy" {
+		return err
 	}
 	return nil
 }
 
-func (minioS3Client *MinioS3Client) DeleteBucket(name string) error {
-	log.Println("delete bucket " + name + "exists")
-	return minioS3Client.client.RemoveBucket(context.Background(), name)
+// MoveIdentity rewrites the canned policy of the user, the bucket policy doesn't name it.
+func (minioS3Client *MinioS3Client) MoveIdentity(name string, previousBucketname string, previousPrefix string, bucketname string, prefix string) error {
+	log.Println("move user " + name + " to bucket " + bucketname + "/" + prefix)
+	return minioS3Client.adminClient.AddCannedPolicy(context.Background(), name, identityPolicy(bucketname, prefix, nil))
 }
 
-func (minioS3Client *MinioS3Client) SetQuota(name string, quota int64) error {
-	log.Println("set quota " + fmt.Sprint(quota) + "on bucket " + name + "exists")
-	minioS3Client.adminClient.SetBucketQuota(context.Background(), name, &madmin.BucketQuota{Quota: uint64(quota), Type: madmin.HardQuota})
+// SetGrants attaches to the user, in addition to its other policies, a canned
+// policy named after it holding the grant statements. The grants of a user that
+// no longer exists are already revoked.
+func (minioS3Client *MinioS3Client) SetGrants(identity string, grants []Grant) error {
+	log.Println("set " + fmt.Sprint(len(grants)) + " grants of user " + identity)
+	policy, err := grantPolicy(grants)
+	if err != nil {
+		return err
+	}
+	policyName := identity + "-grants"
+	userInfo, err := minioS3Client.adminClient.GetUserInfo(context.Background(), identity)
+	if err != nil {
+		if policy != nil || madmin.ToErrorResponse(err).Code != "XMinioAdminNoSuchUser" {
+			return err
+		}
+		err = minioS3Client.adminClient.RemoveCannedPolicy(context.Background(), policyName)
+		if err != nil && madmin.ToErrorResponse(err).Code != "XMinioAdminNoSuchPolicy" {
+			return err
+		}
+		return nil
+	}
+	attached := []string{}
+	for _, name := range strings.Split(userInfo.PolicyName, ",") {
+		if name != "" && name != policyName {
+			attached = append(attached, name)
+		}
+	}
+	if policy != nil {
+		err = minioS3Client.adminClient.AddCannedPolicy(context.Background(), policyName, policy)
+		if err != nil {
+			return err
+		}
+		attached = append(attached, policyName)
+	}
+	if strings.Join(attached, ",") != userInfo.PolicyName {
+		err = minioS3Client.adminClient.SetPolicy(context.Background(), strings.Join(attached, ","), identity, false)
+		if err != nil {
+			return err
+		}
+	}
+	if policy == nil {
+		err = minioS3Client.adminClient.RemoveCannedPolicy(context.Background(), policyName)
+		if err != nil && madmin.ToErrorResponse(err).Code != "XMinioAdminNoSuchPolicy" {
+			return err
+		}
+	}
 	return nil
 }
 
-func newMinioS3Client(S3Config *S3Config) *MinioS3Client {
+// SetPathAccess rewrites the path access statements of the bucket policy when they
+// drifted. MinIO matches the principals of a bucket policy against user names,
+// which are the identity names, and never against groups: paths shared with
+// groups are only shared with their identities.
+func (minioS3Client *MinioS3Client) SetPathAccess(bucketname string, prefix string, access []PathAccess) (bool, error) {
+	groups := []string{}
+	statements, err := pathAccessStatements(bucketname, prefix, access, func(pathAccess PathAccess) (PolicyPrincipal, error) {
+		groups = append(groups, pathAccess.Groups...)
+		if len(pathAccess.Identities) == 0 {
+			return nil, nil
+		}
+		return PolicyPrincipal{"AWS": append(PolicyValues{}, pathAccess.Identities...)}, nil
+	})
+	if err != nil {
+		return false, err
+	}
+	current, err := minioS3Client.client.GetBucketPolicy(context.Background(), bucketname)
+	if err != nil {
+		return false, err
+	}
+	policy, changed, err := replaceStatements(current, pathAccessSid(prefix), statements)
+	if err == nil && changed {
+		log.Println("update policy of bucket " + bucketname)
+		err = minioS3Client.client.SetBucketPolicy(context.Background(), bucketname, policy)
+	}
+	if err == nil && len(groups) > 0 {
+		err = fmt.Errorf("paths of bucket %s can't be shared with groups %s, minio bucket policies only match users: %w", bucketname, strings.Join(groups, ", "), ErrUnsupported)
+	}
+	return changed, err
+}
+
+func (minioS3Client *MinioS3Client) CreateBucketWithObjectLock(name string) error {
+	log.Println("create bucket " + name + " with object lock")
+	return minioS3Client.client.MakeBucket(context.Background(), name, minio.MakeBucketOptions{Region: minioS3Client.s3Config.Region, ObjectLocking: true})
+}
+
+func (minioS3Client *MinioS3Client) GetVersioning(bucketname string) (string, error) {
+	return getVersioning(&minioS3Client.client, bucketname)
+}
+
+func (minioS3Client *MinioS3Client) SetVersioning(bucketname string, status string) error {
+	return setVersioning(&minioS3Client.client, bucketname, status)
+}
+
+func (minioS3Client *MinioS3Client) GetObjectLock(bucketname string) (*ObjectLock, error) {
+	return getObjectLock(&minioS3Client.client, bucketname)
+}
+
+func (minioS3Client *MinioS3Client) SetObjectLock(bucketname string, objectLock ObjectLock) error {
+	return setObjectLock(&minioS3Client.client, bucketname, objectLock)
+}
+
+func (minioS3Client *MinioS3Client) SetLegalHold(bucketname string, status string) error {
+	return setLegalHold(&minioS3Client.client, bucketname, status)
+}
+
+func (minioS3Client *MinioS3Client) GetLifecycle(bucketname string) ([]LifecycleRule, error) {
+	return getLifecycle(&minioS3Client.client, bucketname)
+}
+
+func (minioS3Client *MinioS3Client) SetLifecycle(bucketname string, rules []LifecycleRule) error {
+	return setLifecycle(&minioS3Client.client, bucketname, rules)
+}
+
+func (minioS3Client *MinioS3Client) GetCORS(bucketname string) ([]CORSRule, error) {
+	return getCORS(minioS3Client.corsClient, bucketname)
+}
+
+func (minioS3Client *MinioS3Client) SetCORS(bucketname string, rules []CORSRule) error {
+	return setCORS(minioS3Client.corsClient, bucketname, rules)
+}
+
+func (minioS3Client *MinioS3Client) GetNotifications(bucketname string) ([]Notification, error) {
+	return getNotifications(&minioS3Client.client, bucketname)
+}
+
+func (minioS3Client *MinioS3Client) SetNotifications(bucketname string, notifications []Notification) error {
+	return setNotifications(&minioS3Client.client, bucketname, notifications)
+}
+
+func (minioS3Client *MinioS3Client) GetEncryption(bucketname string) (*Encryption, error) {
+	return getEncryption(&minioS3Client.client, bucketname)
+}
+
+func (minioS3Client *MinioS3Client) SetEncryption(bucketname string, encryption Encryption) error {
+	return setEncryption(&minioS3Client.client, bucketname, encryption)
+}
+
+func (minioS3Client *MinioS3Client) RemoveEncryption(bucketname string) error {
+	return removeEncryption(&minioS3Client.client, bucketname)
+}
+
+// CreateKMSKey creates the key through the KMS of MinIO, KES
+func (minioS3Client *MinioS3Client) CreateKMSKey(keyID string) error {
+	_, err := minioS3Client.adminClient.GetKeyStatus(context.Background(), keyID)
+	if err == nil {
+		return nil
+	}
+	log.Println("create kms key " + keyID)
+	return minioS3Client.adminClient.CreateKey(context.Background(), keyID)
+}
+
+func (minioS3Client *MinioS3Client) GetBucketTags(bucketname string) (map[string]string, error) {
+	return getBucketTags(&minioS3Client.client, bucketname)
+}
+
+func (minioS3Client *MinioS3Client) SetBucketTags(bucketname string, tags map[string]string) error {
+	return setBucketTags(&minioS3Client.client, bucketname, tags)
+}
+
+// GetBucketUsage reads the usage computed by the data scanner of MinIO, which may
+// lag behind recent writes. A bucket not scanned yet has no usage.
+func (minioS3Client *MinioS3Client) GetBucketUsage(bucketname string) (Usage, error) {
+	dataUsage, err := minioS3Client.adminClient.DataUsageInfo(context.Background())
+	if err != nil {
+		return Usage{}, err
+	}
+	bucketUsage := dataUsage.BucketsUsage[bucketname]
+	return Usage{Bytes: int64(bucketUsage.Size), Objects: int64(bucketUsage.ObjectsCount)}, nil
+}
+
+func (minioS3Client *MinioS3Client) GetPrefixUsage(bucketname string, prefix string) (Usage, error) {
+	return prefixUsage(&minioS3Client.client, bucketname, prefix)
+}
+
+func (minioS3Client *MinioS3Client) credentials(accessKey string, secretKey string) S3Credentials {
+	return S3Credentials{
+		AccessKey: accessKey,
+		SecretKey: secretKey,
+		Endpoint:  minioS3Client.s3Config.endpointURL(),
+		Region:    minioS3Client.s3Config.Region,
+	}
+}
+
+func newMinioS3Client(S3Config *S3Config) (*MinioS3Client, error) {
 	log.Println("create minio clients")
+	transport, err := newTransport(S3Config)
+	if err != nil {
+		return nil, err
+	}
 	minioClient, err := minio.New(S3Config.S3UrlEndpoint, &minio.Options{
-		Creds:  credentials.NewStaticV4(S3Config.AccessKey, S3Config.SecretKey, ""),
-		Region: S3Config.Region,
-		Secure: S3Config.UseSsl,
+		Creds:     credentials.NewStaticV4(S3Config.AccessKey, S3Config.SecretKey, ""),
+		Region:    S3Config.Region,
+		Secure:    S3Config.UseSsl,
+		Transport: transport,
 	})
 	if err != nil {
-		log.Fatalln(err)
+		return nil, err
 	}
 
 	adminClient, err := madmin.New(S3Config.S3UrlEndpoint, S3Config.AccessKey, S3Config.SecretKey, S3Config.UseSsl)
 	if err != nil {
-		log.Fatalln(err)
+		return nil, err
+	}
+	adminClient.SetCustomTransport(transport)
+
+	corsClient, err := newCORSClient(S3Config)
+	if err != nil {
+		return nil, err
 	}
 
-	return &MinioS3Client{*S3Config, *minioClient, *adminClient}
+	return &MinioS3Client{*S3Config, *minioClient, *adminClient, corsClient}, nil
 }
@@ -0,0 +1,106 @@
+package factory
+
+import (
+	"encoding/json"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("MinioS3Client", func() {
+	var standIn *s3StandIn
+	var s3Client S3Client
+
+	BeforeEach(func() {
+		standIn = newS3StandIn()
+		var err error
+		s3Client, err = GetS3Client("minio", &S3Config{
+			S3Provider:    "minio",
+			S3UrlEndpoint: standIn.endpoint(),
+			Region:        "us-east-1",
+			AccessKey:     "access",
+			SecretKey:     "secret",
+		})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		standIn.close()
+	})
+
+	It("sets and gets hard size quotas", func() {
+		Expect(s3Client.CreateBucket("bucket-titi")).To(Succeed())
+		Expect(s3Client.SetQuota("bucket-titi", Quota{Bytes: 53687091200})).To(Succeed())
+		quota, err := s3Client.GetQuota("bucket-titi")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(quota).To(Equal(Quota{Bytes: 53687091200}))
+		Expect(standIn.buckets["bucket-titi"].quota).To(ContainSubstring(`"quotatype":"hard"`))
+	})
+
+	It("reports quota failures", func() {
+		Expect(s3Client.SetQuota("bucket-missing", Quota{Bytes: 1024})).NotTo(Succeed())
+		_, err := s3Client.GetQuota("bucket-missing")
+		Expect(err).To(HaveOccurred())
+
+	})
+
+	It("sets the size quota and reports the object quota", func() {
+		Expect(s3Client.CreateBucket("bucket-titi")).To(Succeed())
+		Expect(s3Client.SetQuota("bucket-titi", Quota{Bytes: 1024, Objects: 10})).To(MatchError(ErrUnsupported))
+		quota, err := s3Client.GetQuota("bucket-titi")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(quota).To(Equal(Quota{Bytes: 1024}))
+	})
+
+	It("leaves groups out of the bucket policy and reports them", func() {
+		Expect(s3Client.CreateBucket("bucket-titi")).To(Succeed())
+		access := []PathAccess{
+			{Path: "diffusion", Mode: AccessShared, Identities: []string{"bucket-toto"}, Groups: []string{"datalab-team"}},
+			{Path: "equipe", Mode: AccessShared, Groups: []string{"datalab-team"}},
+		}
+		changed, err := s3Client.SetPathAccess("bucket-titi", "", access)
+		Expect(err).To(MatchError(ErrUnsupported))
+		Expect(err).To(MatchError(ContainSubstring("datalab-team")))
+		Expect(changed).To(BeTrue())
+
+		document := PolicyDocument{}
+		Expect(json.Unmarshal([]byte(standIn.buckets["bucket-titi"].policy), &document)).To(Succeed())
+		Expect(document.Statement).To(HaveLen(2))
+		for _, statement := range document.Statement {
+			Expect(statement.Principal).To(Equal(PolicyPrincipal{"AWS": {"bucket-toto"}}))
+		}
+
+		changed, err = s3Client.SetPathAccess("bucket-titi", "", access[:1])
+		Expect(err).To(MatchError(ErrUnsupported))
+		Expect(changed).To(BeFalse())
+		_, err = s3Client.SetPathAccess("bucket-titi", "", nil)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("attaches the grants of a user in their own canned policy", func() {
+		standIn.users["toto"] = "readwrite"
+		grants := []Grant{{Bucketname: "bucket-titi", Paths: []string{"diffusion/"}, Access: GrantRead}}
+		Expect(s3Client.SetGrants("toto", grants)).To(Succeed())
+		Expect(standIn.users["toto"]).To(Equal("readwrite,toto-grants"))
+		Expect(standIn.cannedPolicies["toto-grants"]).To(ContainSubstring(`"arn:aws:s3:::bucket-titi/diffusion/*"`))
+
+		Expect(s3Client.SetGrants("toto", nil)).To(Succeed())
+		Expect(standIn.users["toto"]).To(Equal("readwrite"))
+		Expect(standIn.cannedPolicies).NotTo(HaveKey("toto-grants"))
+		Expect(s3Client.SetGrants("toto", nil)).To(Succeed())
+
+		Expect(s3Client.SetGrants("tata", grants)).NotTo(Succeed())
+	})
+
+	It("deletes the canned policy of the grants with the user", func() {
+		standIn.users["toto"] = "toto"
+		standIn.cannedPolicies["toto"] = "{}"
+		grants := []Grant{{Bucketname: "bucket-titi", Paths: []string{"diffusion/"}, Access: GrantRead}}
+		Expect(s3Client.SetGrants("toto", grants)).To(Succeed())
+
+		Expect(s3Client.DeleteIdentity("toto", "bucket-toto", "")).To(Succeed())
+		Expect(standIn.users).NotTo(HaveKey("toto"))
+		Expect(standIn.cannedPolicies).To(BeEmpty())
+		Expect(s3Client.SetGrants("toto", nil)).To(Succeed())
+	})
+})
@@ -1,47 +1,778 @@
 package factory
 
 import (
+	"bytes"
 	"fmt"
+	"io"
 	"log"
+	"sort"
+	"strings"
+	"sync"
 )
 
-type MockedS3Client struct{}
+// MockedS3Client is an in-memory object store: buckets, quotas, objects, tags,
+// policies and identities are really stored, so the reconciliation can be
+// tested without a server. Failures makes the named methods fail.
+type MockedS3Client struct {
+	mutex      sync.Mutex
+	buckets    map[string]*mockedBucket
+	identities map[string]S3Credentials
+	// bucket, followed by a slash and the prefix, each identity is allowed on
+	identityLocations map[string]string
+	kmsKeys           map[string]bool
+	grants            map[string][]Grant
+	// error returned by the method with that name, e.g. "SetQuota", instead of doing anything
+	Failures map[string]error
+}
+
+type mockedBucket struct {
+	quota      Quota
+	objects    map[string][]byte
+	tags       map[string]string
+	policy     string
+	versioning string
+	objectLock *ObjectLock
+	legalHold  string
+	lifecycle  []LifecycleRule
+	cors       []CORSRule
+	// notifications as set, the fake has no registered target to check them against
+	notifications []Notification
+	encryption    *Encryption
+}
+
+// fail returns the injected failure of the method, if any. The mutex must be held.
+func (mockedS3Provider *MockedS3Client) fail(method string) error {
+	return mockedS3Provider.Failures[method]
+}
+
+// bucket returns the bucket or an error when it does not exist. The mutex must be held.
+func (mockedS3Provider *MockedS3Client) bucket(name string) (*mockedBucket, error) {
+	bucket, found := mockedS3Provider.buckets[name]
+	if !found {
+		return nil, fmt.Errorf("bucket %s does not exist", name)
+	}
+	return bucket, nil
+}
 
 func (mockedS3Provider *MockedS3Client) BucketExists(name string) (bool, error) {
-	log.Println("check if bucket " + name + "exists")
-	return false, nil
+	mockedS3Provider.mutex.Lock()
+	defer mockedS3Provider.mutex.Unlock()
+	log.Println("check if bucket " + name + " exists")
+	if err := mockedS3Provider.fail("BucketExists"); err != nil {
+		return false, err
+	}
+	_, found := mockedS3Provider.buckets[name]
+	return found, nil
 }
 
-func (mockedS3Provider *MockedS3Client) GetQuota(name string) (int64, error) {
+func (mockedS3Provider *MockedS3Client) GetQuota(name string) (Quota, error) {
+	mockedS3Provider.mutex.Lock()
+	defer mockedS3Provider.mutex.Unlock()
 	log.Println("bucket " + name + " get quota")
-	return 1, nil
+	if err := mockedS3Provider.fail("GetQuota"); err != nil {
+		return Quota{}, err
+	}
+	bucket, err := mockedS3Provider.bucket(name)
+	if err != nil {
+		return Quota{}, err
+	}
+	return bucket.quota, nil
 }
 
 func (mockedS3Provider *MockedS3Client) CreateBucket(name string) error {
-	log.Println("create bucket " + name + "exists")
+	mockedS3Provider.mutex.Lock()
+	defer mockedS3Provider.mutex.Unlock()
+	log.Println("create bucket " + name)
+	return mockedS3Provider.createBucket("CreateBucket", name, nil)
+}
+
+func (mockedS3Provider *MockedS3Client) CreateBucketWithObjectLock(name string) error {
+	mockedS3Provider.mutex.Lock()
+	defer mockedS3Provider.mutex.Unlock()
+	log.Println("create bucket " + name + " with object lock")
+	return mockedS3Provider.createBucket("CreateBucketWithObjectLock", name, &ObjectLock{})
+}
+
+// createBucket stores a new empty bucket. The mutex must be held.
+func (mockedS3Provider *MockedS3Client) createBucket(method string, name string, objectLock *ObjectLock) error {
+	if err := mockedS3Provider.fail(method); err != nil {
+		return err
+	}
+	if _, found := mockedS3Provider.buckets[name]; found {
+		return fmt.Errorf("bucket %s already exists", name)
+	}
+	bucket := &mockedBucket{objects: map[string][]byte{}, tags: map[string]string{}, objectLock: objectLock}
+	if objectLock != nil {
+		// object lock needs versioning
+		bucket.versioning = VersioningEnabled
+	}
+	mockedS3Provider.buckets[name] = bucket
 	return nil
 }
 
 func (mockedS3Provider *MockedS3Client) CreatePath(bucketname string, name string) error {
-	log.Println("create path " + name + "exists")
-	return nil
+	mockedS3Provider.mutex.Lock()
+	defer mockedS3Provider.mutex.Unlock()
+	log.Println("create path " + name + " in bucket " + bucketname)
+	if err := mockedS3Provider.fail("CreatePath"); err != nil {
+		return err
+	}
+	key, err := FolderKey(name)
+	if err != nil {
+		return err
+	}
+	return mockedS3Provider.putObject(bucketname, key, nil)
+}
+
+func (mockedS3Provider *MockedS3Client) PathExists(bucketname string, name string) (bool, error) {
+	mockedS3Provider.mutex.Lock()
+	defer mockedS3Provider.mutex.Unlock()
+	log.Println("check if path " + name + " exists in bucket " + bucketname)
+	if err := mockedS3Provider.fail("PathExists"); err != nil {
+		return false, err
+	}
+	key, err := FolderKey(name)
+	if err != nil {
+		return false, err
+	}
+	bucket, err := mockedS3Provider.bucket(bucketname)
+	if err != nil {
+		return false, err
+	}
+	for object := range bucket.objects {
+		if strings.HasPrefix(object, key) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (mockedS3Provider *MockedS3Client) PutObject(bucketname string, key string, content []byte) error {
+	mockedS3Provider.mutex.Lock()
+	defer mockedS3Provider.mutex.Unlock()
+	log.Println("put object " + key + " in bucket " + bucketname)
+	if err := mockedS3Provider.fail("PutObject"); err != nil {
+		return err
+	}
+	return mockedS3Provider.putObject(bucketname, key, content)
+}
+
+func (mockedS3Provider *MockedS3Client) GetObject(bucketname string, key string) ([]byte, error) {
+	mockedS3Provider.mutex.Lock()
+	defer mockedS3Provider.mutex.Unlock()
+	log.Println("get object " + key + " of bucket " + bucketname)
+	if err := mockedS3Provider.fail("GetObject"); err != nil {
+		return nil, err
+	}
+	bucket, err := mockedS3Provider.bucket(bucketname)
+	if err != nil {
+		return nil, err
+	}
+	content, found := bucket.objects[key]
+	if !found {
+		return nil, fmt.Errorf("object %s not found in bucket %s", key, bucketname)
+	}
+	return append([]byte{}, content...), nil
+}
+
+func (mockedS3Provider *MockedS3Client) OpenObject(bucketname string, key string) (io.ReadCloser, error) {
+	mockedS3Provider.mutex.Lock()
+	defer mockedS3Provider.mutex.Unlock()
+	log.Println("open object " + key + " of bucket " + bucketname)
+	if err := mockedS3Provider.fail("OpenObject"); err != nil {
+		return nil, err
+	}
+	bucket, err := mockedS3Provider.bucket(bucketname)
+	if err != nil {
+		return nil, err
+	}
+	content, found := bucket.objects[key]
+	if !found {
+		return nil, fmt.Errorf("object %s not found in bucket %s", key, bucketname)
+	}
+	return io.NopCloser(bytes.NewReader(append([]byte{}, content...))), nil
+}
+
+// UploadObject reads the whole reader, the mock keeps the objects in memory anyway
+func (mockedS3Provider *MockedS3Client) UploadObject(bucketname string, key string, reader io.Reader, size int64) error {
+	content, err := io.ReadAll(reader)
+	if err != nil {
+		return err
+	}
+	mockedS3Provider.mutex.Lock()
+	defer mockedS3Provider.mutex.Unlock()
+	log.Println("upload object " + key + " in bucket " + bucketname)
+	if err := mockedS3Provider.fail("UploadObject"); err != nil {
+		return err
+	}
+	if int64(len(content)) != size {
+		return fmt.Errorf("object %s has %d bytes, %d expected", key, len(content), size)
+	}
+	return mockedS3Provider.putObject(bucketname, key, content)
+}
+
+func (mockedS3Provider *MockedS3Client) ListObjects(bucketname string, prefix string, startAfter string, maxKeys int) ([]Object, error) {
+	mockedS3Provider.mutex.Lock()
+	defer mockedS3Provider.mutex.Unlock()
+	log.Println("list objects " + prefix + " of bucket " + bucketname)
+	if err := mockedS3Provider.fail("ListObjects"); err != nil {
+		return nil, err
+	}
+	bucket, err := mockedS3Provider.bucket(bucketname)
+	if err != nil {
+		return nil, err
+	}
+	keys := []string{}
+	for key := range bucket.objects {
+		if strings.HasPrefix(key, prefix) && key > startAfter {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	objects := []Object{}
+	for _, key := range keys {
+		if len(objects) == maxKeys {
+			break
+		}
+		objects = append(objects, Object{Key: key, Size: int64(len(bucket.objects[key]))})
+	}
+	return objects, nil
 }
 
-func (mockedS3Provider *MockedS3Client) PathExists(bucketname string, name string) error {
-	log.Println("check if  path " + name + "exists")
+func (mockedS3Provider *MockedS3Client) CopyObject(srcBucketname string, srcKey string, bucketname string, key string) error {
+	mockedS3Provider.mutex.Lock()
+	defer mockedS3Provider.mutex.Unlock()
+	log.Println("copy object " + srcBucketname + "/" + srcKey + " to " + bucketname + "/" + key)
+	if err := mockedS3Provider.fail("CopyObject"); err != nil {
+		return err
+	}
+	srcBucket, err := mockedS3Provider.bucket(srcBucketname)
+	if err != nil {
+		return err
+	}
+	content, found := srcBucket.objects[srcKey]
+	if !found {
+		return fmt.Errorf("object %s not found in bucket %s", srcKey, srcBucketname)
+	}
+	return mockedS3Provider.putObject(bucketname, key, content)
+}
+
+// putObject stores a copy of the content. The mutex must be held.
+func (mockedS3Provider *MockedS3Client) putObject(bucketname string, key string, content []byte) error {
+	bucket, err := mockedS3Provider.bucket(bucketname)
+	if err != nil {
+		return err
+	}
+	bucket.objects[key] = append([]byte{}, content...)
 	return nil
 }
 
 func (mockedS3Provider *MockedS3Client) DeleteBucket(name string) error {
-	log.Println("delete bucket " + name + "exists")
+	mockedS3Provider.mutex.Lock()
+	defer mockedS3Provider.mutex.Unlock()
+	log.Println("delete bucket " + name)
+	if err := mockedS3Provider.fail("DeleteBucket"); err != nil {
+		return err
+	}
+	bucket, err := mockedS3Provider.bucket(name)
+	if err != nil {
+		return err
+	}
+	if len(bucket.objects) > 0 {
+		return fmt.Errorf("bucket %s is not empty", name)
+	}
+	delete(mockedS3Provider.buckets, name)
+	return nil
+}
+
+func (mockedS3Provider *MockedS3Client) SetQuota(name string, quota Quota) error {
+	mockedS3Provider.mutex.Lock()
+	defer mockedS3Provider.mutex.Unlock()
+	log.Println("set quota " + fmt.Sprint(quota.Bytes) + " on bucket " + name)
+	if err := mockedS3Provider.fail("SetQuota"); err != nil {
+		return err
+	}
+	bucket, err := mockedS3Provider.bucket(name)
+	if err != nil {
+		return err
+	}
+	bucket.quota = quota
+	return nil
+}
+
+func (mockedS3Provider *MockedS3Client) IdentityExists(name string) (bool, error) {
+	mockedS3Provider.mutex.Lock()
+	defer mockedS3Provider.mutex.Unlock()
+	log.Println("check if user " + name + " exists")
+	if err := mockedS3Provider.fail("IdentityExists"); err != nil {
+		return false, err
+	}
+	_, found := mockedS3Provider.identities[name]
+	return found, nil
+}
+
+func (mockedS3Provider *MockedS3Client) CreateIdentity(name string, bucketname string, prefix string) (S3Credentials, error) {
+	mockedS3Provider.mutex.Lock()
+	defer mockedS3Provider.mutex.Unlock()
+	log.Println("create user " + name + " for bucket " + bucketname)
+	if err := mockedS3Provider.fail("CreateIdentity"); err != nil {
+		return S3Credentials{}, err
+	}
+	if _, found := mockedS3Provider.identities[name]; found {
+		return S3Credentials{}, fmt.Errorf("user %s already exists", name)
+	}
+	mockedS3Provider.identityLocations[name] = bucketname + "/" + prefix
+	return mockedS3Provider.newKeys(name)
+}
+
+func (mockedS3Provider *MockedS3Client) RotateIdentity(name string) (S3Credentials, error) {
+	mockedS3Provider.mutex.Lock()
+	defer mockedS3Provider.mutex.Unlock()
+	log.Println("rotate secret key of user " + name)
+	if err := mockedS3Provider.fail("RotateIdentity"); err != nil {
+		return S3Credentials{}, err
+	}
+	if _, found := mockedS3Provider.identities[name]; !found {
+		return S3Credentials{}, fmt.Errorf("user %s does not exist", name)
+	}
+	return mockedS3Provider.newKeys(name)
+}
+
+// newKeys stores new keys for the identity. The mutex must be held.
+func (mockedS3Provider *MockedS3Client) newKeys(name string) (S3Credentials, error) {
+	secretKey, err := generateSecretKey()
+	if err != nil {
+		return S3Credentials{}, err
+	}
+	credentials := S3Credentials{AccessKey: name, SecretKey: secretKey}
+	mockedS3Provider.identities[name] = credentials
+	return credentials, nil
+}
+
+func (mockedS3Provider *MockedS3Client) DeleteIdentity(name string, bucketname string, prefix string) error {
+	mockedS3Provider.mutex.Lock()
+	defer mockedS3Provider.mutex.Unlock()
+	log.Println("delete user " + name)
+	if err := mockedS3Provider.fail("DeleteIdentity"); err != nil {
+		return err
+	}
+	delete(mockedS3Provider.identities, name)
+	delete(mockedS3Provider.identityLocations, name)
+	delete(mockedS3Provider.grants, name)
 	return nil
 }
 
-func (mockedS3Provider *MockedS3Client) SetQuota(name string, quota int64) error {
-	log.Println("set quota " + fmt.Sprint(quota) + "on bucket " + name + "exists")
+func (mockedS3Provider *MockedS3Client) MoveIdentity(name string, previousBucketname string, previousPrefix string, bucketname string, prefix string) error {
+	mockedS3Provider.mutex.Lock()
+	defer mockedS3Provider.mutex.Unlock()
+	log.Println("move user " + name + " to bucket " + bucketname + "/" + prefix)
+	if err := mockedS3Provider.fail("MoveIdentity"); err != nil {
+		return err
+	}
+	if _, found := mockedS3Provider.identities[name]; !found {
+		return fmt.Errorf("user %s does not exist", name)
+	}
+	mockedS3Provider.identityLocations[name] = bucketname + "/" + prefix
 	return nil
 }
 
+// IdentityLocation returns the bucket, followed by a slash and the prefix, the identity is allowed on
+func (mockedS3Provider *MockedS3Client) IdentityLocation(name string) string {
+	mockedS3Provider.mutex.Lock()
+	defer mockedS3Provider.mutex.Unlock()
+	return mockedS3Provider.identityLocations[name]
+}
+
+func (mockedS3Provider *MockedS3Client) SetGrants(identity string, grants []Grant) error {
+	mockedS3Provider.mutex.Lock()
+	defer mockedS3Provider.mutex.Unlock()
+	log.Println("set " + fmt.Sprint(len(grants)) + " grants of user " + identity)
+	if err := mockedS3Provider.fail("SetGrants"); err != nil {
+		return err
+	}
+	if _, err := grantPolicy(grants); err != nil {
+		return err
+	}
+	if len(grants) == 0 {
+		delete(mockedS3Provider.grants, identity)
+		return nil
+	}
+	if _, found := mockedS3Provider.identities[identity]; !found {
+		return fmt.Errorf("user %s does not exist", identity)
+	}
+	mockedS3Provider.grants[identity] = append([]Grant{}, grants...)
+	return nil
+}
+
+// Grants returns the grants of the identity
+func (mockedS3Provider *MockedS3Client) Grants(identity string) []Grant {
+	mockedS3Provider.mutex.Lock()
+	defer mockedS3Provider.mutex.Unlock()
+	return append([]Grant{}, mockedS3Provider.grants[identity]...)
+}
+
+// SetPathAccess stores the path access statements in the bucket policy, the
+// principals are the identity and group names, as on MinIO.
+func (mockedS3Provider *MockedS3Client) SetPathAccess(bucketname string, prefix string, access []PathAccess) (bool, error) {
+	mockedS3Provider.mutex.Lock()
+	defer mockedS3Provider.mutex.Unlock()
+	log.Println("set access of " + fmt.Sprint(len(access)) + " paths on bucket " + bucketname)
+	if err := mockedS3Provider.fail("SetPathAccess"); err != nil {
+		return false, err
+	}
+	bucket, err := mockedS3Provider.bucket(bucketname)
+	if err != nil {
+		return false, err
+	}
+	statements, err := pathAccessStatements(bucketname, prefix, access, func(pathAccess PathAccess) (PolicyPrincipal, error) {
+		return PolicyPrincipal{"AWS": append(append(PolicyValues{}, pathAccess.Identities...), pathAccess.Groups...)}, nil
+	})
+	if err != nil {
+		return false, err
+	}
+	policy, changed, err := replaceStatements(bucket.policy, pathAccessSid(prefix), statements)
+	if err != nil || !changed {
+		return false, err
+	}
+	bucket.policy = policy
+	return true, nil
+}
+
+// Policy returns the bucket policy, empty if none
+func (mockedS3Provider *MockedS3Client) Policy(bucketname string) string {
+	mockedS3Provider.mutex.Lock()
+	defer mockedS3Provider.mutex.Unlock()
+	if bucket, found := mockedS3Provider.buckets[bucketname]; found {
+		return bucket.policy
+	}
+	return ""
+}
+
+// Objects returns the content of the objects of the bucket by key
+func (mockedS3Provider *MockedS3Client) Objects(bucketname string) map[string]string {
+	mockedS3Provider.mutex.Lock()
+	defer mockedS3Provider.mutex.Unlock()
+	objects := map[string]string{}
+	if bucket, found := mockedS3Provider.buckets[bucketname]; found {
+		for key, content := range bucket.objects {
+			objects[key] = string(content)
+		}
+	}
+	return objects
+}
+
+func (mockedS3Provider *MockedS3Client) GetVersioning(bucketname string) (string, error) {
+	mockedS3Provider.mutex.Lock()
+	defer mockedS3Provider.mutex.Unlock()
+	log.Println("get versioning of bucket " + bucketname)
+	if err := mockedS3Provider.fail("GetVersioning"); err != nil {
+		return "", err
+	}
+	bucket, err := mockedS3Provider.bucket(bucketname)
+	if err != nil {
+		return "", err
+	}
+	return bucket.versioning, nil
+}
+
+func (mockedS3Provider *MockedS3Client) SetVersioning(bucketname string, status string) error {
+	mockedS3Provider.mutex.Lock()
+	defer mockedS3Provider.mutex.Unlock()
+	log.Println("set versioning " + status + " on bucket " + bucketname)
+	if err := mockedS3Provider.fail("SetVersioning"); err != nil {
+		return err
+	}
+	bucket, err := mockedS3Provider.bucket(bucketname)
+	if err != nil {
+		return err
+	}
+	if bucket.objectLock != nil && status != VersioningEnabled {
+		return fmt.Errorf("versioning of bucket %s can't be suspended, object lock is enabled", bucketname)
+	}
+	bucket.versioning = status
+	return nil
+}
+
+func (mockedS3Provider *MockedS3Client) GetObjectLock(bucketname string) (*ObjectLock, error) {
+	mockedS3Provider.mutex.Lock()
+	defer mockedS3Provider.mutex.Unlock()
+	log.Println("get object lock of bucket " + bucketname)
+	if err := mockedS3Provider.fail("GetObjectLock"); err != nil {
+		return nil, err
+	}
+	bucket, err := mockedS3Provider.bucket(bucketname)
+	if err != nil || bucket.objectLock == nil {
+		return nil, err
+	}
+	objectLock := *bucket.objectLock
+	return &objectLock, nil
+}
+
+func (mockedS3Provider *MockedS3Client) SetObjectLock(bucketname string, objectLock ObjectLock) error {
+	mockedS3Provider.mutex.Lock()
+	defer mockedS3Provider.mutex.Unlock()
+	log.Println("set object lock " + objectLock.Mode + " on bucket " + bucketname)
+	if err := mockedS3Provider.fail("SetObjectLock"); err != nil {
+		return err
+	}
+	bucket, err := mockedS3Provider.bucket(bucketname)
+	if err != nil {
+		return err
+	}
+	if bucket.objectLock == nil {
+		return fmt.Errorf("object lock is not enabled on bucket %s", bucketname)
+	}
+	bucket.objectLock = &objectLock
+	return nil
+}
+
+func (mockedS3Provider *MockedS3Client) SetLegalHold(bucketname string, status string) error {
+	mockedS3Provider.mutex.Lock()
+	defer mockedS3Provider.mutex.Unlock()
+	log.Println("set legal hold " + status + " on bucket " + bucketname)
+	if err := mockedS3Provider.fail("SetLegalHold"); err != nil {
+		return err
+	}
+	bucket, err := mockedS3Provider.bucket(bucketname)
+	if err != nil {
+		return err
+	}
+	if bucket.objectLock == nil {
+		return fmt.Errorf("object lock is not enabled on bucket %s", bucketname)
+	}
+	bucket.legalHold = status
+	return nil
+}
+
+func (mockedS3Provider *MockedS3Client) GetLifecycle(bucketname string) ([]LifecycleRule, error) {
+	mockedS3Provider.mutex.Lock()
+	defer mockedS3Provider.mutex.Unlock()
+	log.Println("get lifecycle rules of bucket " + bucketname)
+	if err := mockedS3Provider.fail("GetLifecycle"); err != nil {
+		return nil, err
+	}
+	bucket, err := mockedS3Provider.bucket(bucketname)
+	if err != nil {
+		return nil, err
+	}
+	return append([]LifecycleRule{}, bucket.lifecycle...), nil
+}
+
+func (mockedS3Provider *MockedS3Client) SetLifecycle(bucketname string, rules []LifecycleRule) error {
+	mockedS3Provider.mutex.Lock()
+	defer mockedS3Provider.mutex.Unlock()
+	log.Println("set " + fmt.Sprint(len(rules)) + " lifecycle rules on bucket " + bucketname)
+	if err := mockedS3Provider.fail("SetLifecycle"); err != nil {
+		return err
+	}
+	bucket, err := mockedS3Provider.bucket(bucketname)
+	if err != nil {
+		return err
+	}
+	bucket.lifecycle = append([]LifecycleRule{}, rules...)
+	return nil
+}
+
+func (mockedS3Provider *MockedS3Client) GetCORS(bucketname string) ([]CORSRule, error) {
+	mockedS3Provider.mutex.Lock()
+	defer mockedS3Provider.mutex.Unlock()
+	log.Println("get cors rules of bucket " + bucketname)
+	if err := mockedS3Provider.fail("GetCORS"); err != nil {
+		return nil, err
+	}
+	bucket, err := mockedS3Provider.bucket(bucketname)
+	if err != nil {
+		return nil, err
+	}
+	return append([]CORSRule{}, bucket.cors...), nil
+}
+
+func (mockedS3Provider *MockedS3Client) SetCORS(bucketname string, rules []CORSRule) error {
+	mockedS3Provider.mutex.Lock()
+	defer mockedS3Provider.mutex.Unlock()
+	log.Println("set " + fmt.Sprint(len(rules)) + " cors rules on bucket " + bucketname)
+	if err := mockedS3Provider.fail("SetCORS"); err != nil {
+		return err
+	}
+	bucket, err := mockedS3Provider.bucket(bucketname)
+	if err != nil {
+		return err
+	}
+	bucket.cors = append([]CORSRule{}, rules...)
+	return nil
+}
+
+func (mockedS3Provider *MockedS3Client) GetNotifications(bucketname string) ([]Notification, error) {
+	mockedS3Provider.mutex.Lock()
+	defer mockedS3Provider.mutex.Unlock()
+	log.Println("get notifications of bucket " + bucketname)
+	if err := mockedS3Provider.fail("GetNotifications"); err != nil {
+		return nil, err
+	}
+	bucket, err := mockedS3Provider.bucket(bucketname)
+	if err != nil {
+		return nil, err
+	}
+	return append([]Notification{}, bucket.notifications...), nil
+}
+
+func (mockedS3Provider *MockedS3Client) SetNotifications(bucketname string, notifications []Notification) error {
+	mockedS3Provider.mutex.Lock()
+	defer mockedS3Provider.mutex.Unlock()
+	log.Println("set " + fmt.Sprint(len(notifications)) + " notifications on bucket " + bucketname)
+	if err := mockedS3Provider.fail("SetNotifications"); err != nil {
+		return err
+	}
+	bucket, err := mockedS3Provider.bucket(bucketname)
+	if err != nil {
+		return err
+	}
+	bucket.notifications = append([]Notification{}, notifications...)
+	return nil
+}
+
+func (mockedS3Provider *MockedS3Client) GetEncryption(bucketname string) (*Encryption, error) {
+	mockedS3Provider.mutex.Lock()
+	defer mockedS3Provider.mutex.Unlock()
+	log.Println("get encryption of bucket " + bucketname)
+	if err := mockedS3Provider.fail("GetEncryption"); err != nil {
+		return nil, err
+	}
+	bucket, err := mockedS3Provider.bucket(bucketname)
+	if err != nil || bucket.encryption == nil {
+		return nil, err
+	}
+	encryption := *bucket.encryption
+	return &encryption, nil
+}
+
+func (mockedS3Provider *MockedS3Client) SetEncryption(bucketname string, encryption Encryption) error {
+	mockedS3Provider.mutex.Lock()
+	defer mockedS3Provider.mutex.Unlock()
+	log.Println("set encryption " + encryption.Type + " on bucket " + bucketname)
+	if err := mockedS3Provider.fail("SetEncryption"); err != nil {
+		return err
+	}
+	bucket, err := mockedS3Provider.bucket(bucketname)
+	if err != nil {
+		return err
+	}
+	if encryption.KMSKeyID != "" && !mockedS3Provider.kmsKeys[encryption.KMSKeyID] {
+		return fmt.Errorf("kms key %s does not exist", encryption.KMSKeyID)
+	}
+	bucket.encryption = &encryption
+	return nil
+}
+
+func (mockedS3Provider *MockedS3Client) RemoveEncryption(bucketname string) error {
+	mockedS3Provider.mutex.Lock()
+	defer mockedS3Provider.mutex.Unlock()
+	log.Println("remove encryption of bucket " + bucketname)
+	if err := mockedS3Provider.fail("RemoveEncryption"); err != nil {
+		return err
+	}
+	bucket, err := mockedS3Provider.bucket(bucketname)
+	if err != nil {
+		return err
+	}
+	bucket.encryption = nil
+	return nil
+}
+
+func (mockedS3Provider *MockedS3Client) CreateKMSKey(keyID string) error {
+	mockedS3Provider.mutex.Lock()
+	defer mockedS3Provider.mutex.Unlock()
+	log.Println("create kms key " + keyID)
+	if err := mockedS3Provider.fail("CreateKMSKey"); err != nil {
+		return err
+	}
+	mockedS3Provider.kmsKeys[keyID] = true
+	return nil
+}
+
+func (mockedS3Provider *MockedS3Client) GetBucketTags(bucketname string) (map[string]string, error) {
+	mockedS3Provider.mutex.Lock()
+	defer mockedS3Provider.mutex.Unlock()
+	log.Println("get tags of bucket " + bucketname)
+	if err := mockedS3Provider.fail("GetBucketTags"); err != nil {
+		return nil, err
+	}
+	bucket, err := mockedS3Provider.bucket(bucketname)
+	if err != nil {
+		return nil, err
+	}
+	tags := map[string]string{}
+	for key, value := range bucket.tags {
+		tags[key] = value
+	}
+	return tags, nil
+}
+
+func (mockedS3Provider *MockedS3Client) SetBucketTags(bucketname string, tags map[string]string) error {
+	mockedS3Provider.mutex.Lock()
+	defer mockedS3Provider.mutex.Unlock()
+	log.Println("set " + fmt.Sprint(len(tags)) + " tags on bucket " + bucketname)
+	if err := mockedS3Provider.fail("SetBucketTags"); err != nil {
+		return err
+	}
+	bucket, err := mockedS3Provider.bucket(bucketname)
+	if err != nil {
+		return err
+	}
+	bucket.tags = map[string]string{}
+	for key, value := range tags {
+		bucket.tags[key] = value
+	}
+	return nil
+}
+
+func (mockedS3Provider *MockedS3Client) GetBucketUsage(bucketname string) (Usage, error) {
+	mockedS3Provider.mutex.Lock()
+	defer mockedS3Provider.mutex.Unlock()
+	log.Println("get usage of bucket " + bucketname)
+	if err := mockedS3Provider.fail("GetBucketUsage"); err != nil {
+		return Usage{}, err
+	}
+	bucket, err := mockedS3Provider.bucket(bucketname)
+	if err != nil {
+		return Usage{}, err
+	}
+	usage := Usage{}
+	for _, content := range bucket.objects {
+		usage.Bytes += int64(len(content))
+		usage.Objects++
+	}
+	return usage, nil
+}
+
+func (mockedS3Provider *MockedS3Client) GetPrefixUsage(bucketname string, prefix string) (Usage, error) {
+	mockedS3Provider.mutex.Lock()
+	defer mockedS3Provider.mutex.Unlock()
+	log.Println("get usage of prefix " + prefix + " of bucket " + bucketname)
+	if err := mockedS3Provider.fail("GetPrefixUsage"); err != nil {
+		return Usage{}, err
+	}
+	bucket, err := mockedS3Provider.bucket(bucketname)
+	if err != nil {
+		return Usage{}, err
+	}
+	usage := Usage{}
+	for key, content := range bucket.objects {
+		if strings.HasPrefix(key, prefix) {
+			usage.Bytes += int64(len(content))
+			usage.Objects++
+		}
+	}
+	return usage, nil
+}
+
 func newMockedS3Client() *MockedS3Client {
-	return &MockedS3Client{}
+	return &MockedS3Client{
+		buckets:           map[string]*mockedBucket{},
+		identities:        map[string]S3Credentials{},
+		identityLocations: map[string]string{},
+		kmsKeys:           map[string]bool{},
+		grants:            map[string][]Grant{},
+		Failures:          map[string]error{},
+	}
 }
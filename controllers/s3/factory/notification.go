@@ -0,0 +1,112 @@
+package factory
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/notification"
+)
+
+// Notification sends the events of the objects matching the filters to a target
+// registered in the object store
+type Notification struct {
+	ID string
+	// ARN of the target, its service tells a queue (sqs), a topic (sns) or a
+	// lambda, e.g. arn:minio:sqs::pipelines:webhook
+	TargetARN string
+	// e.g. s3:ObjectCreated:*
+	Events []string
+	Prefix string
+	Suffix string
+}
+
+// SortNotifications sorts notifications by ID, to compare notification sets
+func SortNotifications(notifications []Notification) []Notification {
+	sorted := append([]Notification{}, notifications...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].ID < sorted[j].ID })
+	return sorted
+}
+
+// setFilterRule sets the prefix or suffix filter rule read from the object store
+func (notification *Notification) setFilterRule(name string, value string) {
+	switch strings.ToLower(name) {
+	case "prefix":
+		notification.Prefix = value
+	case "suffix":
+		notification.Suffix = value
+	}
+}
+
+func getNotifications(client *minio.Client, bucketname string) ([]Notification, error) {
+	configuration, err := client.GetBucketNotification(context.Background(), bucketname)
+	if err != nil {
+		return nil, err
+	}
+	notifications := []Notification{}
+	add := func(config notification.Config, arn string) {
+		events := []string{}
+		for _, event := range config.Events {
+			events = append(events, string(event))
+		}
+		n := Notification{ID: config.ID, TargetARN: arn, Events: events}
+		if config.Filter != nil {
+			for _, rule := range config.Filter.S3Key.FilterRules {
+				n.setFilterRule(rule.Name, rule.Value)
+			}
+		}
+		notifications = append(notifications, n)
+	}
+	for _, queue := range configuration.QueueConfigs {
+		add(queue.Config, queue.Queue)
+	}
+	for _, topic := range configuration.TopicConfigs {
+		add(topic.Config, topic.Topic)
+	}
+	for _, lambda := range configuration.LambdaConfigs {
+		add(lambda.Config, lambda.Lambda)
+	}
+	return notifications, nil
+}
+
+func setNotifications(client *minio.Client, bucketname string, notifications []Notification) error {
+	log.Println("set " + fmt.Sprint(len(notifications)) + " notifications on bucket " + bucketname)
+	configuration := notification.Configuration{}
+	for _, n := range notifications {
+		arn, err := notification.NewArnFromString(n.TargetARN)
+		if err != nil {
+			return fmt.Errorf("invalid target %s of notification %s: %w", n.TargetARN, n.ID, err)
+		}
+		config := notification.NewConfig(arn)
+		config.ID = n.ID
+		for _, event := range n.Events {
+			config.AddEvents(notification.EventType(event))
+		}
+		if n.Prefix != "" {
+			config.AddFilterPrefix(n.Prefix)
+		}
+		if n.Suffix != "" {
+			config.AddFilterSuffix(n.Suffix)
+		}
+		if len(config.Filter.S3Key.FilterRules) == 0 {
+			config.Filter = nil
+		}
+		switch arn.Service {
+		case "sqs":
+			configuration.AddQueue(config)
+		case "sns":
+			configuration.AddTopic(config)
+		case "lambda":
+			configuration.AddLambda(config)
+		default:
+			return fmt.Errorf("target %s of notification %s is neither a queue, a topic nor a lambda", n.TargetARN, n.ID)
+		}
+	}
+	if len(notifications) == 0 {
+		return client.RemoveAllBucketNotification(context.Background(), bucketname)
+	}
+	return client.SetBucketNotification(context.Background(), bucketname, configuration)
+}
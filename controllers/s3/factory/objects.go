@@ -0,0 +1,70 @@
+package factory
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// Objects of the providers relying on minio-go.
+
+func getObject(client *minio.Client, bucketname string, key string) ([]byte, error) {
+	object, err := client.GetObject(context.Background(), bucketname, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("can't get object %s %s: %w", bucketname, key, err)
+	}
+	defer object.Close()
+	content, err := io.ReadAll(object)
+	if err != nil {
+		return nil, fmt.Errorf("can't read object %s %s: %w", bucketname, key, err)
+	}
+	return content, nil
+}
+
+func openObject(client *minio.Client, bucketname string, key string) (io.ReadCloser, error) {
+	object, err := client.GetObject(context.Background(), bucketname, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("can't get object %s %s: %w", bucketname, key, err)
+	}
+	return object, nil
+}
+
+func uploadObject(client *minio.Client, bucketname string, key string, reader io.Reader, size int64) error {
+	log.Println("upload object " + key + " in bucket " + bucketname)
+	_, err := client.PutObject(context.Background(), bucketname, key, reader, size, minio.PutObjectOptions{})
+	if err != nil {
+		return fmt.Errorf("can't upload object %s %s: %w", bucketname, key, err)
+	}
+	return nil
+}
+
+func listObjects(client *minio.Client, bucketname string, prefix string, startAfter string, maxKeys int) ([]Object, error) {
+	objects := []Object{}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	options := minio.ListObjectsOptions{Prefix: prefix, StartAfter: startAfter, Recursive: true, MaxKeys: maxKeys}
+	for object := range client.ListObjects(ctx, bucketname, options) {
+		if object.Err != nil {
+			return nil, object.Err
+		}
+		objects = append(objects, Object{Key: object.Key, Size: object.Size})
+		if len(objects) == maxKeys {
+			break
+		}
+	}
+	return objects, nil
+}
+
+func copyObject(client *minio.Client, srcBucketname string, srcKey string, bucketname string, key string) error {
+	log.Println("copy object " + srcBucketname + "/" + srcKey + " to " + bucketname + "/" + key)
+	_, err := client.CopyObject(context.Background(),
+		minio.CopyDestOptions{Bucket: bucketname, Object: key},
+		minio.CopySrcOptions{Bucket: srcBucketname, Object: srcKey})
+	if err != nil {
+		return fmt.Errorf("can't copy object %s %s: %w", srcBucketname, srcKey, err)
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package factory
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// FolderKey returns the key of the folder marker of a path, the path followed
+// by a slash as S3 browsers expect, e.g. diffusion/2023/ for diffusion/2023.
+func FolderKey(name string) (string, error) {
+	segments := []string{}
+	for _, segment := range strings.Split(name, "/") {
+		if segment == "" {
+			continue
+		}
+		if segment == "." || segment == ".." {
+			return "", fmt.Errorf("invalid path %s", name)
+		}
+		segments = append(segments, segment)
+	}
+	if len(segments) == 0 {
+		return "", fmt.Errorf("invalid path %s", name)
+	}
+	return strings.Join(segments, "/") + "/", nil
+}
+
+// Folders are plain S3 objects, shared by the providers relying on minio-go.
+
+func createPath(client *minio.Client, bucketname string, name string) error {
+	key, err := FolderKey(name)
+	if err != nil {
+		return err
+	}
+	return putObject(client, bucketname, key, nil)
+}
+
+func pathExists(client *minio.Client, bucketname string, name string) (bool, error) {
+	key, err := FolderKey(name)
+	if err != nil {
+		return false, err
+	}
+	found := false
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	for object := range client.ListObjects(ctx, bucketname, minio.ListObjectsOptions{Prefix: key, MaxKeys: 1}) {
+		if object.Err != nil {
+			return false, object.Err
+		}
+		found = true
+		break
+	}
+	return found, nil
+}
+
+func putObject(client *minio.Client, bucketname string, key string, content []byte) error {
+	log.Println("put object " + key + " in bucket " + bucketname)
+	_, err := client.PutObject(context.Background(), bucketname, key, bytes.NewReader(content), int64(len(content)), minio.PutObjectOptions{})
+	if err != nil {
+		return fmt.Errorf("error on object creation %s %s: %w", bucketname, key, err)
+	}
+	return nil
+}
+
+func prefixUsage(client *minio.Client, bucketname string, prefix string) (Usage, error) {
+	usage := Usage{}
+	for object := range client.ListObjects(context.Background(), bucketname, minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+		if object.Err != nil {
+			return Usage{}, object.Err
+		}
+		usage.Bytes += object.Size
+		usage.Objects++
+	}
+	return usage, nil
+}
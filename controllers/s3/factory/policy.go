@@ -0,0 +1,252 @@
+package factory
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+const (
+	// access modes of a bucket path
+	AccessPrivate    = "private"
+	AccessPublicRead = "public-read"
+	AccessShared     = "shared"
+
+	// Sid prefix of the bucket policy statements generated from the path access modes,
+	// the other statements of the bucket policy are left untouched
+	pathAccessSidPrefix = "OnyxiaPath"
+)
+
+// PathAccess is the access mode of a path of a bucket
+type PathAccess struct {
+	// path in the bucket, the whole bucket if empty
+	Path string
+	Mode string
+	// identities the path is shared with, shared mode only
+	Identities []string
+	// principals, in the object store syntax, the path is shared with, shared mode only
+	Groups []string
+}
+
+// PolicyDocument is an IAM-style policy, used as identity policy or bucket policy
+type PolicyDocument struct {
+	Version   string            `json:"Version"`
+	Statement []PolicyStatement `json:"Statement"`
+}
+
+type PolicyStatement struct {
+	Sid       string                             `json:"Sid,omitempty"`
+	Effect    string                             `json:"Effect"`
+	Principal PolicyPrincipal                    `json:"Principal,omitempty"`
+	Action    PolicyValues                       `json:"Action"`
+	Resource  PolicyValues                       `json:"Resource"`
+	Condition map[string]map[string]PolicyValues `json:"Condition,omitempty"`
+}
+
+// PolicyValues is a list of values, that object stores may return as a single string
+type PolicyValues []string
+
+func (values *PolicyValues) UnmarshalJSON(data []byte) error {
+	var value string
+	if json.Unmarshal(data, &value) == nil {
+		*values = PolicyValues{value}
+		return nil
+	}
+	return json.Unmarshal(data, (*[]string)(values))
+}
+
+// PolicyPrincipal maps a principal type, usually AWS, to principals
+type PolicyPrincipal map[string]PolicyValues
+
+func (principal *PolicyPrincipal) UnmarshalJSON(data []byte) error {
+	var value string
+	if json.Unmarshal(data, &value) == nil {
+		*principal = PolicyPrincipal{"AWS": {value}}
+		return nil
+	}
+	return json.Unmarshal(data, (*map[string]PolicyValues)(principal))
+}
+
+// identityPolicy allows every s3 action on the bucket and its objects, or only on
+// the objects of the prefix, when the identity owns a prefix of a shared bucket
+func identityPolicy(bucketname string, prefix string, principal PolicyPrincipal) []byte {
+	policy, _ := json.Marshal(PolicyDocument{
+		Version:   "2012-10-17",
+		Statement: identityStatements(bucketname, prefix, principal),
+	})
+	return policy
+}
+
+func identityStatements(bucketname string, prefix string, principal PolicyPrincipal) []PolicyStatement {
+	if prefix == "" {
+		return []PolicyStatement{{
+			Effect:    "Allow",
+			Principal: principal,
+			Action:    []string{"s3:*"},
+			Resource:  []string{"arn:aws:s3:::" + bucketname, "arn:aws:s3:::" + bucketname + "/*"},
+		}}
+	}
+	return []PolicyStatement{{
+		Effect:    "Allow",
+		Principal: principal,
+		Action:    []string{"s3:GetBucketLocation", "s3:ListBucket", "s3:ListBucketMultipartUploads"},
+		Resource:  []string{"arn:aws:s3:::" + bucketname},
+		Condition: map[string]map[string]PolicyValues{"StringLike": {"s3:prefix": {prefix + "*"}}},
+	}, {
+		Effect:    "Allow",
+		Principal: principal,
+		Action:    []string{"s3:*"},
+		Resource:  []string{"arn:aws:s3:::" + bucketname + "/" + prefix + "*"},
+	}}
+}
+
+// pathAccessSid returns the Sid prefix of the path access statements of the
+// workspace owning the prefix, of the whole bucket if empty
+func pathAccessSid(prefix string) string {
+	if prefix == "" {
+		return pathAccessSidPrefix
+	}
+	return pathAccessSidPrefix + "/" + prefix
+}
+
+// pathAccessStatements turns the access modes of the paths, relative to the prefix
+// of the workspace in a shared bucket, into bucket policy statements. principals
+// maps the identities and groups of a shared path, to nil when none can be matched.
+func pathAccessStatements(bucketname string, workspacePrefix string, access []PathAccess, principals func(PathAccess) (PolicyPrincipal, error)) ([]PolicyStatement, error) {
+	statements := []PolicyStatement{}
+	sid := pathAccessSid(workspacePrefix)
+	for i, pathAccess := range access {
+		prefix := strings.Trim(pathAccess.Path, "/")
+		if prefix != "" {
+			prefix = prefix + "/"
+		}
+		prefix = workspacePrefix + prefix
+		objects := "arn:aws:s3:::" + bucketname + "/" + prefix + "*"
+		var principal PolicyPrincipal
+		var actions PolicyValues
+		switch pathAccess.Mode {
+		case AccessPrivate, "":
+			continue
+		case AccessPublicRead:
+			principal = PolicyPrincipal{"AWS": {"*"}}
+			actions = PolicyValues{"s3:GetObject"}
+		case AccessShared:
+			if len(pathAccess.Identities) == 0 && len(pathAccess.Groups) == 0 {
+				continue
+			}
+			var err error
+			principal, err = principals(pathAccess)
+			if err != nil {
+				return nil, err
+			}
+			if principal == nil {
+				// none of the principals can be matched by the object store
+				continue
+			}
+			actions = PolicyValues{"s3:DeleteObject", "s3:GetObject", "s3:PutObject"}
+		default:
+			return nil, fmt.Errorf("unknown access mode %s for path %s", pathAccess.Mode, pathAccess.Path)
+		}
+		list := PolicyStatement{
+			Sid:       fmt.Sprintf("%sList%d", sid, i),
+			Effect:    "Allow",
+			Principal: principal,
+			Action:    PolicyValues{"s3:ListBucket"},
+			Resource:  PolicyValues{"arn:aws:s3:::" + bucketname},
+		}
+		if prefix != "" {
+			list.Condition = map[string]map[string]PolicyValues{"StringLike": {"s3:prefix": {prefix + "*"}}}
+		}
+		statements = append(statements, list, PolicyStatement{
+			Sid:       fmt.Sprintf("%sObjects%d", sid, i),
+			Effect:    "Allow",
+			Principal: principal,
+			Action:    actions,
+			Resource:  PolicyValues{objects},
+		})
+	}
+	return statements, nil
+}
+
+// replaceStatements replaces, in the current bucket policy, the statements whose Sid
+// starts with sidPrefix. It returns the resulting policy, empty when no statement is
+// left, and whether the replaced statements differed from the given ones.
+func replaceStatements(current string, sidPrefix string, statements []PolicyStatement) (string, bool, error) {
+	policy := PolicyDocument{Version: "2012-10-17"}
+	if current != "" {
+		err := json.Unmarshal([]byte(current), &policy)
+		if err != nil {
+			return "", false, fmt.Errorf("can't read bucket policy: %w", err)
+		}
+	}
+	kept := []PolicyStatement{}
+	replaced := []PolicyStatement{}
+	for _, statement := range policy.Statement {
+		if strings.HasPrefix(statement.Sid, sidPrefix) {
+			replaced = append(replaced, statement)
+		} else {
+			kept = append(kept, statement)
+		}
+	}
+	if sameStatements(replaced, statements) {
+		return current, false, nil
+	}
+	policy.Statement = append(kept, statements...)
+	if len(policy.Statement) == 0 {
+		return "", true, nil
+	}
+	document, err := json.Marshal(policy)
+	return string(document), true, err
+}
+
+// sameStatements compares statements regardless of the order of statements and values
+func sameStatements(a []PolicyStatement, b []PolicyStatement) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	return reflect.DeepEqual(normalizeStatements(a), normalizeStatements(b))
+}
+
+func normalizeStatements(statements []PolicyStatement) []PolicyStatement {
+	normalized := []PolicyStatement{}
+	for _, statement := range statements {
+		statement.Action = sortedValues(statement.Action)
+		statement.Resource = sortedValues(statement.Resource)
+		principal := PolicyPrincipal{}
+		for k, v := range statement.Principal {
+			principal[k] = sortedValues(v)
+		}
+		statement.Principal = principal
+		condition := map[string]map[string]PolicyValues{}
+		for operator, values := range statement.Condition {
+			condition[operator] = map[string]PolicyValues{}
+			for k, v := range values {
+				condition[operator][k] = sortedValues(v)
+			}
+		}
+		statement.Condition = condition
+		normalized = append(normalized, statement)
+	}
+	sort.Slice(normalized, func(i, j int) bool { return normalized[i].Sid < normalized[j].Sid })
+	return normalized
+}
+
+func sortedValues(values PolicyValues) PolicyValues {
+	sorted := append(PolicyValues{}, values...)
+	sort.Strings(sorted)
+	return sorted
+}
+
+// generateSecretKey returns a random secret key for a new identity
+func generateSecretKey() (string, error) {
+	secret := make([]byte, 30)
+	_, err := rand.Read(secret)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(secret), nil
+}
@@ -0,0 +1,137 @@
+package factory
+
+import (
+	"encoding/json"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Bucket policy", func() {
+	access := []PathAccess{
+		{Path: "diffusion/", Mode: AccessPublicRead},
+		{Path: "sensible", Mode: AccessPrivate},
+		{Path: "partage", Mode: AccessShared, Identities: []string{"user-toto"}},
+	}
+	principals := func(pathAccess PathAccess) (PolicyPrincipal, error) {
+		return PolicyPrincipal{"AWS": pathAccess.Identities}, nil
+	}
+
+	It("turns path access modes into statements", func() {
+		statements, err := pathAccessStatements("bucket-titi", "", access, principals)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(statements).To(HaveLen(4))
+		Expect(statements[0].Condition).To(HaveKeyWithValue("StringLike", HaveKeyWithValue("s3:prefix", PolicyValues{"diffusion/*"})))
+		Expect(statements[1].Principal).To(Equal(PolicyPrincipal{"AWS": {"*"}}))
+		Expect(statements[1].Action).To(Equal(PolicyValues{"s3:GetObject"}))
+		Expect(statements[1].Resource).To(Equal(PolicyValues{"arn:aws:s3:::bucket-titi/diffusion/*"}))
+		Expect(statements[3].Principal).To(Equal(PolicyPrincipal{"AWS": {"user-toto"}}))
+		Expect(statements[3].Resource).To(Equal(PolicyValues{"arn:aws:s3:::bucket-titi/partage/*"}))
+
+		_, err = pathAccessStatements("bucket-titi", "", []PathAccess{{Path: "x", Mode: "public-write"}}, principals)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("scopes statements to the prefix of a workspace in a shared bucket", func() {
+		statements, err := pathAccessStatements("bucket-shared", "titi/", access, principals)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(statements[0].Sid).To(Equal("OnyxiaPath/titi/List0"))
+		Expect(statements[0].Condition).To(HaveKeyWithValue("StringLike", HaveKeyWithValue("s3:prefix", PolicyValues{"titi/diffusion/*"})))
+		Expect(statements[1].Resource).To(Equal(PolicyValues{"arn:aws:s3:::bucket-shared/titi/diffusion/*"}))
+
+		identity := identityStatements("bucket-shared", "titi/", nil)
+		Expect(identity).To(HaveLen(2))
+		Expect(identity[0].Condition).To(HaveKeyWithValue("StringLike", HaveKeyWithValue("s3:prefix", PolicyValues{"titi/*"})))
+		Expect(identity[1].Resource).To(Equal(PolicyValues{"arn:aws:s3:::bucket-shared/titi/*"}))
+		Expect(identityStatements("bucket-titi", "", nil)[0].Resource).To(ContainElement("arn:aws:s3:::bucket-titi/*"))
+	})
+
+	It("replaces the managed statements and keeps the others", func() {
+		foreign := `{"Version":"2012-10-17","Statement":[{"Sid":"Admin","Effect":"Allow","Principal":"*","Action":"s3:GetBucketLocation","Resource":"arn:aws:s3:::bucket-titi"}]}`
+		statements, err := pathAccessStatements("bucket-titi", "", access, principals)
+		Expect(err).NotTo(HaveOccurred())
+
+		policy, changed, err := replaceStatements(foreign, pathAccessSidPrefix, statements)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(changed).To(BeTrue())
+		document := PolicyDocument{}
+		Expect(json.Unmarshal([]byte(policy), &document)).To(Succeed())
+		Expect(document.Statement).To(HaveLen(5))
+		Expect(document.Statement[0].Sid).To(Equal("Admin"))
+		Expect(document.Statement[0].Principal).To(Equal(PolicyPrincipal{"AWS": {"*"}}))
+
+		_, changed, err = replaceStatements(policy, pathAccessSidPrefix, statements)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(changed).To(BeFalse())
+
+		policy, changed, err = replaceStatements(policy, pathAccessSidPrefix, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(changed).To(BeTrue())
+		Expect(json.Unmarshal([]byte(policy), &document)).To(Succeed())
+		Expect(document.Statement).To(HaveLen(1))
+
+		policy, changed, err = replaceStatements("", pathAccessSidPrefix, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(changed).To(BeFalse())
+		Expect(policy).To(BeEmpty())
+	})
+
+	It("detects and corrects drift of the bucket policy", func() {
+		standIn := newS3StandIn()
+		defer standIn.close()
+		s3Client, err := GetS3Client("minio", &S3Config{
+			S3Provider:    "minio",
+			S3UrlEndpoint: standIn.endpoint(),
+			Region:        "us-east-1",
+			AccessKey:     "access",
+			SecretKey:     "secret",
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(s3Client.CreateBucket("bucket-titi")).To(Succeed())
+
+		changed, err := s3Client.SetPathAccess("bucket-titi", "", access)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(changed).To(BeTrue())
+		changed, err = s3Client.SetPathAccess("bucket-titi", "", access)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(changed).To(BeFalse())
+
+		// someone makes the private path public
+		document := PolicyDocument{}
+		Expect(json.Unmarshal([]byte(standIn.buckets["bucket-titi"].policy), &document)).To(Succeed())
+		document.Statement[1].Resource = PolicyValues{"arn:aws:s3:::bucket-titi/*"}
+		drifted, _ := json.Marshal(document)
+		standIn.buckets["bucket-titi"].policy = string(drifted)
+
+		changed, err = s3Client.SetPathAccess("bucket-titi", "", access)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(changed).To(BeTrue())
+		Expect(standIn.buckets["bucket-titi"].policy).NotTo(ContainSubstring(`"arn:aws:s3:::bucket-titi/*"`))
+
+		changed, err = s3Client.SetPathAccess("bucket-titi", "", nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(changed).To(BeTrue())
+		Expect(standIn.buckets["bucket-titi"].policy).To(BeEmpty())
+	})
+
+	It("turns grants into identity policy statements", func() {
+		policy, err := grantPolicy([]Grant{
+			{Bucketname: "bucket-titi", Paths: []string{"diffusion/", "sensible/2023/"}, Access: GrantRead},
+			{Bucketname: "bucket-shared", Paths: []string{"tata/partage/"}, Access: GrantReadWrite},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		document := PolicyDocument{}
+		Expect(json.Unmarshal(policy, &document)).To(Succeed())
+		Expect(document.Statement).To(HaveLen(4))
+		Expect(document.Statement[0].Condition).To(HaveKeyWithValue("StringLike", HaveKeyWithValue("s3:prefix", PolicyValues{"diffusion/*", "sensible/2023/*"})))
+		Expect(document.Statement[1].Action).To(Equal(PolicyValues{"s3:GetObject"}))
+		Expect(document.Statement[3].Action).To(ContainElement("s3:PutObject"))
+		Expect(document.Statement[3].Resource).To(Equal(PolicyValues{"arn:aws:s3:::bucket-shared/tata/partage/*"}))
+
+		policy, err = grantPolicy(nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(policy).To(BeNil())
+		_, err = grantPolicy([]Grant{{Bucketname: "bucket-titi", Paths: []string{"diffusion/"}, Access: "admin"}})
+		Expect(err).To(HaveOccurred())
+	})
+})
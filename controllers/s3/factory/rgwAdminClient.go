@@ -0,0 +1,168 @@
+package factory
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	v4 "github.com/aws/aws-sdk-go/aws/signer/v4"
+)
+
+// rgwAdminClient talks to the RADOS Gateway Admin Ops API.
+// See https://docs.ceph.com/en/latest/radosgw/adminops/
+type rgwAdminClient struct {
+	endpoint   string
+	adminPath  string
+	region     string
+	signer     *v4.Signer
+	httpClient *http.Client
+}
+
+// RgwQuota is the quota document used by the Admin Ops API for buckets and users.
+// A negative value means unlimited.
+type RgwQuota struct {
+	Enabled    bool  `json:"enabled"`
+	MaxSize    int64 `json:"max_size"`
+	MaxObjects int64 `json:"max_objects"`
+}
+
+type rgwBucketStats struct {
+	Bucket      string   `json:"bucket"`
+	Owner       string   `json:"owner"`
+	BucketQuota RgwQuota `json:"bucket_quota"`
+	Usage       map[string]struct {
+		SizeActual int64 `json:"size_actual"`
+		NumObjects int64 `json:"num_objects"`
+	} `json:"usage"`
+}
+
+// RgwUser is the subset of the user document the operator relies on.
+type RgwUser struct {
+	UserID      string   `json:"user_id"`
+	DisplayName string   `json:"display_name"`
+	Keys        []rgwKey `json:"keys"`
+}
+
+// rgwError is returned when the Admin Ops API answers with an error status.
+type rgwError struct {
+	StatusCode int
+	Code       string `json:"Code"`
+}
+
+func (err *rgwError) Error() string {
+	return fmt.Sprintf("rgw admin api returned %d %s", err.StatusCode, err.Code)
+}
+
+func isRgwNotFound(err error) bool {
+	rgwErr, ok := err.(*rgwError)
+	return ok && rgwErr.StatusCode == http.StatusNotFound
+}
+
+func newRgwAdminClient(endpoint string, adminPath string, region string, accessKey string, secretKey string, httpClient *http.Client) *rgwAdminClient {
+	return &rgwAdminClient{
+		endpoint:   strings.TrimSuffix(endpoint, "/"),
+		adminPath:  "/" + strings.Trim(adminPath, "/"),
+		region:     region,
+		signer:     v4.NewSigner(credentials.NewStaticCredentials(accessKey, secretKey, "")),
+		httpClient: httpClient,
+	}
+}
+
+// do sends a SigV4 signed request to the admin API and decodes the JSON answer in out, if any.
+func (rgw *rgwAdminClient) do(method string, resource string, query url.Values, body interface{}, out interface{}) error {
+	var payload []byte
+	if body != nil {
+		var err error
+		payload, err = json.Marshal(body)
+		if err != nil {
+			return err
+		}
+	}
+	query.Set("format", "json")
+	request, err := http.NewRequest(method, rgw.endpoint+rgw.adminPath+"/"+resource+"?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	var bodyReader io.ReadSeeker
+	if payload != nil {
+		bodyReader = bytes.NewReader(payload)
+		request.ContentLength = int64(len(payload))
+		request.Header.Set("Content-Type", "application/json")
+	}
+	_, err = rgw.signer.Sign(request, bodyReader, "s3", rgw.region, time.Now())
+	if err != nil {
+		return err
+	}
+	response, err := rgw.httpClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	content, err := io.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+	if response.StatusCode >= 300 {
+		rgwErr := &rgwError{StatusCode: response.StatusCode}
+		_ = json.Unmarshal(content, rgwErr)
+		return rgwErr
+	}
+	if out != nil && len(content) > 0 {
+		return json.Unmarshal(content, out)
+	}
+	return nil
+}
+
+func (rgw *rgwAdminClient) getBucket(name string) (*rgwBucketStats, error) {
+	stats := &rgwBucketStats{}
+	err := rgw.do(http.MethodGet, "bucket", url.Values{"bucket": {name}, "stats": {"true"}}, nil, stats)
+	return stats, err
+}
+
+func (rgw *rgwAdminClient) setBucketQuota(uid string, name string, quota RgwQuota) error {
+	return rgw.do(http.MethodPut, "bucket", url.Values{"quota": {""}, "uid": {uid}, "bucket": {name}}, quota, nil)
+}
+
+func (rgw *rgwAdminClient) getUser(uid string) (*RgwUser, error) {
+	user := &RgwUser{}
+	err := rgw.do(http.MethodGet, "user", url.Values{"uid": {uid}}, nil, user)
+	return user, err
+}
+
+func (rgw *rgwAdminClient) createUser(uid string, displayName string) (*RgwUser, error) {
+	user := &RgwUser{}
+	err := rgw.do(http.MethodPut, "user", url.Values{"uid": {uid}, "display-name": {displayName}}, nil, user)
+	return user, err
+}
+
+func (rgw *rgwAdminClient) deleteUser(uid string) error {
+	return rgw.do(http.MethodDelete, "user", url.Values{"uid": {uid}}, nil, nil)
+}
+
+func (rgw *rgwAdminClient) setUserQuota(uid string, quota RgwQuota) error {
+	return rgw.do(http.MethodPut, "user", url.Values{"quota": {""}, "uid": {uid}, "quota-type": {"user"}}, quota, nil)
+}
+
+// rgwKey is an s3 key pair of a user
+type rgwKey struct {
+	User      string `json:"user"`
+	AccessKey string `json:"access_key"`
+	SecretKey string `json:"secret_key"`
+}
+
+// createKey generates a new s3 key pair for the user and returns all its keys
+func (rgw *rgwAdminClient) createKey(uid string) ([]rgwKey, error) {
+	keys := []rgwKey{}
+	err := rgw.do(http.MethodPut, "user", url.Values{"key": {""}, "uid": {uid}, "key-type": {"s3"}, "generate-key": {"True"}}, nil, &keys)
+	return keys, err
+}
+
+func (rgw *rgwAdminClient) removeKey(uid string, accessKey string) error {
+	return rgw.do(http.MethodDelete, "user", url.Values{"key": {""}, "uid": {uid}, "key-type": {"s3"}, "access-key": {accessKey}}, nil, nil)
+}
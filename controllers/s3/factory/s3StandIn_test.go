@@ -0,0 +1,473 @@
+package factory
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// s3StandIn is a minimal path-style S3-compatible server used to exercise the
+// providers without a real object store. Requests are not authenticated.
+type s3StandIn struct {
+	mu      sync.Mutex
+	buckets map[string]*standInBucket
+	server  *httptest.Server
+	// minio admin users, with their comma separated policies, and canned policies
+	users          map[string]string
+	cannedPolicies map[string]string
+}
+
+type standInBucket struct {
+	objects map[string][]byte
+	tags    map[string]string
+	policy  string
+	// minio admin quota, as sent by madmin
+	quota string
+	// versioning status and object lock configuration, as sent by the client
+	versioning string
+	objectLock string
+	legalHolds map[string]string
+	lifecycle  string
+	cors       string
+	// notification configuration, as sent by the client
+	notification string
+	encryption   string
+}
+
+type standInTagging struct {
+	XMLName xml.Name `xml:"Tagging"`
+	TagSet  []struct {
+		Key   string `xml:"Key"`
+		Value string `xml:"Value"`
+	} `xml:"TagSet>Tag"`
+}
+
+type standInListResult struct {
+	XMLName     xml.Name `xml:"ListBucketResult"`
+	Name        string   `xml:"Name"`
+	Prefix      string   `xml:"Prefix"`
+	KeyCount    int      `xml:"KeyCount"`
+	MaxKeys     int      `xml:"MaxKeys"`
+	IsTruncated bool     `xml:"IsTruncated"`
+	Contents    []struct {
+		Key          string `xml:"Key"`
+		Size         int    `xml:"Size"`
+		ETag         string `xml:"ETag"`
+		LastModified string `xml:"LastModified"`
+	} `xml:"Contents"`
+}
+
+func newS3StandIn() *s3StandIn {
+	standIn := &s3StandIn{buckets: map[string]*standInBucket{}, users: map[string]string{}, cannedPolicies: map[string]string{}}
+	standIn.server = httptest.NewServer(standIn)
+	return standIn
+}
+
+// newTLSS3StandIn starts the stand-in over https with the given server configuration.
+func newTLSS3StandIn(tlsConfig *tls.Config) *s3StandIn {
+	standIn := &s3StandIn{buckets: map[string]*standInBucket{}, users: map[string]string{}, cannedPolicies: map[string]string{}}
+	standIn.server = httptest.NewUnstartedServer(standIn)
+	standIn.server.TLS = tlsConfig
+	standIn.server.StartTLS()
+	return standIn
+}
+
+// endpoint returns the host:port of the stand-in, the way S3Config expects it.
+func (standIn *s3StandIn) endpoint() string {
+	return strings.TrimPrefix(strings.TrimPrefix(standIn.server.URL, "http://"), "https://")
+}
+
+func (standIn *s3StandIn) close() {
+	standIn.server.Close()
+}
+
+func (standIn *s3StandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// read before locking, the body may be streamed from an object of the stand-in
+	body, _ := io.ReadAll(r.Body)
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	standIn.mu.Lock()
+	defer standIn.mu.Unlock()
+
+	if strings.HasPrefix(r.URL.Path, "/minio/admin/v3/") {
+		standIn.serveAdmin(w, r)
+		return
+	}
+
+	path := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)
+	bucketName := path[0]
+	key := ""
+	if len(path) == 2 {
+		key = path[1]
+	}
+	bucket, found := standIn.buckets[bucketName]
+	query := r.URL.Query()
+
+	switch {
+	case key == "" && r.Method == http.MethodPut && query.Has("tagging"):
+		if !found {
+			writeStandInError(w, http.StatusNotFound, "NoSuchBucket")
+			return
+		}
+		tagging := standInTagging{}
+		if err := xml.NewDecoder(r.Body).Decode(&tagging); err != nil {
+			writeStandInError(w, http.StatusBadRequest, "MalformedXML")
+			return
+		}
+		bucket.tags = map[string]string{}
+		for _, tag := range tagging.TagSet {
+			bucket.tags[tag.Key] = tag.Value
+		}
+	case key == "" && r.Method == http.MethodDelete && query.Has("tagging"):
+		if !found {
+			writeStandInError(w, http.StatusNotFound, "NoSuchBucket")
+			return
+		}
+		bucket.tags = map[string]string{}
+		w.WriteHeader(http.StatusNoContent)
+	case key == "" && r.Method == http.MethodGet && query.Has("tagging"):
+		if !found {
+			writeStandInError(w, http.StatusNotFound, "NoSuchBucket")
+			return
+		}
+		if len(bucket.tags) == 0 {
+			writeStandInError(w, http.StatusNotFound, "NoSuchTagSet")
+			return
+		}
+		tagging := standInTagging{}
+		for k, v := range bucket.tags {
+			tagging.TagSet = append(tagging.TagSet, struct {
+				Key   string `xml:"Key"`
+				Value string `xml:"Value"`
+			}{k, v})
+		}
+		writeStandInXML(w, tagging)
+	case key == "" && query.Has("policy"):
+		if !found {
+			writeStandInError(w, http.StatusNotFound, "NoSuchBucket")
+			return
+		}
+		switch r.Method {
+		case http.MethodPut:
+			content, _ := io.ReadAll(r.Body)
+			bucket.policy = string(content)
+			w.WriteHeader(http.StatusNoContent)
+		case http.MethodDelete:
+			bucket.policy = ""
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			if bucket.policy == "" {
+				writeStandInError(w, http.StatusNotFound, "NoSuchBucketPolicy")
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = io.WriteString(w, bucket.policy)
+		}
+	case key == "" && query.Has("versioning"):
+		if !found {
+			writeStandInError(w, http.StatusNotFound, "NoSuchBucket")
+			return
+		}
+		if r.Method == http.MethodPut {
+			configuration := struct {
+				Status string `xml:"Status"`
+			}{}
+			_ = xml.NewDecoder(r.Body).Decode(&configuration)
+			bucket.versioning = configuration.Status
+			return
+		}
+		w.Header().Set("Content-Type", "application/xml")
+		_, _ = io.WriteString(w, "<VersioningConfiguration><Status>"+bucket.versioning+"</Status></VersioningConfiguration>")
+	case key == "" && query.Has("object-lock"):
+		if !found {
+			writeStandInError(w, http.StatusNotFound, "NoSuchBucket")
+			return
+		}
+		if bucket.objectLock == "" {
+			writeStandInError(w, http.StatusNotFound, "ObjectLockConfigurationNotFoundError")
+			return
+		}
+		if r.Method == http.MethodPut {
+			content, _ := io.ReadAll(r.Body)
+			bucket.objectLock = string(content)
+			return
+		}
+		w.Header().Set("Content-Type", "application/xml")
+		_, _ = io.WriteString(w, bucket.objectLock)
+	case key != "" && query.Has("legal-hold") && r.Method == http.MethodPut:
+		if !found || bucket.objectLock == "" {
+			writeStandInError(w, http.StatusBadRequest, "InvalidRequest")
+			return
+		}
+		legalHold := struct {
+			Status string `xml:"Status"`
+		}{}
+		_ = xml.NewDecoder(r.Body).Decode(&legalHold)
+		bucket.legalHolds[key] = legalHold.Status
+	case key == "" && query.Has("lifecycle"):
+		if !found {
+			writeStandInError(w, http.StatusNotFound, "NoSuchBucket")
+			return
+		}
+		switch r.Method {
+		case http.MethodPut:
+			content, _ := io.ReadAll(r.Body)
+			bucket.lifecycle = string(content)
+		case http.MethodDelete:
+			bucket.lifecycle = ""
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			if bucket.lifecycle == "" {
+				writeStandInError(w, http.StatusNotFound, "NoSuchLifecycleConfiguration")
+				return
+			}
+			w.Header().Set("Content-Type", "application/xml")
+			_, _ = io.WriteString(w, bucket.lifecycle)
+		}
+	case key == "" && query.Has("cors"):
+		if !found {
+			writeStandInError(w, http.StatusNotFound, "NoSuchBucket")
+			return
+		}
+		switch r.Method {
+		case http.MethodPut:
+			content, _ := io.ReadAll(r.Body)
+			bucket.cors = string(content)
+		case http.MethodDelete:
+			bucket.cors = ""
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			if bucket.cors == "" {
+				writeStandInError(w, http.StatusNotFound, "NoSuchCORSConfiguration")
+				return
+			}
+			w.Header().Set("Content-Type", "application/xml")
+			_, _ = io.WriteString(w, bucket.cors)
+		}
+	case key == "" && query.Has("notification"):
+		if !found {
+			writeStandInError(w, http.StatusNotFound, "NoSuchBucket")
+			return
+		}
+		if r.Method == http.MethodPut {
+			content, _ := io.ReadAll(r.Body)
+			bucket.notification = string(content)
+			return
+		}
+		w.Header().Set("Content-Type", "application/xml")
+		if bucket.notification == "" {
+			_, _ = io.WriteString(w, "<NotificationConfiguration></NotificationConfiguration>")
+			return
+		}
+		_, _ = io.WriteString(w, bucket.notification)
+	case key == "" && query.Has("encryption"):
+		if !found {
+			writeStandInError(w, http.StatusNotFound, "NoSuchBucket")
+			return
+		}
+		switch r.Method {
+		case http.MethodPut:
+			content, _ := io.ReadAll(r.Body)
+			bucket.encryption = string(content)
+		case http.MethodDelete:
+			bucket.encryption = ""
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			if bucket.encryption == "" {
+				writeStandInError(w, http.StatusNotFound, "ServerSideEncryptionConfigurationNotFoundError")
+				return
+			}
+			w.Header().Set("Content-Type", "application/xml")
+			_, _ = io.WriteString(w, bucket.encryption)
+		}
+	case key == "" && r.Method == http.MethodHead:
+		if !found {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	case key == "" && r.Method == http.MethodPut:
+		if found {
+			writeStandInError(w, http.StatusConflict, "BucketAlreadyOwnedByYou")
+			return
+		}
+		bucket = &standInBucket{objects: map[string][]byte{}, tags: map[string]string{}, legalHolds: map[string]string{}}
+		if r.Header.Get("X-Amz-Bucket-Object-Lock-Enabled") == "true" {
+			bucket.versioning = "Enabled"
+			bucket.objectLock = "<ObjectLockConfiguration><ObjectLockEnabled>Enabled</ObjectLockEnabled></ObjectLockConfiguration>"
+		}
+		standIn.buckets[bucketName] = bucket
+	case key == "" && r.Method == http.MethodDelete:
+		if !found {
+			writeStandInError(w, http.StatusNotFound, "NoSuchBucket")
+			return
+		}
+		if len(bucket.objects) > 0 {
+			writeStandInError(w, http.StatusConflict, "BucketNotEmpty")
+			return
+		}
+		delete(standIn.buckets, bucketName)
+		w.WriteHeader(http.StatusNoContent)
+	case key == "" && r.Method == http.MethodGet:
+		if !found {
+			writeStandInError(w, http.StatusNotFound, "NoSuchBucket")
+			return
+		}
+		writeStandInXML(w, bucket.list(bucketName, query.Get("prefix"), query.Get("start-after")))
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		if !found {
+			writeStandInError(w, http.StatusNotFound, "NoSuchBucket")
+			return
+		}
+		content, found := bucket.objects[key]
+		if !found {
+			writeStandInError(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+		w.Header().Set("ETag", `"d41d8cd98f00b204e9800998ecf8427e"`)
+		w.Header().Set("Last-Modified", "Sun, 01 Jan 2023 00:00:00 GMT")
+		if r.Method == http.MethodGet {
+			_, _ = w.Write(content)
+		}
+	case r.Method == http.MethodPut && r.Header.Get("X-Amz-Copy-Source") != "":
+		source, _ := url.PathUnescape(strings.TrimPrefix(r.Header.Get("X-Amz-Copy-Source"), "/"))
+		srcBucketName, srcKey, _ := strings.Cut(source, "/")
+		srcBucket, srcFound := standIn.buckets[srcBucketName]
+		if !found || !srcFound {
+			writeStandInError(w, http.StatusNotFound, "NoSuchBucket")
+			return
+		}
+		content, srcFound := srcBucket.objects[srcKey]
+		if !srcFound {
+			writeStandInError(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		bucket.objects[key] = append([]byte{}, content...)
+		_, _ = io.WriteString(w, `<CopyObjectResult><LastModified>2023-01-01T00:00:00.000Z</LastModified><ETag>"d41d8cd98f00b204e9800998ecf8427e"</ETag></CopyObjectResult>`)
+	case r.Method == http.MethodPut:
+		if !found {
+			writeStandInError(w, http.StatusNotFound, "NoSuchBucket")
+			return
+		}
+		content, _ := io.ReadAll(r.Body)
+		if strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") {
+			content = decodeAwsChunked(content)
+		}
+		bucket.objects[key] = content
+		w.Header().Set("ETag", `"d41d8cd98f00b204e9800998ecf8427e"`)
+	default:
+		writeStandInError(w, http.StatusNotImplemented, "NotImplemented")
+	}
+}
+
+// decodeAwsChunked removes the chunk headers of a streaming signed upload,
+// <hex size>;chunk-signature=<signature>\r\n<data>\r\n, up to the empty chunk
+func decodeAwsChunked(body []byte) []byte {
+	content := []byte{}
+	for {
+		header, rest, found := bytes.Cut(body, []byte("\r\n"))
+		if !found {
+			return content
+		}
+		size, _, _ := bytes.Cut(header, []byte(";"))
+		length, err := strconv.ParseInt(string(size), 16, 64)
+		if err != nil || length == 0 || int64(len(rest)) < length {
+			return content
+		}
+		content = append(content, rest[:length]...)
+		body = bytes.TrimPrefix(rest[length:], []byte("\r\n"))
+	}
+}
+
+// serveAdmin serves the bucket quota and policy endpoints of the MinIO admin api
+func (standIn *s3StandIn) serveAdmin(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	switch strings.TrimPrefix(r.URL.Path, "/minio/admin/v3/") {
+	case "set-bucket-quota", "get-bucket-quota":
+		standIn.serveBucketQuota(w, r)
+	case "user-info":
+		policyName, found := standIn.users[query.Get("accessKey")]
+		if !found {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = io.WriteString(w, `{"Code":"XMinioAdminNoSuchUser","Message":"The specified user does not exist"}`)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]string{"policyName": policyName, "status": "enabled"})
+	case "add-canned-policy":
+		content, _ := io.ReadAll(r.Body)
+		standIn.cannedPolicies[query.Get("name")] = string(content)
+	case "remove-canned-policy":
+		if _, found := standIn.cannedPolicies[query.Get("name")]; !found {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = io.WriteString(w, `{"Code":"XMinioAdminNoSuchPolicy","Message":"The canned policy does not exist"}`)
+			return
+		}
+		delete(standIn.cannedPolicies, query.Get("name"))
+	case "remove-user":
+		delete(standIn.users, query.Get("accessKey"))
+	case "set-user-or-group-policy":
+		standIn.users[query.Get("userOrGroup")] = query.Get("policyName")
+	default:
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+func (standIn *s3StandIn) serveBucketQuota(w http.ResponseWriter, r *http.Request) {
+	bucket, found := standIn.buckets[r.URL.Query().Get("bucket")]
+	if !found {
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = io.WriteString(w, `{"Code":"NoSuchBucket","Message":"The specified bucket does not exist"}`)
+		return
+	}
+	switch {
+	case r.URL.Path == "/minio/admin/v3/set-bucket-quota" && r.Method == http.MethodPut:
+		content, _ := io.ReadAll(r.Body)
+		bucket.quota = string(content)
+	case r.URL.Path == "/minio/admin/v3/get-bucket-quota" && r.Method == http.MethodGet:
+		if bucket.quota == "" {
+			_, _ = io.WriteString(w, `{"quota":0}`)
+			return
+		}
+		_, _ = io.WriteString(w, bucket.quota)
+	default:
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+func (bucket *standInBucket) list(name string, prefix string, startAfter string) standInListResult {
+	keys := []string{}
+	for key := range bucket.objects {
+		if strings.HasPrefix(key, prefix) && key > startAfter {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	result := standInListResult{Name: name, Prefix: prefix, KeyCount: len(keys), MaxKeys: 1000}
+	for _, key := range keys {
+		result.Contents = append(result.Contents, struct {
+			Key          string `xml:"Key"`
+			Size         int    `xml:"Size"`
+			ETag         string `xml:"ETag"`
+			LastModified string `xml:"LastModified"`
+		}{key, len(bucket.objects[key]), `"d41d8cd98f00b204e9800998ecf8427e"`, "2023-01-01T00:00:00.000Z"})
+	}
+	return result
+}
+
+func writeStandInXML(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/xml")
+	_ = xml.NewEncoder(w).Encode(v)
+}
+
+func writeStandInError(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	_, _ = io.WriteString(w, "<Error><Code>"+code+"</Code><Message>"+code+"</Message></Error>")
+}
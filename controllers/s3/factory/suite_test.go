@@ -0,0 +1,24 @@
+package factory
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// These tests use Ginkgo (BDD-style Go testing framework). Refer to
+// http://onsi.github.io/ginkgo/ to learn more about Ginkgo.
+
+func TestFactory(t *testing.T) {
+	RegisterFailHandler(Fail)
+
+	RunSpecs(t, "S3 Factory Suite")
+}
+
+var _ = BeforeSuite(func() {
+	// failures are expected in some specs, do not wait for minio-go retries
+	minio.MaxRetry = 1
+})
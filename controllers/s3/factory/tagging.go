@@ -0,0 +1,32 @@
+package factory
+
+import (
+	"context"
+	"log"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/tags"
+)
+
+func getBucketTags(client *minio.Client, bucketname string) (map[string]string, error) {
+	bucketTags, err := client.GetBucketTagging(context.Background(), bucketname)
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchTagSet" {
+			return map[string]string{}, nil
+		}
+		return nil, err
+	}
+	return bucketTags.ToMap(), nil
+}
+
+func setBucketTags(client *minio.Client, bucketname string, bucketTags map[string]string) error {
+	log.Println("set tags on bucket " + bucketname)
+	if len(bucketTags) == 0 {
+		return client.RemoveBucketTagging(context.Background(), bucketname)
+	}
+	tagSet, err := tags.MapToBucketTags(bucketTags)
+	if err != nil {
+		return err
+	}
+	return client.SetBucketTagging(context.Background(), bucketname, tagSet)
+}
@@ -0,0 +1,44 @@
+package factory
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Bucket tags", func() {
+	var standIn *s3StandIn
+
+	BeforeEach(func() {
+		standIn = newS3StandIn()
+	})
+
+	AfterEach(func() {
+		standIn.close()
+	})
+
+	for _, provider := range []string{"minio", "aws"} {
+		provider := provider
+
+		It("replaces and removes bucket tags using "+provider, func() {
+			s3Client, err := GetS3Client(provider, &S3Config{S3Provider: provider, S3UrlEndpoint: standIn.endpoint(),
+				Region: "us-east-1", AccessKey: "access", SecretKey: "secret"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(s3Client.CreateBucket("bucket-titi")).To(Succeed())
+
+			tags, err := s3Client.GetBucketTags("bucket-titi")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(tags).To(BeEmpty())
+
+			workspaceTags := map[string]string{"onyxia.sh/workspace": "titi", "onyxia.sh/cost-centre": "DG75-L201"}
+			Expect(s3Client.SetBucketTags("bucket-titi", workspaceTags)).To(Succeed())
+			tags, err = s3Client.GetBucketTags("bucket-titi")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(tags).To(Equal(workspaceTags))
+
+			Expect(s3Client.SetBucketTags("bucket-titi", map[string]string{})).To(Succeed())
+			tags, err = s3Client.GetBucketTags("bucket-titi")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(tags).To(BeEmpty())
+		})
+	}
+})
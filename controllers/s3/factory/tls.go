@@ -0,0 +1,58 @@
+package factory
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// TLSConfig defines how the clients verify the object store and authenticate to it.
+// It only applies when S3Config.UseSsl is set.
+type TLSConfig struct {
+	// PEM bundle of certificate authorities trusted in addition to the system ones
+	CABundle []byte
+	// optional PEM client certificate and key
+	ClientCert []byte
+	ClientKey  []byte
+	// name sent as SNI and expected in the server certificate, the endpoint host if empty
+	ServerName string
+	// disables the verification of the server certificate, for tests only
+	InsecureSkipVerify bool
+}
+
+// newTransport returns the http transport shared by the s3 and admin clients of a provider.
+func newTransport(S3Config *S3Config) (*http.Transport, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if !S3Config.UseSsl {
+		return transport, nil
+	}
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: S3Config.TLS.ServerName,
+	}
+	if len(S3Config.TLS.CABundle) > 0 {
+		rootCAs, err := x509.SystemCertPool()
+		if err != nil {
+			rootCAs = x509.NewCertPool()
+		}
+		if !rootCAs.AppendCertsFromPEM(S3Config.TLS.CABundle) {
+			return nil, fmt.Errorf("no certificate found in the s3 CA bundle")
+		}
+		tlsConfig.RootCAs = rootCAs
+	}
+	if len(S3Config.TLS.ClientCert) > 0 || len(S3Config.TLS.ClientKey) > 0 {
+		clientCert, err := tls.X509KeyPair(S3Config.TLS.ClientCert, S3Config.TLS.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid s3 client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{clientCert}
+	}
+	if S3Config.TLS.InsecureSkipVerify {
+		log.Println("WARNING: the certificate of " + S3Config.S3UrlEndpoint + " is not verified, do not use in production")
+		tlsConfig.InsecureSkipVerify = true
+	}
+	transport.TLSClientConfig = tlsConfig
+	return transport, nil
+}
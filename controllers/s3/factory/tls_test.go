@@ -0,0 +1,167 @@
+package factory
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+type testCertificate struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+	keyPEM  []byte
+}
+
+// newTestCertificate issues a certificate signed by parent, or self-signed if parent is nil.
+func newTestCertificate(commonName string, parent *testCertificate, usage x509.ExtKeyUsage, dnsNames ...string) *testCertificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).NotTo(HaveOccurred())
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	Expect(err).NotTo(HaveOccurred())
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     dnsNames,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	signerCert, signerKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+	} else {
+		signerCert, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signerCert, &key.PublicKey, signerKey)
+	Expect(err).NotTo(HaveOccurred())
+	cert, err := x509.ParseCertificate(der)
+	Expect(err).NotTo(HaveOccurred())
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	Expect(err).NotTo(HaveOccurred())
+	return &testCertificate{
+		cert:    cert,
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}),
+	}
+}
+
+var _ = Describe("TLS", func() {
+	var ca, client *testCertificate
+	var standIn *s3StandIn
+	var serverNames []string
+
+	BeforeEach(func() {
+		ca = newTestCertificate("onyxia test ca", nil, x509.ExtKeyUsageAny)
+		// the server certificate is only valid for s3.onyxia.test, not for 127.0.0.1
+		server := newTestCertificate("s3", ca, x509.ExtKeyUsageServerAuth, "s3.onyxia.test")
+		client = newTestCertificate("onyxia-operator", ca, x509.ExtKeyUsageClientAuth)
+		serverCert, err := tls.X509KeyPair(server.certPEM, server.keyPEM)
+		Expect(err).NotTo(HaveOccurred())
+		clientCAs := x509.NewCertPool()
+		clientCAs.AddCert(ca.cert)
+		serverNames = []string{}
+		standIn = newTLSS3StandIn(&tls.Config{
+			ClientAuth: tls.RequireAndVerifyClientCert,
+			ClientCAs:  clientCAs,
+			GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+				serverNames = append(serverNames, hello.ServerName)
+				return &serverCert, nil
+			},
+		})
+	})
+
+	AfterEach(func() {
+		standIn.close()
+	})
+
+	newS3Config := func(tlsConfig TLSConfig) *S3Config {
+		return &S3Config{
+			S3UrlEndpoint: standIn.endpoint(),
+			Region:        "us-east-1",
+			AccessKey:     "access",
+			SecretKey:     "secret",
+			UseSsl:        true,
+			TLS:           tlsConfig,
+		}
+	}
+
+	for _, provider := range []string{"minio", "aws", "ceph-rgw"} {
+		provider := provider
+
+		It("connects with the CA bundle, client certificate and server name override using "+provider, func() {
+			s3Client, err := GetS3Client(provider, newS3Config(TLSConfig{
+				CABundle:   ca.certPEM,
+				ClientCert: client.certPEM,
+				ClientKey:  client.keyPEM,
+				ServerName: "s3.onyxia.test",
+			}))
+			Expect(err).NotTo(HaveOccurred())
+			_, err = s3Client.BucketExists("bucket-titi")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(serverNames).To(ContainElement("s3.onyxia.test"))
+		})
+
+		It("does not trust the server without the CA bundle using "+provider, func() {
+			s3Client, err := GetS3Client(provider, newS3Config(TLSConfig{
+				ClientCert: client.certPEM,
+				ClientKey:  client.keyPEM,
+				ServerName: "s3.onyxia.test",
+			}))
+			Expect(err).NotTo(HaveOccurred())
+			_, err = s3Client.BucketExists("bucket-titi")
+			Expect(err).To(HaveOccurred())
+		})
+	}
+
+	It("checks the server name", func() {
+		s3Client, err := GetS3Client("minio", newS3Config(TLSConfig{
+			CABundle:   ca.certPEM,
+			ClientCert: client.certPEM,
+			ClientKey:  client.keyPEM,
+		}))
+		Expect(err).NotTo(HaveOccurred())
+		_, err = s3Client.BucketExists("bucket-titi")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("is rejected by the server without client certificate", func() {
+		s3Client, err := GetS3Client("minio", newS3Config(TLSConfig{
+			CABundle:   ca.certPEM,
+			ServerName: "s3.onyxia.test",
+		}))
+		Expect(err).NotTo(HaveOccurred())
+		_, err = s3Client.BucketExists("bucket-titi")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("skips the verification of the server certificate when asked to", func() {
+		s3Client, err := GetS3Client("minio", newS3Config(TLSConfig{
+			ClientCert:         client.certPEM,
+			ClientKey:          client.keyPEM,
+			InsecureSkipVerify: true,
+		}))
+		Expect(err).NotTo(HaveOccurred())
+		_, err = s3Client.BucketExists("bucket-titi")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("refuses invalid CA bundles and client certificates", func() {
+		_, err := GetS3Client("minio", newS3Config(TLSConfig{CABundle: []byte("not a certificate")}))
+		Expect(err).To(HaveOccurred())
+		_, err = GetS3Client("minio", newS3Config(TLSConfig{ClientCert: client.certPEM, ClientKey: ca.keyPEM}))
+		Expect(err).To(HaveOccurred())
+	})
+})
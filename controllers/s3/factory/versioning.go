@@ -0,0 +1,76 @@
+package factory
+
+import (
+	"context"
+	"log"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// Versioning, object lock and legal hold are plain S3 APIs, shared by the
+// providers relying on minio-go.
+
+func getVersioning(client *minio.Client, bucketname string) (string, error) {
+	configuration, err := client.GetBucketVersioning(context.Background(), bucketname)
+	if err != nil {
+		return "", err
+	}
+	return configuration.Status, nil
+}
+
+func setVersioning(client *minio.Client, bucketname string, status string) error {
+	log.Println("set versioning " + status + " on bucket " + bucketname)
+	return client.SetBucketVersioning(context.Background(), bucketname, minio.BucketVersioningConfiguration{Status: status})
+}
+
+func getObjectLock(client *minio.Client, bucketname string) (*ObjectLock, error) {
+	enabled, mode, validity, unit, err := client.GetObjectLockConfig(context.Background(), bucketname)
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "ObjectLockConfigurationNotFoundError" {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if enabled != "Enabled" {
+		return nil, nil
+	}
+	objectLock := &ObjectLock{}
+	if mode != nil && validity != nil && unit != nil {
+		objectLock.Mode = string(*mode)
+		if *unit == minio.Years {
+			objectLock.Years = *validity
+		} else {
+			objectLock.Days = *validity
+		}
+	}
+	return objectLock, nil
+}
+
+func setObjectLock(client *minio.Client, bucketname string, objectLock ObjectLock) error {
+	log.Println("set object lock " + objectLock.Mode + " on bucket " + bucketname)
+	if objectLock.Mode == "" {
+		// no default retention
+		return client.SetObjectLockConfig(context.Background(), bucketname, nil, nil, nil)
+	}
+	mode := minio.RetentionMode(objectLock.Mode)
+	validity, unit := objectLock.Days, minio.Days
+	if objectLock.Years > 0 {
+		validity, unit = objectLock.Years, minio.Years
+	}
+	return client.SetObjectLockConfig(context.Background(), bucketname, &mode, &validity, &unit)
+}
+
+func setLegalHold(client *minio.Client, bucketname string, status string) error {
+	log.Println("set legal hold " + status + " on objects of bucket " + bucketname)
+	legalHold := minio.LegalHoldStatus(status)
+	for object := range client.ListObjects(context.Background(), bucketname, minio.ListObjectsOptions{Recursive: true}) {
+		if object.Err != nil {
+			return object.Err
+		}
+		err := client.PutObjectLegalHold(context.Background(), bucketname, object.Key, minio.PutObjectLegalHoldOptions{Status: &legalHold})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
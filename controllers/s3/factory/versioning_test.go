@@ -0,0 +1,72 @@
+package factory
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Versioning and object lock", func() {
+	var standIn *s3StandIn
+
+	BeforeEach(func() {
+		standIn = newS3StandIn()
+	})
+
+	AfterEach(func() {
+		standIn.close()
+	})
+
+	for _, provider := range []string{"minio", "aws"} {
+		provider := provider
+
+		It("enables and suspends versioning using "+provider, func() {
+			s3Client, err := GetS3Client(provider, &S3Config{S3Provider: provider, S3UrlEndpoint: standIn.endpoint(),
+				Region: "us-east-1", AccessKey: "access", SecretKey: "secret"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(s3Client.CreateBucket("bucket-titi")).To(Succeed())
+
+			versioning, err := s3Client.GetVersioning("bucket-titi")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(versioning).To(BeEmpty())
+			Expect(s3Client.SetVersioning("bucket-titi", VersioningEnabled)).To(Succeed())
+			versioning, err = s3Client.GetVersioning("bucket-titi")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(versioning).To(Equal(VersioningEnabled))
+			Expect(s3Client.SetVersioning("bucket-titi", VersioningSuspended)).To(Succeed())
+			versioning, err = s3Client.GetVersioning("bucket-titi")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(versioning).To(Equal(VersioningSuspended))
+		})
+
+		It("sets the default retention and legal hold of locked buckets using "+provider, func() {
+			s3Client, err := GetS3Client(provider, &S3Config{S3Provider: provider, S3UrlEndpoint: standIn.endpoint(),
+				Region: "us-east-1", AccessKey: "access", SecretKey: "secret"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(s3Client.CreateBucketWithObjectLock("bucket-titi")).To(Succeed())
+			Expect(s3Client.CreatePath("bucket-titi", "archives")).To(Succeed())
+
+			objectLock, err := s3Client.GetObjectLock("bucket-titi")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(objectLock).To(Equal(&ObjectLock{}))
+
+			Expect(s3Client.SetObjectLock("bucket-titi", ObjectLock{Mode: "COMPLIANCE", Years: 10})).To(Succeed())
+			objectLock, err = s3Client.GetObjectLock("bucket-titi")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(objectLock).To(Equal(&ObjectLock{Mode: "COMPLIANCE", Years: 10}))
+
+			Expect(s3Client.SetLegalHold("bucket-titi", LegalHoldOn)).To(Succeed())
+			Expect(standIn.buckets["bucket-titi"].legalHolds).To(HaveKeyWithValue("archives/", LegalHoldOn))
+		})
+
+		It("reports buckets created without object lock using "+provider, func() {
+			s3Client, err := GetS3Client(provider, &S3Config{S3Provider: provider, S3UrlEndpoint: standIn.endpoint(),
+				Region: "us-east-1", AccessKey: "access", SecretKey: "secret"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(s3Client.CreateBucket("bucket-titi")).To(Succeed())
+
+			objectLock, err := s3Client.GetObjectLock("bucket-titi")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(objectLock).To(BeNil())
+		})
+	}
+})
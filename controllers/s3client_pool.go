@@ -0,0 +1,202 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"sync"
+
+	onyxiav1 "github.com/inseefrlab/onyxia-onboarding-operator/api/v1"
+	"github.com/inseefrlab/onyxia-onboarding-operator/controllers/s3/factory"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// S3ClientPool hands out the S3Client of each S3Backend. Clients are built
+// lazily and rebuilt whenever the backend or its credentials change, so that
+// endpoints and keys can be updated without restarting the operator. The
+// workspace controller watches the credentials secrets to get the new clients
+// at once. Credential files are only read again when a client is requested,
+// so their rotation is lazy: it takes effect at the next reconcile, at the
+// latest after the usage refresh interval.
+type S3ClientPool struct {
+	k8sClient client.Client
+	// backend used by workspaces without backendRef, nil if there is none
+	defaultConfig *factory.S3Config
+	// secret holding the credentials of the default backend, if they are not in defaultConfig
+	defaultCredentialsSecret *types.NamespacedName
+	mu                       sync.Mutex
+	clients                  map[string]pooledS3Client
+}
+
+type pooledS3Client struct {
+	// version of the configuration and credentials the client was built from
+	version  string
+	s3Client factory.S3Client
+}
+
+// NewS3ClientPool creates a pool reading S3Backends and secrets with the given client.
+// The credentials of the default backend are read, by order of precedence, from
+// defaultCredentialsSecret, from defaultConfig.CredentialsPath or from defaultConfig itself.
+func NewS3ClientPool(c client.Client, defaultConfig *factory.S3Config, defaultCredentialsSecret *types.NamespacedName) *S3ClientPool {
+	return &S3ClientPool{
+		k8sClient:                c,
+		defaultConfig:            defaultConfig,
+		defaultCredentialsSecret: defaultCredentialsSecret,
+		clients:                  map[string]pooledS3Client{},
+	}
+}
+
+// Get returns the client of the named S3Backend, or the default client if the name is empty.
+func (pool *S3ClientPool) Get(ctx context.Context, backendName string) (factory.S3Client, error) {
+	if backendName == "" {
+		return pool.getDefault(ctx)
+	}
+	backend := &onyxiav1.S3Backend{}
+	err := pool.k8sClient.Get(ctx, types.NamespacedName{Name: backendName}, backend)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			pool.forget(backendName)
+		}
+		return nil, fmt.Errorf("can't get s3 backend %s: %w", backendName, err)
+	}
+	secret := &v1.Secret{}
+	err = pool.k8sClient.Get(ctx, types.NamespacedName{
+		Namespace: backend.Spec.CredentialsSecretRef.Namespace,
+		Name:      backend.Spec.CredentialsSecretRef.Name,
+	}, secret)
+	if err != nil {
+		return nil, fmt.Errorf("can't get credentials of s3 backend %s: %w", backendName, err)
+	}
+	s3Config := &factory.S3Config{
+		S3Provider:    backend.Spec.Provider,
+		S3UrlEndpoint: backend.Spec.Endpoint,
+		Region:        backend.Spec.Region,
+		AccessKey:     string(secret.Data[onyxiav1.S3BackendAccessKeyKey]),
+		SecretKey:     string(secret.Data[onyxiav1.S3BackendSecretKeyKey]),
+		UseSsl:        backend.Spec.TLS.Enabled,
+		TLS: factory.TLSConfig{
+			ServerName:         backend.Spec.TLS.ServerName,
+			InsecureSkipVerify: backend.Spec.TLS.InsecureSkipVerify,
+		},
+		RgwAdminPath: backend.Spec.RgwAdminPath,
+	}
+	version := fmt.Sprintf("%d/%s", backend.GetGeneration(), secret.GetResourceVersion())
+	if backend.Spec.TLS.CASecretRef != nil {
+		caSecret := &v1.Secret{}
+		err = pool.k8sClient.Get(ctx, types.NamespacedName{
+			Namespace: backend.Spec.TLS.CASecretRef.Namespace,
+			Name:      backend.Spec.TLS.CASecretRef.Name,
+		}, caSecret)
+		if err != nil {
+			return nil, fmt.Errorf("can't get CA of s3 backend %s: %w", backendName, err)
+		}
+		s3Config.TLS.CABundle = caSecret.Data["ca.crt"]
+		version += "/" + caSecret.GetResourceVersion()
+	}
+	if backend.Spec.TLS.ClientCertSecretRef != nil {
+		certSecret := &v1.Secret{}
+		err = pool.k8sClient.Get(ctx, types.NamespacedName{
+			Namespace: backend.Spec.TLS.ClientCertSecretRef.Namespace,
+			Name:      backend.Spec.TLS.ClientCertSecretRef.Name,
+		}, certSecret)
+		if err != nil {
+			return nil, fmt.Errorf("can't get client certificate of s3 backend %s: %w", backendName, err)
+		}
+		s3Config.TLS.ClientCert = certSecret.Data[v1.TLSCertKey]
+		s3Config.TLS.ClientKey = certSecret.Data[v1.TLSPrivateKeyKey]
+		version += "/" + certSecret.GetResourceVersion()
+	}
+	return pool.build(ctx, backendName, version, s3Config)
+}
+
+func (pool *S3ClientPool) getDefault(ctx context.Context) (factory.S3Client, error) {
+	if pool.defaultConfig == nil {
+		return nil, fmt.Errorf("no default s3 backend configured, bucket.backendRef is required")
+	}
+	s3Config := *pool.defaultConfig
+	version := "static"
+	if pool.defaultCredentialsSecret != nil {
+		secret := &v1.Secret{}
+		err := pool.k8sClient.Get(ctx, *pool.defaultCredentialsSecret, secret)
+		if err != nil {
+			return nil, fmt.Errorf("can't get credentials of default s3 backend: %w", err)
+		}
+		s3Config.AccessKey = string(secret.Data[onyxiav1.S3BackendAccessKeyKey])
+		s3Config.SecretKey = string(secret.Data[onyxiav1.S3BackendSecretKeyKey])
+		version = "secret/" + secret.GetResourceVersion()
+	} else if s3Config.CredentialsPath != "" {
+		// the kubelet updates mounted secrets in place, compare the content to detect a rotation
+		err := s3Config.LoadCredentials()
+		if err != nil {
+			return nil, err
+		}
+		version = fmt.Sprintf("file/%x", sha256.Sum256([]byte(s3Config.AccessKey+"\n"+s3Config.SecretKey)))
+	}
+	return pool.build(ctx, "", version, &s3Config)
+}
+
+// build returns the pooled client of the key if it was built from the same version, a new one otherwise.
+func (pool *S3ClientPool) build(ctx context.Context, key string, version string, s3Config *factory.S3Config) (factory.S3Client, error) {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	pooled, found := pool.clients[key]
+	if found && pooled.version == version {
+		return pooled.s3Client, nil
+	}
+	log.FromContext(ctx).Info("building s3 client", "backend", key, "version", version)
+	s3Client, err := factory.GetS3Client(s3Config.S3Provider, s3Config)
+	if err != nil {
+		return nil, fmt.Errorf("can't build client of s3 backend %s: %w", key, err)
+	}
+	pool.clients[key] = pooledS3Client{version: version, s3Client: s3Client}
+	return s3Client, nil
+}
+
+// backendsUsingSecret returns the backends whose credentials, CA or client certificate
+// are in the secret, the default backend under the empty name
+func (pool *S3ClientPool) backendsUsingSecret(ctx context.Context, secret types.NamespacedName) (map[string]bool, error) {
+	backends := map[string]bool{}
+	if pool.defaultConfig != nil && pool.defaultCredentialsSecret != nil && *pool.defaultCredentialsSecret == secret {
+		backends[""] = true
+	}
+	s3Backends := &onyxiav1.S3BackendList{}
+	err := pool.k8sClient.List(ctx, s3Backends)
+	if err != nil {
+		return nil, err
+	}
+	for _, backend := range s3Backends.Items {
+		refs := []*v1.SecretReference{&backend.Spec.CredentialsSecretRef, backend.Spec.TLS.CASecretRef, backend.Spec.TLS.ClientCertSecretRef}
+		for _, ref := range refs {
+			if ref != nil && ref.Namespace == secret.Namespace && ref.Name == secret.Name {
+				backends[backend.Name] = true
+			}
+		}
+	}
+	return backends, nil
+}
+
+func (pool *S3ClientPool) forget(backendName string) {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	delete(pool.clients, backendName)
+}
@@ -0,0 +1,137 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	onyxiav1 "github.com/inseefrlab/onyxia-onboarding-operator/api/v1"
+	"github.com/inseefrlab/onyxia-onboarding-operator/controllers/s3/factory"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+var _ = Describe("S3ClientPool", func() {
+	var k8sClient client.Client
+	var secret *v1.Secret
+	secretName := types.NamespacedName{Namespace: "onyxia-onboarding-operator-system", Name: "s3-credentials"}
+
+	BeforeEach(func() {
+		secret = &v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: secretName.Namespace, Name: secretName.Name},
+			Data:       map[string][]byte{onyxiav1.S3BackendAccessKeyKey: []byte("access"), onyxiav1.S3BackendSecretKeyKey: []byte("secret")},
+		}
+		k8sClient = newFakeClient(secret)
+	})
+
+	rotate := func() {
+		Expect(k8sClient.Get(context.Background(), secretName, secret)).To(Succeed())
+		secret.Data[onyxiav1.S3BackendSecretKeyKey] = []byte("rotated")
+		Expect(k8sClient.Update(context.Background(), secret)).To(Succeed())
+	}
+
+	It("rebuilds the default client when its secret is rotated", func() {
+		pool := NewS3ClientPool(k8sClient, &factory.S3Config{S3Provider: "mockedS3Provider"}, &secretName)
+		s3Client, err := pool.Get(context.Background(), "")
+		Expect(err).NotTo(HaveOccurred())
+		same, err := pool.Get(context.Background(), "")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(same).To(BeIdenticalTo(s3Client))
+
+		rotate()
+		rebuilt, err := pool.Get(context.Background(), "")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(rebuilt).NotTo(BeIdenticalTo(s3Client))
+	})
+
+	It("rebuilds the default client when its credential files are rotated", func() {
+		dir := GinkgoT().TempDir()
+		write := func(secretKey string) {
+			Expect(os.WriteFile(filepath.Join(dir, factory.AccessKeyFileName), []byte("access\n"), 0o600)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(dir, factory.SecretKeyFileName), []byte(secretKey+"\n"), 0o600)).To(Succeed())
+		}
+		write("secret")
+		pool := NewS3ClientPool(k8sClient, &factory.S3Config{S3Provider: "mockedS3Provider", CredentialsPath: dir}, nil)
+		s3Client, err := pool.Get(context.Background(), "")
+		Expect(err).NotTo(HaveOccurred())
+		same, err := pool.Get(context.Background(), "")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(same).To(BeIdenticalTo(s3Client))
+
+		write("rotated")
+		rebuilt, err := pool.Get(context.Background(), "")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(rebuilt).NotTo(BeIdenticalTo(s3Client))
+	})
+
+	It("needs a backend name without default backend", func() {
+		pool := NewS3ClientPool(k8sClient, nil, nil)
+		_, err := pool.Get(context.Background(), "")
+		Expect(err).To(MatchError(ContainSubstring("no default s3 backend configured")))
+	})
+
+	It("rebuilds the client of a backend when its secret is rotated", func() {
+		backend := &onyxiav1.S3Backend{
+			ObjectMeta: metav1.ObjectMeta{Name: "datalab"},
+			Spec: onyxiav1.S3BackendSpec{Provider: "mockedS3Provider", CredentialsSecretRef: v1.SecretReference{
+				Namespace: secretName.Namespace, Name: secretName.Name,
+			}},
+		}
+		Expect(k8sClient.Create(context.Background(), backend)).To(Succeed())
+		pool := NewS3ClientPool(k8sClient, nil, nil)
+		s3Client, err := pool.Get(context.Background(), "datalab")
+		Expect(err).NotTo(HaveOccurred())
+
+		rotate()
+		rebuilt, err := pool.Get(context.Background(), "datalab")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(rebuilt).NotTo(BeIdenticalTo(s3Client))
+	})
+
+	It("requeues the workspaces of the backends using a rotated secret", func() {
+		backend := &onyxiav1.S3Backend{
+			ObjectMeta: metav1.ObjectMeta{Name: "datalab"},
+			Spec: onyxiav1.S3BackendSpec{Provider: "mockedS3Provider", CredentialsSecretRef: v1.SecretReference{
+				Namespace: secretName.Namespace, Name: "datalab-credentials",
+			}},
+		}
+		titi := newWorkspace("titi")
+		tata := newWorkspace("tata")
+		tata.Spec.Bucket.BackendRef = "datalab"
+		for _, object := range []client.Object{backend, titi, tata} {
+			Expect(k8sClient.Create(context.Background(), object)).To(Succeed())
+		}
+		reconciler := &WorkspaceReconciler{
+			Client:    k8sClient,
+			S3Clients: NewS3ClientPool(k8sClient, &factory.S3Config{S3Provider: "mockedS3Provider"}, &secretName),
+		}
+
+		Expect(reconciler.workspacesForSecret(secret)).To(Equal([]reconcile.Request{{NamespacedName: client.ObjectKeyFromObject(titi)}}))
+		datalabSecret := &v1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: secretName.Namespace, Name: "datalab-credentials"}}
+		Expect(reconciler.workspacesForSecret(datalabSecret)).To(Equal([]reconcile.Request{{NamespacedName: client.ObjectKeyFromObject(tata)}}))
+		other := &v1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "titi", Name: "onyxia-s3"}}
+		Expect(reconciler.workspacesForSecret(other)).To(BeEmpty())
+	})
+})
@@ -0,0 +1,67 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	onyxiav1 "github.com/inseefrlab/onyxia-onboarding-operator/api/v1"
+	"github.com/inseefrlab/onyxia-onboarding-operator/controllers/s3/factory"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// handleAccess applies the access modes of the paths to the bucket policy. Workspaces
+// a path is shared with are resolved to their S3 identity, named after their bucket.
+// Principals the object store can't allow are returned, to be reported in the
+// workspace status, instead of failing the reconcile.
+func (r *WorkspaceReconciler) handleAccess(ctx context.Context, onyxiaWorkspace *onyxiav1.Workspace, s3Client factory.S3Client) ([]string, error) {
+	access := []factory.PathAccess{}
+	for _, pathAccess := range onyxiaWorkspace.Spec.Bucket.Access {
+		identities := []string{}
+		for _, name := range pathAccess.Workspaces {
+			workspace := &onyxiav1.Workspace{}
+			err := r.Get(ctx, client.ObjectKey{Namespace: onyxiaWorkspace.Namespace, Name: name}, workspace)
+			if err != nil {
+				return nil, fmt.Errorf("can't share path %s with workspace %s: %w", pathAccess.Path, name, err)
+			}
+			if workspace.Spec.Bucket.Credentials == nil {
+				return nil, fmt.Errorf("can't share path %s with workspace %s, it has no s3 identity", pathAccess.Path, name)
+			}
+			identities = append(identities, workspace.Spec.Bucket.Name)
+		}
+		access = append(access, factory.PathAccess{
+			Path:       pathAccess.Path,
+			Mode:       pathAccess.Mode,
+			Identities: identities,
+			Groups:     pathAccess.Groups,
+		})
+	}
+	bucketname, prefix := bucketLocation(onyxiaWorkspace)
+	changed, err := s3Client.SetPathAccess(bucketname, prefix, access)
+	unapplied := []string{}
+	if unsupported(err) {
+		unapplied = append(unapplied, err.Error())
+	} else if err != nil {
+		return nil, fmt.Errorf("can't set access policy of bucket %s: %w", bucketname, err)
+	}
+	if changed {
+		log.FromContext(ctx).Info("bucket policy drifted from the path access modes, corrected", "bucket", bucketname, "prefix", prefix)
+	}
+	return unapplied, nil
+}
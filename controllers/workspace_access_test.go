@@ -0,0 +1,73 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	onyxiav1 "github.com/inseefrlab/onyxia-onboarding-operator/api/v1"
+	"github.com/inseefrlab/onyxia-onboarding-operator/controllers/s3/factory"
+	"k8s.io/client-go/kubernetes/scheme"
+)
+
+var _ = Describe("handleAccess", func() {
+	var reconciler *WorkspaceReconciler
+	var s3Client *factory.MockedS3Client
+	var workspace *onyxiav1.Workspace
+
+	BeforeEach(func() {
+		toto := newWorkspace("toto")
+		toto.Spec.Bucket.Credentials = &onyxiav1.BucketCredentials{}
+		reconciler = &WorkspaceReconciler{Client: newFakeClient(toto), Scheme: scheme.Scheme}
+		s3Client = newMockedS3Client()
+		workspace = newWorkspace("titi")
+		workspace.Status.Bucket.Name = "bucket-titi"
+		workspace.Spec.Bucket.Access = []onyxiav1.PathAccess{
+			{Path: "diffusion", Mode: factory.AccessShared, Workspaces: []string{"toto"}, Groups: []string{"datalab-team"}},
+		}
+		Expect(s3Client.CreateBucket("bucket-titi")).To(Succeed())
+	})
+
+	It("shares the paths with the identities of the workspaces", func() {
+		unapplied, err := reconciler.handleAccess(context.Background(), workspace, s3Client)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(unapplied).To(BeEmpty())
+		Expect(s3Client.Policy("bucket-titi")).To(ContainSubstring(`"bucket-toto"`))
+	})
+
+	It("reports the principals the object store can't allow", func() {
+		s3Client.Failures = map[string]error{"SetPathAccess": fmt.Errorf("groups datalab-team: %w", factory.ErrUnsupported)}
+		unapplied, err := reconciler.handleAccess(context.Background(), workspace, s3Client)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(unapplied).To(ConsistOf(ContainSubstring("datalab-team")))
+
+		s3Client.Failures = map[string]error{"SetPathAccess": fmt.Errorf("policy backend unavailable")}
+		_, err = reconciler.handleAccess(context.Background(), workspace, s3Client)
+		Expect(err).To(MatchError(ContainSubstring("policy backend unavailable")))
+	})
+
+	It("fails on workspaces without s3 identity", func() {
+		workspace.Spec.Bucket.Access[0].Workspaces = []string{"tata"}
+		Expect(reconciler.Create(context.Background(), newWorkspace("tata"))).To(Succeed())
+		_, err := reconciler.handleAccess(context.Background(), workspace, s3Client)
+		Expect(err).To(MatchError(ContainSubstring("it has no s3 identity")))
+	})
+})
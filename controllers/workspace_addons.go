@@ -0,0 +1,339 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	onyxiav1 "github.com/inseefrlab/onyxia-onboarding-operator/api/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// addonLabel is the label holding the WorkspaceAddon of the objects it installed
+const addonLabel = "onyxia.sh/addon"
+
+// addonKinds are the kinds addon manifests may hold, those the operator has RBAC on
+var addonKinds = map[schema.GroupKind]bool{
+	{Kind: "ConfigMap"}: true,
+	{Group: "rbac.authorization.k8s.io", Kind: "Role"}:        true,
+	{Group: "rbac.authorization.k8s.io", Kind: "RoleBinding"}: true,
+	{Group: "policy", Kind: "PodDisruptionBudget"}:            true,
+}
+
+var (
+	addonRoleKind        = schema.GroupKind{Group: "rbac.authorization.k8s.io", Kind: "Role"}
+	addonRoleBindingKind = schema.GroupKind{Group: "rbac.authorization.k8s.io", Kind: "RoleBinding"}
+)
+
+// addonValues are the values given to the manifests template of the addons
+type addonValues struct {
+	Name         string
+	Namespace    string
+	Bucket       string
+	BucketPrefix string
+	Owners       []onyxiav1.Subject
+}
+
+// addonMatches reports whether the addon is installed for the workspace
+func addonMatches(addon *onyxiav1.WorkspaceAddon, onyxiaWorkspace *onyxiav1.Workspace) (bool, error) {
+	if addon.Spec.WorkspaceSelector == nil {
+		return true, nil
+	}
+	selector, err := metav1.LabelSelectorAsSelector(addon.Spec.WorkspaceSelector)
+	if err != nil {
+		return false, fmt.Errorf("invalid workspace selector: %w", err)
+	}
+	return selector.Matches(labels.Set(onyxiaWorkspace.Labels)), nil
+}
+
+// renderAddon returns the objects of the manifests of the addon rendered for the workspace,
+// in the workspace namespace and labelled with the addon
+func (r *WorkspaceReconciler) renderAddon(addon *onyxiav1.WorkspaceAddon, onyxiaWorkspace *onyxiav1.Workspace) ([]*unstructured.Unstructured, error) {
+	manifests, err := template.New(addon.Name).Option("missingkey=error").Parse(addon.Spec.Manifests)
+	if err != nil {
+		return nil, fmt.Errorf("invalid manifests template: %w", err)
+	}
+	bucketname, prefix := bucketLocation(onyxiaWorkspace)
+	content := &bytes.Buffer{}
+	err = manifests.Execute(content, addonValues{
+		Name:         onyxiaWorkspace.Name,
+		Namespace:    onyxiaWorkspace.Spec.Namespace,
+		Bucket:       bucketname,
+		BucketPrefix: prefix,
+		Owners:       onyxiaWorkspace.Spec.Owners,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("can't render manifests: %w", err)
+	}
+	objects := []*unstructured.Unstructured{}
+	decoder := yaml.NewYAMLOrJSONDecoder(content, content.Len()+1)
+	for {
+		object := &unstructured.Unstructured{}
+		err = decoder.Decode(&object.Object)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid manifest: %w", err)
+		}
+		if len(object.Object) == 0 {
+			// empty document, e.g. a template condition turned off
+			continue
+		}
+		gvk := object.GroupVersionKind()
+		if gvk.Kind == "" || object.GetName() == "" {
+			return nil, fmt.Errorf("manifest %d needs a kind and a name", len(objects)+1)
+		}
+		if !addonKinds[gvk.GroupKind()] {
+			return nil, fmt.Errorf("%s %s is not a ConfigMap, Role, RoleBinding or PodDisruptionBudget", gvk.Kind, object.GetName())
+		}
+		if object.GetNamespace() != "" && object.GetNamespace() != onyxiaWorkspace.Spec.Namespace {
+			return nil, fmt.Errorf("%s %s must be in the workspace namespace", gvk.Kind, object.GetName())
+		}
+		mapping, err := r.RESTMapper().RESTMapping(gvk.GroupKind(), gvk.Version)
+		if err != nil {
+			return nil, fmt.Errorf("unknown kind of %s %s: %w", gvk.Kind, object.GetName(), err)
+		}
+		if mapping.Scope.Name() != meta.RESTScopeNameNamespace {
+			return nil, fmt.Errorf("%s %s is not namespaced", gvk.Kind, object.GetName())
+		}
+		object.SetNamespace(onyxiaWorkspace.Spec.Namespace)
+		objectLabels := object.GetLabels()
+		if objectLabels == nil {
+			objectLabels = map[string]string{}
+		}
+		objectLabels[managedByLabel] = managedByValue
+		objectLabels[addonLabel] = addon.Name
+		object.SetLabels(objectLabels)
+		objects = append(objects, object)
+	}
+	err = r.checkAddonRoleBindings(objects)
+	if err != nil {
+		return nil, err
+	}
+	return objects, nil
+}
+
+// checkAddonRoleBindings makes sure the RoleBindings of the addon objects bind a Role
+// of the addon, or a ClusterRole of the operator configuration, so that addons don't
+// grant more than the operator holds or the administrators allowed
+func (r *WorkspaceReconciler) checkAddonRoleBindings(objects []*unstructured.Unstructured) error {
+	roles := map[string]bool{}
+	for _, object := range objects {
+		if object.GroupVersionKind().GroupKind() == addonRoleKind {
+			roles[object.GetName()] = true
+		}
+	}
+	for _, object := range objects {
+		if object.GroupVersionKind().GroupKind() != addonRoleBindingKind {
+			continue
+		}
+		kind, _, _ := unstructured.NestedString(object.Object, "roleRef", "kind")
+		name, _, _ := unstructured.NestedString(object.Object, "roleRef", "name")
+		switch {
+		case kind == "Role" && roles[name]:
+		case kind == "ClusterRole" && r.Config.addonClusterRoleAllowed(name):
+		case kind == "ClusterRole":
+			return fmt.Errorf("RoleBinding %s binds ClusterRole %s, not an addon ClusterRole of the operator configuration", object.GetName(), name)
+		default:
+			return fmt.Errorf("RoleBinding %s binds %s %s, not a Role of the addon", object.GetName(), kind, name)
+		}
+	}
+	return nil
+}
+
+// addonObject identifies an object installed by an addon in the status of the workspace
+func addonObject(object *unstructured.Unstructured) onyxiav1.AddonObject {
+	return onyxiav1.AddonObject{APIVersion: object.GetAPIVersion(), Kind: object.GetKind(), Name: object.GetName()}
+}
+
+func containsAddonObject(objects []onyxiav1.AddonObject, object onyxiav1.AddonObject) bool {
+	for _, o := range objects {
+		if o == object {
+			return true
+		}
+	}
+	return false
+}
+
+// applyAddon applies the objects of the addon with server-side apply. It returns the
+// objects applied, up to the first failure.
+func (r *WorkspaceReconciler) applyAddon(ctx context.Context, objects []*unstructured.Unstructured) ([]onyxiav1.AddonObject, error) {
+	applied := []onyxiav1.AddonObject{}
+	for _, object := range objects {
+		err := r.Patch(ctx, object, client.Apply, client.FieldOwner(managedByValue), client.ForceOwnership)
+		if err != nil {
+			return applied, fmt.Errorf("can't apply %s %s: %w", object.GetKind(), object.GetName(), err)
+		}
+		applied = append(applied, addonObject(object))
+	}
+	return applied, nil
+}
+
+// pruneAddon deletes the objects the addon installed in the namespace that are not kept.
+// Objects no longer labelled with the addon have been taken over and are left alone.
+// It returns the objects that could not be deleted.
+func (r *WorkspaceReconciler) pruneAddon(ctx context.Context, namespace string, addonName string, installed []onyxiav1.AddonObject, kept []onyxiav1.AddonObject) ([]onyxiav1.AddonObject, error) {
+	left := []onyxiav1.AddonObject{}
+	var errs []error
+	for _, installedObject := range installed {
+		if containsAddonObject(kept, installedObject) {
+			continue
+		}
+		object := &unstructured.Unstructured{}
+		object.SetAPIVersion(installedObject.APIVersion)
+		object.SetKind(installedObject.Kind)
+		err := r.Get(ctx, client.ObjectKey{Namespace: namespace, Name: installedObject.Name}, object)
+		if err == nil && object.GetLabels()[addonLabel] == addonName {
+			log.FromContext(ctx).Info("deleting addon object", "addon", addonName, "namespace", namespace, "kind", installedObject.Kind, "name", installedObject.Name)
+			err = r.Delete(ctx, object)
+		}
+		err = client.IgnoreNotFound(err)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("can't delete %s %s: %w", installedObject.Kind, installedObject.Name, err))
+			left = append(left, installedObject)
+		}
+	}
+	return left, utilerrors.NewAggregate(errs)
+}
+
+// handleAddons installs the WorkspaceAddons matching the workspace in its namespace and
+// prunes the objects removed from their manifests, or of the addons no longer matching.
+// The errors of an addon are reported in its status, they don't stop the other addons.
+func (r *WorkspaceReconciler) handleAddons(ctx context.Context, onyxiaWorkspace *onyxiav1.Workspace) error {
+	addons := &onyxiav1.WorkspaceAddonList{}
+	err := r.List(ctx, addons)
+	if err != nil {
+		return err
+	}
+	namespace := onyxiaWorkspace.Spec.Namespace
+	previous := map[string]onyxiav1.AddonStatus{}
+	for _, status := range onyxiaWorkspace.Status.Addons {
+		previous[status.Name] = status
+	}
+	statuses := []onyxiav1.AddonStatus{}
+	for i := range addons.Items {
+		addon := &addons.Items[i]
+		matches, err := addonMatches(addon, onyxiaWorkspace)
+		if err == nil && !matches {
+			continue
+		}
+		installed := previous[addon.Name].Objects
+		delete(previous, addon.Name)
+		status := onyxiav1.AddonStatus{Name: addon.Name}
+		var objects []*unstructured.Unstructured
+		if err == nil {
+			objects, err = r.renderAddon(addon, onyxiaWorkspace)
+		}
+		if err == nil {
+			status.Objects, err = r.applyAddon(ctx, objects)
+		}
+		if err == nil {
+			var left []onyxiav1.AddonObject
+			left, err = r.pruneAddon(ctx, namespace, addon.Name, installed, status.Objects)
+			status.Objects = append(status.Objects, left...)
+		} else {
+			// keep track of the installed objects, pruned once the addon is fixed
+			for _, object := range installed {
+				if !containsAddonObject(status.Objects, object) {
+					status.Objects = append(status.Objects, object)
+				}
+			}
+		}
+		if err != nil {
+			log.FromContext(ctx).Error(err, "can't install addon", "addon", addon.Name, "namespace", namespace)
+			status.Error = err.Error()
+		}
+		statuses = append(statuses, status)
+	}
+	// addons deleted or no longer matching the workspace
+	removed := []string{}
+	for name := range previous {
+		removed = append(removed, name)
+	}
+	sort.Strings(removed)
+	for _, name := range removed {
+		left, err := r.pruneAddon(ctx, namespace, name, previous[name].Objects, nil)
+		if err != nil {
+			log.FromContext(ctx).Error(err, "can't uninstall addon", "addon", name, "namespace", namespace)
+			statuses = append(statuses, onyxiav1.AddonStatus{Name: name, Objects: left, Error: err.Error()})
+		}
+	}
+	if len(statuses) == 0 {
+		statuses = nil
+	}
+	onyxiaWorkspace.Status.Addons = statuses
+	setAddonsCondition(onyxiaWorkspace)
+	return nil
+}
+
+// setAddonsCondition reports whether every addon of the workspace is installed
+func setAddonsCondition(onyxiaWorkspace *onyxiav1.Workspace) {
+	failed := []string{}
+	for _, status := range onyxiaWorkspace.Status.Addons {
+		if status.Error != "" {
+			failed = append(failed, status.Name+": "+status.Error)
+		}
+	}
+	condition := metav1.Condition{
+		Type:               "AddonsInstalled",
+		Status:             metav1.ConditionTrue,
+		Reason:             "ReasonSucceeded",
+		LastTransitionTime: metav1.NewTime(time.Now()),
+		Message:            "addons installed",
+		ObservedGeneration: onyxiaWorkspace.GetGeneration(),
+	}
+	if len(failed) > 0 {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "ReasonFailed"
+		condition.Message = strings.Join(failed, "; ")
+	}
+	meta.SetStatusCondition(&onyxiaWorkspace.Status.Conditions, condition)
+}
+
+// workspacesForAddon requeues every workspace when an addon changes, so that the
+// workspaces it no longer matches uninstall it
+func (r *WorkspaceReconciler) workspacesForAddon(addon client.Object) []reconcile.Request {
+	workspaces := &onyxiav1.WorkspaceList{}
+	err := r.List(context.Background(), workspaces)
+	if err != nil {
+		log.Log.Error(err, err.Error())
+		return nil
+	}
+	requests := []reconcile.Request{}
+	for _, workspace := range workspaces.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&workspace)})
+	}
+	return requests
+}
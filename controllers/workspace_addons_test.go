@@ -0,0 +1,223 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	onyxiav1 "github.com/inseefrlab/onyxia-onboarding-operator/api/v1"
+	v1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// applyClient turns the server-side apply patches, which the fake client doesn't
+// support, into creates and updates
+type applyClient struct {
+	client.Client
+}
+
+func (c applyClient) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	if patch.Type() != types.ApplyPatchType {
+		return c.Client.Patch(ctx, obj, patch, opts...)
+	}
+	existing := &unstructured.Unstructured{}
+	existing.SetGroupVersionKind(obj.GetObjectKind().GroupVersionKind())
+	err := c.Get(ctx, client.ObjectKeyFromObject(obj), existing)
+	if apierrors.IsNotFound(err) {
+		return c.Create(ctx, obj)
+	}
+	if err != nil {
+		return err
+	}
+	obj.SetResourceVersion(existing.GetResourceVersion())
+	return c.Update(ctx, obj)
+}
+
+var _ = Describe("handleAddons", func() {
+	var k8sClient client.Client
+	var reconciler *WorkspaceReconciler
+	var workspace *onyxiav1.Workspace
+	var addon *onyxiav1.WorkspaceAddon
+
+	const manifests = `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: onyxia-workspace
+data:
+  workspace: {{ .Name }}
+  bucket: {{ .Bucket }}
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: Role
+metadata:
+  name: onyxia-reader
+rules:
+- apiGroups: [""]
+  resources: ["configmaps"]
+  verbs: ["get"]
+`
+
+	BeforeEach(func() {
+		mapper := meta.NewDefaultRESTMapper(nil)
+		mapper.Add(v1.SchemeGroupVersion.WithKind("ConfigMap"), meta.RESTScopeNamespace)
+		mapper.Add(v1.SchemeGroupVersion.WithKind("Namespace"), meta.RESTScopeRoot)
+		mapper.Add(rbacv1.SchemeGroupVersion.WithKind("Role"), meta.RESTScopeNamespace)
+		mapper.Add(rbacv1.SchemeGroupVersion.WithKind("RoleBinding"), meta.RESTScopeNamespace)
+		addon = &onyxiav1.WorkspaceAddon{
+			ObjectMeta: metav1.ObjectMeta{Name: "defaults"},
+			Spec: onyxiav1.WorkspaceAddonSpec{
+				WorkspaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"onyxia.sh/addons": "default"}},
+				Manifests:         manifests,
+			},
+		}
+		k8sClient = applyClient{fake.NewClientBuilder().WithScheme(scheme.Scheme).WithRESTMapper(mapper).WithObjects(addon).Build()}
+		reconciler = &WorkspaceReconciler{Client: k8sClient, Scheme: scheme.Scheme}
+		workspace = newWorkspace("titi")
+		workspace.Labels = map[string]string{"onyxia.sh/addons": "default"}
+		workspace.Status.Bucket.Name = "bucket-titi"
+	})
+
+	configMap := func() (*v1.ConfigMap, error) {
+		configMap := &v1.ConfigMap{}
+		err := k8sClient.Get(context.Background(), client.ObjectKey{Namespace: "titi", Name: "onyxia-workspace"}, configMap)
+		return configMap, err
+	}
+
+	role := func() (*rbacv1.Role, error) {
+		role := &rbacv1.Role{}
+		err := k8sClient.Get(context.Background(), client.ObjectKey{Namespace: "titi", Name: "onyxia-reader"}, role)
+		return role, err
+	}
+
+	updateManifests := func(manifests string) {
+		addon.Spec.Manifests = manifests
+		Expect(k8sClient.Update(context.Background(), addon)).To(Succeed())
+	}
+
+	It("renders the manifests for the workspace in its namespace", func() {
+		objects, err := reconciler.renderAddon(addon, workspace)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(objects).To(HaveLen(2))
+		Expect(objects[0].GetNamespace()).To(Equal("titi"))
+		Expect(objects[0].GetLabels()).To(HaveKeyWithValue(addonLabel, "defaults"))
+		Expect(objects[0].Object["data"]).To(Equal(map[string]interface{}{"workspace": "titi", "bucket": "bucket-titi"}))
+	})
+
+	It("refuses the kinds the operator can't manage", func() {
+		addon.Spec.Manifests = "apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: jupyter\n"
+		_, err := reconciler.renderAddon(addon, workspace)
+		Expect(err).To(MatchError(ContainSubstring("is not a ConfigMap, Role, RoleBinding or PodDisruptionBudget")))
+	})
+
+	roleBinding := func(name string, kind string, role string) string {
+		return "---\napiVersion: rbac.authorization.k8s.io/v1\nkind: RoleBinding\nmetadata:\n  name: " + name +
+			"\nroleRef:\n  apiGroup: rbac.authorization.k8s.io\n  kind: " + kind + "\n  name: " + role +
+			"\nsubjects:\n- kind: Group\n  name: titi\n"
+	}
+
+	It("binds the Roles of the addon and the ClusterRoles of the configuration", func() {
+		reconciler.Config.AddonClusterRoles = []string{"view"}
+		addon.Spec.Manifests = manifests + roleBinding("onyxia-reader", "Role", "onyxia-reader") + roleBinding("onyxia-viewer", "ClusterRole", "view")
+		objects, err := reconciler.renderAddon(addon, workspace)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(objects).To(HaveLen(4))
+	})
+
+	It("refuses RoleBindings of other roles", func() {
+		reconciler.Config.AddonClusterRoles = []string{"view"}
+		addon.Spec.Manifests = manifests + roleBinding("onyxia-admin", "ClusterRole", "cluster-admin")
+		_, err := reconciler.renderAddon(addon, workspace)
+		Expect(err).To(MatchError(ContainSubstring("RoleBinding onyxia-admin binds ClusterRole cluster-admin, not an addon ClusterRole of the operator configuration")))
+
+		addon.Spec.Manifests = manifests + roleBinding("onyxia-admin", "Role", "admin")
+		_, err = reconciler.renderAddon(addon, workspace)
+		Expect(err).To(MatchError(ContainSubstring("RoleBinding onyxia-admin binds Role admin, not a Role of the addon")))
+	})
+
+	It("refuses manifests of another namespace", func() {
+		addon.Spec.Manifests = "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: onyxia-workspace\n  namespace: toto\n"
+		_, err := reconciler.renderAddon(addon, workspace)
+		Expect(err).To(MatchError(ContainSubstring("must be in the workspace namespace")))
+	})
+
+	It("installs the addon and records its objects", func() {
+		Expect(reconciler.handleAddons(context.Background(), workspace)).To(Succeed())
+		installed, err := configMap()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(installed.Data).To(HaveKeyWithValue("bucket", "bucket-titi"))
+		_, err = role()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(workspace.Status.Addons).To(Equal([]onyxiav1.AddonStatus{{Name: "defaults", Objects: []onyxiav1.AddonObject{
+			{APIVersion: "v1", Kind: "ConfigMap", Name: "onyxia-workspace"},
+			{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "Role", Name: "onyxia-reader"},
+		}}}))
+		Expect(meta.IsStatusConditionTrue(workspace.Status.Conditions, "AddonsInstalled")).To(BeTrue())
+	})
+
+	It("reports the addons that fail without stopping", func() {
+		updateManifests("apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: jupyter\n")
+		Expect(reconciler.handleAddons(context.Background(), workspace)).To(Succeed())
+		Expect(workspace.Status.Addons).To(HaveLen(1))
+		Expect(workspace.Status.Addons[0].Error).NotTo(BeEmpty())
+		Expect(meta.IsStatusConditionFalse(workspace.Status.Conditions, "AddonsInstalled")).To(BeTrue())
+	})
+
+	It("prunes the objects removed from the manifests", func() {
+		Expect(reconciler.handleAddons(context.Background(), workspace)).To(Succeed())
+		updateManifests("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: onyxia-workspace\n")
+		Expect(reconciler.handleAddons(context.Background(), workspace)).To(Succeed())
+		_, err := configMap()
+		Expect(err).NotTo(HaveOccurred())
+		_, err = role()
+		Expect(apierrors.IsNotFound(err)).To(BeTrue())
+		Expect(workspace.Status.Addons[0].Objects).To(Equal([]onyxiav1.AddonObject{{APIVersion: "v1", Kind: "ConfigMap", Name: "onyxia-workspace"}}))
+	})
+
+	It("uninstalls the addon when the workspace no longer matches", func() {
+		Expect(reconciler.handleAddons(context.Background(), workspace)).To(Succeed())
+		workspace.Labels = nil
+		Expect(reconciler.handleAddons(context.Background(), workspace)).To(Succeed())
+		_, err := configMap()
+		Expect(apierrors.IsNotFound(err)).To(BeTrue())
+		_, err = role()
+		Expect(apierrors.IsNotFound(err)).To(BeTrue())
+		Expect(workspace.Status.Addons).To(BeNil())
+	})
+
+	It("leaves the objects taken over by others", func() {
+		Expect(reconciler.handleAddons(context.Background(), workspace)).To(Succeed())
+		installed, err := configMap()
+		Expect(err).NotTo(HaveOccurred())
+		delete(installed.Labels, addonLabel)
+		Expect(k8sClient.Update(context.Background(), installed)).To(Succeed())
+		workspace.Labels = nil
+		Expect(reconciler.handleAddons(context.Background(), workspace)).To(Succeed())
+		_, err = configMap()
+		Expect(err).NotTo(HaveOccurred())
+	})
+})
@@ -33,19 +33,34 @@ import (
 	utilerrors "k8s.io/apimachinery/pkg/util/errors"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 )
 
 // WorkspaceReconciler reconciles a Workspace object
 type WorkspaceReconciler struct {
 	client.Client
-	Scheme   *runtime.Scheme
-	S3Client *factory.S3Client
+	Scheme    *runtime.Scheme
+	S3Clients *S3ClientPool
+	Config    OperatorConfig
 }
 
 //+kubebuilder:rbac:groups=onyxia.onyxia.sh,resources=workspaces,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=onyxia.onyxia.sh,resources=workspaces/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=onyxia.onyxia.sh,resources=workspaces/finalizers,verbs=update
+//+kubebuilder:rbac:groups=onyxia.onyxia.sh,resources=s3backends,verbs=get;list;watch
+//+kubebuilder:rbac:groups=onyxia.onyxia.sh,resources=workspaceaddons,verbs=get;list;watch
+//+kubebuilder:rbac:groups=core,resources=configmaps,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=core,resources=namespaces,verbs=get;list;watch;create;update;patch
+//+kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch;create;update;delete
+//+kubebuilder:rbac:groups=networking.k8s.io,resources=networkpolicies,verbs=get;list;watch;create;update;delete
+//+kubebuilder:rbac:groups=rbac.authorization.k8s.io,resources=rolebindings,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=rbac.authorization.k8s.io,resources=roles,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=rbac.authorization.k8s.io,resources=clusterroles,verbs=bind
+//+kubebuilder:rbac:groups=policy,resources=poddisruptionbudgets,verbs=get;list;watch;create;update;patch;delete
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
@@ -68,19 +83,39 @@ func (r *WorkspaceReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 	} else {
 		logger.Info("OnyxiaWorskpace to reconcile: " + fmt.Sprintf("%b", &onyxiaWorkspace))
 
-		err = handleBucket(onyxiaWorkspace, *r.S3Client)
+		if !onyxiaWorkspace.DeletionTimestamp.IsZero() {
+			err = r.finalizeIdentity(ctx, onyxiaWorkspace)
+			if err != nil {
+				return r.degrade(ctx, onyxiaWorkspace, err)
+			}
+			return ctrl.Result{}, nil
+		}
+		// the finalizer stays until the identity recorded in the status is deleted
+		hasIdentity := onyxiaWorkspace.Spec.Bucket.Credentials != nil || onyxiaWorkspace.Status.Identity != nil
+		if hasIdentity != controllerutil.ContainsFinalizer(onyxiaWorkspace, s3IdentityFinalizer) {
+			if hasIdentity {
+				controllerutil.AddFinalizer(onyxiaWorkspace, s3IdentityFinalizer)
+			} else {
+				controllerutil.RemoveFinalizer(onyxiaWorkspace, s3IdentityFinalizer)
+			}
+			err = r.Update(ctx, onyxiaWorkspace)
+			if err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+
+		s3Client, err := r.S3Clients.Get(ctx, onyxiaWorkspace.Spec.Bucket.BackendRef)
 		if err != nil {
-			log.Log.Error(err, err.Error())
-			meta.SetStatusCondition(&onyxiaWorkspace.Status.Conditions,
-				metav1.Condition{
-					Type:               "OperatorDegraded",
-					Status:             metav1.ConditionFalse,
-					Reason:             "ReasonFailed",
-					LastTransitionTime: metav1.NewTime(time.Now()),
-					Message:            err.Error(),
-					ObservedGeneration: onyxiaWorkspace.GetGeneration(),
-				})
-			return ctrl.Result{}, utilerrors.NewAggregate([]error{err, r.Status().Update(ctx, onyxiaWorkspace)})
+			return r.degrade(ctx, onyxiaWorkspace, err)
+		}
+		var unapplied []string
+		if sharedBucket := r.sharedBucket(onyxiaWorkspace); sharedBucket != "" {
+			err = r.handleSharedBucket(onyxiaWorkspace, s3Client, sharedBucket)
+		} else {
+			unapplied, err = handleBucket(onyxiaWorkspace, s3Client)
+		}
+		if err != nil {
+			return r.degrade(ctx, onyxiaWorkspace, err)
 		}
 		namespaceConfiguration := &v1.Namespace{
 			ObjectMeta: metav1.ObjectMeta{Name: onyxiaWorkspace.Spec.Namespace},
@@ -93,35 +128,72 @@ func (r *WorkspaceReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 		err = r.Create(ctx, namespaceConfiguration)
 		err = client.IgnoreAlreadyExists(err)
 		if err != nil {
-			log.Log.Error(err, err.Error())
-			meta.SetStatusCondition(&onyxiaWorkspace.Status.Conditions,
-				metav1.Condition{
-					Type:               "OperatorDegraded",
-					Status:             metav1.ConditionFalse,
-					Reason:             "ReasonFailed",
-					LastTransitionTime: metav1.NewTime(time.Now()),
-					Message:            "failed to create namespace",
-					ObservedGeneration: onyxiaWorkspace.GetGeneration(),
-				})
-			onyxiaWorkspace.Status.ObservedGeneration = onyxiaWorkspace.GetGeneration()
-			return ctrl.Result{}, utilerrors.NewAggregate([]error{err, r.Status().Update(ctx, onyxiaWorkspace)})
+			return r.degrade(ctx, onyxiaWorkspace, fmt.Errorf("failed to create namespace: %w", err))
+		}
+		err = r.handlePodSecurity(ctx, onyxiaWorkspace)
+		if err != nil {
+			return r.degrade(ctx, onyxiaWorkspace, err)
+		}
+		err = r.handleNamespaceMetadata(ctx, onyxiaWorkspace)
+		if err != nil {
+			return r.degrade(ctx, onyxiaWorkspace, err)
 		}
 		err = r.addResourceQuotaToNamespace(r.Client, onyxiaWorkspace)
 		err = client.IgnoreAlreadyExists(err)
 		if err != nil {
-			log.Log.Error(err, err.Error())
-			meta.SetStatusCondition(&onyxiaWorkspace.Status.Conditions,
-				metav1.Condition{
-					Type:               "OperatorDegraded",
-					Status:             metav1.ConditionFalse,
-					Reason:             "ReasonFailed",
-					LastTransitionTime: metav1.NewTime(time.Now()),
-					Message:            "failed to put resourcequota",
-					ObservedGeneration: onyxiaWorkspace.GetGeneration(),
-				})
-			onyxiaWorkspace.Status.ObservedGeneration = onyxiaWorkspace.GetGeneration()
-			return ctrl.Result{}, utilerrors.NewAggregate([]error{err, r.Status().Update(ctx, onyxiaWorkspace)})
+			return r.degrade(ctx, onyxiaWorkspace, fmt.Errorf("failed to put resourcequota: %w", err))
+		}
+		err = r.handleMembers(ctx, onyxiaWorkspace)
+		if err != nil {
+			return r.degrade(ctx, onyxiaWorkspace, err)
+		}
+		err = r.handleNetworkPolicies(ctx, onyxiaWorkspace)
+		if err != nil {
+			return r.degrade(ctx, onyxiaWorkspace, err)
+		}
+		err = r.handleIdentity(ctx, onyxiaWorkspace, s3Client)
+		if err != nil {
+			return r.degrade(ctx, onyxiaWorkspace, err)
 		}
+		accessUnapplied, err := r.handleAccess(ctx, onyxiaWorkspace, s3Client)
+		if err != nil {
+			return r.degrade(ctx, onyxiaWorkspace, err)
+		}
+		unapplied = append(unapplied, accessUnapplied...)
+		protectionUnapplied, err := handleProtection(onyxiaWorkspace, s3Client)
+		if err != nil {
+			return r.degrade(ctx, onyxiaWorkspace, err)
+		}
+		unapplied = append(unapplied, protectionUnapplied...)
+		err = r.handleLifecycle(onyxiaWorkspace, s3Client)
+		if err != nil {
+			return r.degrade(ctx, onyxiaWorkspace, err)
+		}
+		err = r.handleCORS(onyxiaWorkspace, s3Client)
+		if err != nil {
+			return r.degrade(ctx, onyxiaWorkspace, err)
+		}
+		err = r.handleNotifications(ctx, onyxiaWorkspace, s3Client)
+		if err != nil {
+			return r.degrade(ctx, onyxiaWorkspace, err)
+		}
+		err = handleEncryption(onyxiaWorkspace, s3Client)
+		if err != nil {
+			return r.degrade(ctx, onyxiaWorkspace, err)
+		}
+		err = r.handleSeed(ctx, onyxiaWorkspace, s3Client)
+		if err != nil {
+			return r.degrade(ctx, onyxiaWorkspace, err)
+		}
+		err = r.handleAddons(ctx, onyxiaWorkspace)
+		if err != nil {
+			return r.degrade(ctx, onyxiaWorkspace, err)
+		}
+		err = r.handleUsage(onyxiaWorkspace, s3Client)
+		if err != nil {
+			return r.degrade(ctx, onyxiaWorkspace, err)
+		}
+		setBucketSettingsCondition(onyxiaWorkspace, append(unapplied, sharedBucketUnapplied(onyxiaWorkspace)...))
 		logger.Info("Created / updated namespace", "namespace", onyxiaWorkspace.Namespace)
 		onyxiaWorkspace.Status.ObservedGeneration = onyxiaWorkspace.GetGeneration()
 		meta.SetStatusCondition(&onyxiaWorkspace.Status.Conditions, metav1.Condition{
@@ -132,73 +204,173 @@ func (r *WorkspaceReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 			Message:            "operator successfully reconciling",
 			ObservedGeneration: onyxiaWorkspace.GetGeneration(),
 		})
-		return ctrl.Result{}, utilerrors.NewAggregate([]error{err, r.Status().Update(ctx, onyxiaWorkspace)})
+		if seeding(onyxiaWorkspace) {
+			// requeue to copy the next seed objects
+			return ctrl.Result{Requeue: true}, utilerrors.NewAggregate([]error{err, r.Status().Update(ctx, onyxiaWorkspace)})
+		}
+		// requeue to refresh the bucket usage
+		return ctrl.Result{RequeueAfter: r.Config.usageRefreshInterval()}, utilerrors.NewAggregate([]error{err, r.Status().Update(ctx, onyxiaWorkspace)})
 	}
 
 	return ctrl.Result{}, nil
 }
 
+// degrade reports the failure of a reconcile step in the OperatorDegraded condition
+// of the workspace and returns it, so that the workspace is reconciled again
+func (r *WorkspaceReconciler) degrade(ctx context.Context, onyxiaWorkspace *onyxiav1.Workspace, err error) (ctrl.Result, error) {
+	log.Log.Error(err, err.Error())
+	meta.SetStatusCondition(&onyxiaWorkspace.Status.Conditions,
+		metav1.Condition{
+			Type:               "OperatorDegraded",
+			Status:             metav1.ConditionFalse,
+			Reason:             "ReasonFailed",
+			LastTransitionTime: metav1.NewTime(time.Now()),
+			Message:            err.Error(),
+			ObservedGeneration: onyxiaWorkspace.GetGeneration(),
+		})
+	return ctrl.Result{}, utilerrors.NewAggregate([]error{err, r.Status().Update(ctx, onyxiaWorkspace)})
+}
+
 // SetupWithManager sets up the controller with the Manager.
 func (r *WorkspaceReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&onyxiav1.Workspace{}).
 		//Owns(&v1.Namespace{}).
 		Owns(&v1.ResourceQuota{}).
+		Watches(&source.Kind{Type: &onyxiav1.S3Backend{}}, handler.EnqueueRequestsFromMapFunc(r.workspacesForS3Backend)).
+		Watches(&source.Kind{Type: &onyxiav1.WorkspaceAddon{}}, handler.EnqueueRequestsFromMapFunc(r.workspacesForAddon)).
+		Watches(&source.Kind{Type: &v1.Secret{}}, handler.EnqueueRequestsFromMapFunc(r.workspacesForSecret)).
 		Complete(r)
 }
 
-func handleBucket(onyxiaWorkspace *onyxiav1.Workspace, s3Client factory.S3Client) error {
+// workspacesForS3Backend requeues the workspaces hosted on a S3Backend when it changes
+func (r *WorkspaceReconciler) workspacesForS3Backend(backend client.Object) []reconcile.Request {
+	workspaces := &onyxiav1.WorkspaceList{}
+	err := r.List(context.Background(), workspaces)
+	if err != nil {
+		log.Log.Error(err, err.Error())
+		return nil
+	}
+	requests := []reconcile.Request{}
+	for _, workspace := range workspaces.Items {
+		if workspace.Spec.Bucket.BackendRef == backend.GetName() {
+			requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&workspace)})
+		}
+	}
+	return requests
+}
+
+// workspacesForSecret requeues the workspaces hosted on the backends whose credentials
+// are in the secret, so that their s3 client is rebuilt as soon as the secret is rotated
+func (r *WorkspaceReconciler) workspacesForSecret(secret client.Object) []reconcile.Request {
+	if r.S3Clients == nil {
+		return nil
+	}
+	backends, err := r.S3Clients.backendsUsingSecret(context.Background(), client.ObjectKeyFromObject(secret))
+	if err != nil {
+		log.Log.Error(err, err.Error())
+		return nil
+	}
+	if len(backends) == 0 {
+		return nil
+	}
+	workspaces := &onyxiav1.WorkspaceList{}
+	err = r.List(context.Background(), workspaces)
+	if err != nil {
+		log.Log.Error(err, err.Error())
+		return nil
+	}
+	requests := []reconcile.Request{}
+	for _, workspace := range workspaces.Items {
+		if backends[workspace.Spec.Bucket.BackendRef] {
+			requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&workspace)})
+		}
+	}
+	return requests
+}
+
+// bucketQuota returns the quota enforced by the object store, none for soft quotas
+func bucketQuota(bucket onyxiav1.Bucket) factory.Quota {
+	if bucket.QuotaType == "soft" {
+		return factory.Quota{}
+	}
+	return factory.Quota{Bytes: quotaBytes(bucket), Objects: bucket.ObjectQuota}
+}
+
+// quotaBytes returns the size quota of the bucket in bytes, 0 if unlimited
+func quotaBytes(bucket onyxiav1.Bucket) int64 {
+	if bucket.Quota == nil {
+		return 0
+	}
+	return bucket.Quota.Value()
+}
+
+// handleBucket makes sure the bucket of the workspace exists, with its tags, quota
+// and paths. Quota limits the object store doesn't have are returned, to be reported
+// in the workspace status, instead of failing the reconcile.
+func handleBucket(onyxiaWorkspace *onyxiav1.Workspace, s3Client factory.S3Client) ([]string, error) {
+	unapplied := []string{}
+	if onyxiaWorkspace.Status.Bucket.Name != onyxiaWorkspace.Spec.Bucket.Name {
+		// the legal hold was applied to the objects of another bucket
+		onyxiaWorkspace.Status.Bucket.LegalHold = ""
+	}
+	onyxiaWorkspace.Status.Bucket.Name = onyxiaWorkspace.Spec.Bucket.Name
+	onyxiaWorkspace.Status.Bucket.Prefix = ""
 	//create bucket
 	found, err := s3Client.BucketExists(onyxiaWorkspace.Spec.Bucket.Name)
 	if err != nil {
 		log.Log.Error(err, err.Error())
-		return fmt.Errorf("can't create bucket " + onyxiaWorkspace.Spec.Bucket.Name)
+		return nil, fmt.Errorf("can't create bucket " + onyxiaWorkspace.Spec.Bucket.Name)
 	}
 	if !found {
-		err = s3Client.CreateBucket(onyxiaWorkspace.Spec.Bucket.Name)
+		if onyxiaWorkspace.Spec.Bucket.ObjectLock != nil {
+			err = s3Client.CreateBucketWithObjectLock(onyxiaWorkspace.Spec.Bucket.Name)
+		} else {
+			err = s3Client.CreateBucket(onyxiaWorkspace.Spec.Bucket.Name)
+		}
 		if err != nil {
 			log.Log.Error(err, err.Error())
-			return fmt.Errorf("can't create bucket " + onyxiaWorkspace.Spec.Bucket.Name)
+			return nil, fmt.Errorf("can't create bucket " + onyxiaWorkspace.Spec.Bucket.Name)
 		}
-		err = s3Client.SetQuota(onyxiaWorkspace.Spec.Bucket.Name, onyxiaWorkspace.Spec.Bucket.Quota)
+		err = handleBucketTags(onyxiaWorkspace, s3Client)
 		if err != nil {
 			log.Log.Error(err, err.Error())
-			return fmt.Errorf("can't set quota for bucket " + onyxiaWorkspace.Spec.Bucket.Name)
+			return nil, err
 		}
-		for _, v := range onyxiaWorkspace.Spec.Bucket.Paths {
-			err = s3Client.CreatePath(onyxiaWorkspace.Spec.Bucket.Name, v)
-			if err != nil {
-				log.Log.Error(err, err.Error())
-				return fmt.Errorf("can't create path " + v)
-			}
+		err = s3Client.SetQuota(onyxiaWorkspace.Spec.Bucket.Name, bucketQuota(onyxiaWorkspace.Spec.Bucket))
+		if unsupported(err) {
+			unapplied = append(unapplied, err.Error())
+		} else if err != nil {
+			log.Log.Error(err, err.Error())
+			return nil, fmt.Errorf("can't set quota for bucket %s: %w", onyxiaWorkspace.Spec.Bucket.Name, err)
 		}
 	} else {
-		quota, err := s3Client.GetQuota(onyxiaWorkspace.Spec.Bucket.Name)
+		err = handleBucketTags(onyxiaWorkspace, s3Client)
 		if err != nil {
 			log.Log.Error(err, err.Error())
-			return fmt.Errorf("can't get quota for " + onyxiaWorkspace.Spec.Bucket.Name)
+			return nil, err
 		}
-		if quota != onyxiaWorkspace.Spec.Bucket.Quota {
-			err = s3Client.SetQuota(onyxiaWorkspace.Spec.Bucket.Name, onyxiaWorkspace.Spec.Bucket.Quota)
-			if err != nil {
-				log.Log.Error(err, err.Error())
-				return fmt.Errorf("can't set quota for " + onyxiaWorkspace.Spec.Bucket.Name)
-			}
+		quota, err := s3Client.GetQuota(onyxiaWorkspace.Spec.Bucket.Name)
+		if err != nil {
+			log.Log.Error(err, err.Error())
+			return nil, fmt.Errorf("can't get quota for " + onyxiaWorkspace.Spec.Bucket.Name)
 		}
-		for _, v := range onyxiaWorkspace.Spec.Bucket.Paths {
-			err = s3Client.PathExists(onyxiaWorkspace.Spec.Bucket.Name, v)
-			if err != nil {
+		if quota != bucketQuota(onyxiaWorkspace.Spec.Bucket) {
+			err = s3Client.SetQuota(onyxiaWorkspace.Spec.Bucket.Name, bucketQuota(onyxiaWorkspace.Spec.Bucket))
+			if unsupported(err) {
+				unapplied = append(unapplied, err.Error())
+			} else if err != nil {
 				log.Log.Error(err, err.Error())
-				return fmt.Errorf("can't check path " + onyxiaWorkspace.Spec.Bucket.Name)
-			}
-			err = s3Client.CreatePath(onyxiaWorkspace.Spec.Bucket.Name, v)
-			if err != nil {
-				log.Log.Error(err, err.Error())
-				return fmt.Errorf("can't create path " + v)
+				return nil, fmt.Errorf("can't set quota for %s: %w", onyxiaWorkspace.Spec.Bucket.Name, err)
 			}
 		}
 	}
-	return nil
+	err = handlePaths(onyxiaWorkspace, s3Client)
+	if err != nil {
+		log.Log.Error(err, err.Error())
+		return nil, err
+	}
+	return unapplied, nil
 }
 
 func (r *WorkspaceReconciler) addResourceQuotaToNamespace(c client.Client, onyxiaWorkspace *onyxiav1.Workspace) error {
@@ -0,0 +1,193 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	onyxiav1 "github.com/inseefrlab/onyxia-onboarding-operator/api/v1"
+	"github.com/inseefrlab/onyxia-onboarding-operator/controllers/s3/factory"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// newMockedS3Client returns the in-memory fake of an empty object store
+func newMockedS3Client() *factory.MockedS3Client {
+	s3Client, err := factory.GetS3Client("mockedS3Provider", nil)
+	Expect(err).NotTo(HaveOccurred())
+	return s3Client.(*factory.MockedS3Client)
+}
+
+// newWorkspace returns a workspace of the operator namespace with a bucket of the same name
+func newWorkspace(name string) *onyxiav1.Workspace {
+	quota := resource.MustParse("100M")
+	return &onyxiav1.Workspace{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "onyxia-onboarding-operator-system", Name: name},
+		Spec: onyxiav1.WorkspaceSpec{
+			Namespace: name,
+			Bucket:    onyxiav1.Bucket{Name: "bucket-" + name, Quota: &quota},
+		},
+	}
+}
+
+var _ = Describe("handleBucket", func() {
+	var s3Client *factory.MockedS3Client
+	var workspace *onyxiav1.Workspace
+
+	BeforeEach(func() {
+		s3Client = newMockedS3Client()
+		workspace = newWorkspace("titi")
+	})
+
+	It("creates the bucket with its quota, tags and paths", func() {
+		workspace.Spec.Bucket.ObjectQuota = 1000
+		workspace.Spec.Bucket.Paths = []string{"diffusion", "sensible/2023"}
+		Expect(handleBucket(workspace, s3Client)).Error().NotTo(HaveOccurred())
+
+		Expect(workspace.Status.Bucket.Name).To(Equal("bucket-titi"))
+		quota, err := s3Client.GetQuota("bucket-titi")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(quota).To(Equal(factory.Quota{Bytes: 100000000, Objects: 1000}))
+		tags, err := s3Client.GetBucketTags("bucket-titi")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(tags).To(HaveKeyWithValue(workspaceTagKey, "titi"))
+		Expect(tags).To(HaveKeyWithValue(namespaceTagKey, "titi"))
+		for _, path := range workspace.Spec.Bucket.Paths {
+			found, err := s3Client.PathExists("bucket-titi", path)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(found).To(BeTrue(), path)
+		}
+	})
+
+	It("leaves soft quotas to the usage warning", func() {
+		workspace.Spec.Bucket.QuotaType = "soft"
+		Expect(handleBucket(workspace, s3Client)).Error().NotTo(HaveOccurred())
+		quota, err := s3Client.GetQuota("bucket-titi")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(quota).To(BeZero())
+	})
+
+	It("repairs the quota of an existing bucket", func() {
+		Expect(handleBucket(workspace, s3Client)).Error().NotTo(HaveOccurred())
+		Expect(s3Client.SetQuota("bucket-titi", factory.Quota{Bytes: 1024})).To(Succeed())
+
+		Expect(handleBucket(workspace, s3Client)).Error().NotTo(HaveOccurred())
+		quota, err := s3Client.GetQuota("bucket-titi")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(quota).To(Equal(factory.Quota{Bytes: 100000000}))
+	})
+
+	It("creates the bucket with object lock", func() {
+		workspace.Spec.Bucket.ObjectLock = &onyxiav1.ObjectLock{}
+		Expect(handleBucket(workspace, s3Client)).Error().NotTo(HaveOccurred())
+		objectLock, err := s3Client.GetObjectLock("bucket-titi")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(objectLock).NotTo(BeNil())
+	})
+
+	It("refuses the bucket of another workspace", func() {
+		Expect(handleBucket(newWorkspace("tata"), s3Client)).Error().NotTo(HaveOccurred())
+		workspace.Spec.Bucket.Name = "bucket-tata"
+		Expect(handleBucket(workspace, s3Client)).Error().To(MatchError(ContainSubstring("belongs to workspace tata")))
+	})
+
+	It("refuses the bucket of a workspace of the same name in another namespace", func() {
+		Expect(handleBucket(workspace, s3Client)).Error().NotTo(HaveOccurred())
+		workspace.Namespace = "datalab"
+		workspace.Spec.Namespace = "datalab-titi"
+		Expect(handleBucket(workspace, s3Client)).Error().To(MatchError(ContainSubstring("belongs to workspace titi of namespace titi")))
+	})
+
+	It("adopts the bucket tagged before the namespace tag", func() {
+		Expect(s3Client.CreateBucket("bucket-titi")).To(Succeed())
+		Expect(s3Client.SetBucketTags("bucket-titi", map[string]string{workspaceTagKey: "titi"})).To(Succeed())
+		Expect(handleBucket(workspace, s3Client)).Error().NotTo(HaveOccurred())
+		tags, err := s3Client.GetBucketTags("bucket-titi")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(tags).To(HaveKeyWithValue(namespaceTagKey, "titi"))
+	})
+
+	It("reports the quota limits the object store doesn't have", func() {
+		s3Client.Failures["SetQuota"] = fmt.Errorf("object quota of bucket bucket-titi is not applied: %w", factory.ErrUnsupported)
+		unapplied, err := handleBucket(workspace, s3Client)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(unapplied).To(ConsistOf(ContainSubstring("object quota of bucket bucket-titi")))
+	})
+
+	It("reports the failures of the object store", func() {
+		s3Client.Failures["SetQuota"] = errors.New("quota backend unavailable")
+		Expect(handleBucket(workspace, s3Client)).Error().To(MatchError(ContainSubstring("quota backend unavailable")))
+	})
+})
+
+var _ = Describe("WorkspaceReconciler", func() {
+	var k8sClient client.Client
+	var s3Client factory.S3Client
+	var reconciler *WorkspaceReconciler
+	var workspace *onyxiav1.Workspace
+
+	BeforeEach(func() {
+		workspace = newWorkspace("titi")
+		workspace.Spec.Bucket.Paths = []string{"diffusion"}
+		k8sClient = newFakeClient(workspace)
+		pool := NewS3ClientPool(k8sClient, &factory.S3Config{S3Provider: "mockedS3Provider"}, nil)
+		var err error
+		s3Client, err = pool.Get(context.Background(), "")
+		Expect(err).NotTo(HaveOccurred())
+		reconciler = &WorkspaceReconciler{Client: k8sClient, Scheme: scheme.Scheme, S3Clients: pool}
+	})
+
+	reconcile := func() (ctrl.Result, error) {
+		return reconciler.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKeyFromObject(workspace)})
+	}
+
+	It("provisions the namespace and the bucket of the workspace", func() {
+		result, err := reconcile()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.RequeueAfter).To(Equal(reconciler.Config.usageRefreshInterval()))
+
+		Expect(k8sClient.Get(context.Background(), client.ObjectKey{Name: "titi"}, &v1.Namespace{})).To(Succeed())
+		found, err := s3Client.PathExists("bucket-titi", "diffusion")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(found).To(BeTrue())
+
+		Expect(k8sClient.Get(context.Background(), client.ObjectKeyFromObject(workspace), workspace)).To(Succeed())
+		Expect(workspace.Status.Bucket.Name).To(Equal("bucket-titi"))
+		Expect(meta.IsStatusConditionTrue(workspace.Status.Conditions, "OperatorSuccessful")).To(BeTrue())
+	})
+
+	It("reports the failure of a bucket step in the status", func() {
+		s3Client.(*factory.MockedS3Client).Failures["CreatePath"] = errors.New("object store unavailable")
+		_, err := reconcile()
+		Expect(err).To(MatchError(ContainSubstring("object store unavailable")))
+
+		Expect(k8sClient.Get(context.Background(), client.ObjectKeyFromObject(workspace), workspace)).To(Succeed())
+		degraded := meta.FindStatusCondition(workspace.Status.Conditions, "OperatorDegraded")
+		Expect(degraded).NotTo(BeNil())
+		Expect(degraded.Message).To(ContainSubstring("object store unavailable"))
+	})
+})
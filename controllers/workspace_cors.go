@@ -0,0 +1,94 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+	"reflect"
+
+	onyxiav1 "github.com/inseefrlab/onyxia-onboarding-operator/api/v1"
+	"github.com/inseefrlab/onyxia-onboarding-operator/controllers/s3/factory"
+)
+
+// corsMethods are the methods a CORS rule may allow
+var corsMethods = map[string]bool{"GET": true, "PUT": true, "POST": true, "DELETE": true, "HEAD": true}
+
+// corsRules appends the rules of the workspace to the default rules of the operator
+func corsRules(defaults []onyxiav1.CORSRule, rules []onyxiav1.CORSRule) ([]factory.CORSRule, error) {
+	corsRules := []factory.CORSRule{}
+	for _, rule := range append(append([]onyxiav1.CORSRule{}, defaults...), rules...) {
+		if len(rule.AllowedOrigins) == 0 || len(rule.AllowedMethods) == 0 {
+			return nil, fmt.Errorf("cors rule needs allowed origins and methods")
+		}
+		for _, method := range rule.AllowedMethods {
+			if !corsMethods[method] {
+				return nil, fmt.Errorf("cors method %s is not supported", method)
+			}
+		}
+		corsRule := factory.CORSRule{
+			AllowedOrigins: rule.AllowedOrigins,
+			AllowedMethods: rule.AllowedMethods,
+			MaxAgeSeconds:  int(rule.MaxAgeSeconds),
+		}
+		// the object store returns no header rather than an empty list
+		if len(rule.AllowedHeaders) > 0 {
+			corsRule.AllowedHeaders = rule.AllowedHeaders
+		}
+		if len(rule.ExposeHeaders) > 0 {
+			corsRule.ExposeHeaders = rule.ExposeHeaders
+		}
+		corsRules = append(corsRules, corsRule)
+	}
+	return corsRules, nil
+}
+
+// handleCORS applies the CORS rules when the bucket rules differ. Without any rule,
+// the CORS rules the operator set are removed, those set by others are left untouched.
+func (r *WorkspaceReconciler) handleCORS(onyxiaWorkspace *onyxiav1.Workspace, s3Client factory.S3Client) error {
+	bucketname, prefix := bucketLocation(onyxiaWorkspace)
+	if prefix != "" {
+		// the CORS rules of a shared bucket are left to the administrators
+		return nil
+	}
+	rules, err := corsRules(r.Config.DefaultCORSRules, onyxiaWorkspace.Spec.Bucket.CORS)
+	if err != nil {
+		return err
+	}
+	if len(rules) == 0 {
+		if !onyxiaWorkspace.Status.Bucket.CORSRulesApplied {
+			return nil
+		}
+		err = s3Client.SetCORS(bucketname, nil)
+		if err != nil {
+			return fmt.Errorf("can't remove cors rules of bucket %s: %w", bucketname, err)
+		}
+		onyxiaWorkspace.Status.Bucket.CORSRulesApplied = false
+		return nil
+	}
+	current, err := s3Client.GetCORS(bucketname)
+	if err != nil {
+		return fmt.Errorf("can't get cors rules of bucket %s: %w", bucketname, err)
+	}
+	if !reflect.DeepEqual(current, rules) {
+		err = s3Client.SetCORS(bucketname, rules)
+		if err != nil {
+			return fmt.Errorf("can't set cors rules of bucket %s: %w", bucketname, err)
+		}
+	}
+	onyxiaWorkspace.Status.Bucket.CORSRulesApplied = true
+	return nil
+}
@@ -0,0 +1,68 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	onyxiav1 "github.com/inseefrlab/onyxia-onboarding-operator/api/v1"
+	"github.com/inseefrlab/onyxia-onboarding-operator/controllers/s3/factory"
+)
+
+var _ = Describe("handleCORS", func() {
+	var reconciler *WorkspaceReconciler
+	var s3Client *factory.MockedS3Client
+	var workspace *onyxiav1.Workspace
+
+	BeforeEach(func() {
+		reconciler = &WorkspaceReconciler{}
+		s3Client = newMockedS3Client()
+		workspace = newWorkspace("titi")
+		workspace.Status.Bucket.Name = "bucket-titi"
+		workspace.Spec.Bucket.CORS = []onyxiav1.CORSRule{{AllowedOrigins: []string{"https://datalab.example.com"}, AllowedMethods: []string{"GET"}}}
+		Expect(s3Client.CreateBucket("bucket-titi")).To(Succeed())
+	})
+
+	cors := func() []factory.CORSRule {
+		rules, err := s3Client.GetCORS("bucket-titi")
+		Expect(err).NotTo(HaveOccurred())
+		return rules
+	}
+
+	It("applies the cors rules of the workspace", func() {
+		Expect(reconciler.handleCORS(workspace, s3Client)).To(Succeed())
+		Expect(cors()).To(Equal([]factory.CORSRule{{AllowedOrigins: []string{"https://datalab.example.com"}, AllowedMethods: []string{"GET"}}}))
+		Expect(workspace.Status.Bucket.CORSRulesApplied).To(BeTrue())
+	})
+
+	It("removes the cors rules it set when the spec empties", func() {
+		Expect(reconciler.handleCORS(workspace, s3Client)).To(Succeed())
+		workspace.Spec.Bucket.CORS = nil
+		Expect(reconciler.handleCORS(workspace, s3Client)).To(Succeed())
+		Expect(cors()).To(BeEmpty())
+		Expect(workspace.Status.Bucket.CORSRulesApplied).To(BeFalse())
+	})
+
+	It("leaves the cors rules set by others", func() {
+		others := []factory.CORSRule{{AllowedOrigins: []string{"*"}, AllowedMethods: []string{"HEAD"}}}
+		Expect(s3Client.SetCORS("bucket-titi", others)).To(Succeed())
+		workspace.Spec.Bucket.CORS = nil
+		Expect(reconciler.handleCORS(workspace, s3Client)).To(Succeed())
+		Expect(cors()).To(Equal(others))
+	})
+})
@@ -0,0 +1,107 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+	"time"
+
+	onyxiav1 "github.com/inseefrlab/onyxia-onboarding-operator/api/v1"
+	"github.com/inseefrlab/onyxia-onboarding-operator/controllers/s3/factory"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// handleEncryption applies the default encryption of the bucket, then reports the
+// effective one in the status, even when it could not be applied. Without encryption
+// in the spec, the encryption the operator set is removed, one set by others is left
+// untouched. The encryption of a shared bucket is only reported.
+func handleEncryption(onyxiaWorkspace *onyxiav1.Workspace, s3Client factory.S3Client) error {
+	bucket := onyxiaWorkspace.Spec.Bucket
+	bucketname, prefix := bucketLocation(onyxiaWorkspace)
+	var applyErr error
+	if bucket.Encryption != nil && prefix == "" {
+		applyErr = applyEncryption(bucketname, bucket.Encryption, s3Client)
+		if applyErr == nil {
+			onyxiaWorkspace.Status.Bucket.EncryptionApplied = true
+		}
+	} else if onyxiaWorkspace.Status.Bucket.EncryptionApplied && prefix == "" {
+		applyErr = s3Client.RemoveEncryption(bucketname)
+		if applyErr != nil {
+			applyErr = fmt.Errorf("can't remove encryption of bucket %s: %w", bucketname, applyErr)
+		} else {
+			onyxiaWorkspace.Status.Bucket.EncryptionApplied = false
+		}
+	}
+	effective, err := s3Client.GetEncryption(bucketname)
+	if err != nil {
+		return fmt.Errorf("can't get encryption of bucket %s: %w", bucketname, err)
+	}
+	onyxiaWorkspace.Status.Bucket.Encryption = "none"
+	onyxiaWorkspace.Status.Bucket.KMSKeyID = ""
+	if effective != nil {
+		onyxiaWorkspace.Status.Bucket.Encryption = effective.Type
+		onyxiaWorkspace.Status.Bucket.KMSKeyID = effective.KMSKeyID
+	}
+
+	condition := metav1.Condition{
+		Type:               "Degraded",
+		Status:             metav1.ConditionFalse,
+		Reason:             "ReasonSucceeded",
+		LastTransitionTime: metav1.NewTime(time.Now()),
+		Message:            "bucket encryption is as expected",
+		ObservedGeneration: onyxiaWorkspace.GetGeneration(),
+	}
+	if bucket.Sensitive && effective == nil {
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = "UnencryptedSensitiveBucket"
+		condition.Message = "bucket " + bucketname + " holds sensitive data but is not encrypted"
+		if applyErr != nil {
+			condition.Message += ": " + applyErr.Error()
+		}
+	}
+	meta.SetStatusCondition(&onyxiaWorkspace.Status.Conditions, condition)
+	return applyErr
+}
+
+func applyEncryption(bucketname string, encryption *onyxiav1.Encryption, s3Client factory.S3Client) error {
+	desired := factory.Encryption{Type: encryption.Type}
+	if encryption.Type == factory.EncryptionSSEKMS {
+		if encryption.KMSKeyID == "" {
+			return fmt.Errorf("SSE-KMS encryption of bucket %s needs a kms key id", bucketname)
+		}
+		desired.KMSKeyID = encryption.KMSKeyID
+		if encryption.CreateKey {
+			err := s3Client.CreateKMSKey(encryption.KMSKeyID)
+			if err != nil {
+				return fmt.Errorf("can't create kms key %s: %w", encryption.KMSKeyID, err)
+			}
+		}
+	}
+	current, err := s3Client.GetEncryption(bucketname)
+	if err != nil {
+		return fmt.Errorf("can't get encryption of bucket %s: %w", bucketname, err)
+	}
+	if current != nil && *current == desired {
+		return nil
+	}
+	err = s3Client.SetEncryption(bucketname, desired)
+	if err != nil {
+		return fmt.Errorf("can't set encryption of bucket %s: %w", bucketname, err)
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	onyxiav1 "github.com/inseefrlab/onyxia-onboarding-operator/api/v1"
+	"github.com/inseefrlab/onyxia-onboarding-operator/controllers/s3/factory"
+	"k8s.io/apimachinery/pkg/api/meta"
+)
+
+var _ = Describe("handleEncryption", func() {
+	var s3Client *factory.MockedS3Client
+	var workspace *onyxiav1.Workspace
+
+	BeforeEach(func() {
+		s3Client = newMockedS3Client()
+		workspace = newWorkspace("titi")
+		workspace.Status.Bucket.Name = "bucket-titi"
+		workspace.Spec.Bucket.Encryption = &onyxiav1.Encryption{Type: factory.EncryptionSSES3}
+		Expect(s3Client.CreateBucket("bucket-titi")).To(Succeed())
+	})
+
+	encryption := func() *factory.Encryption {
+		encryption, err := s3Client.GetEncryption("bucket-titi")
+		Expect(err).NotTo(HaveOccurred())
+		return encryption
+	}
+
+	It("sets the encryption of the workspace and reports it", func() {
+		Expect(handleEncryption(workspace, s3Client)).To(Succeed())
+		Expect(encryption()).To(Equal(&factory.Encryption{Type: factory.EncryptionSSES3}))
+		Expect(workspace.Status.Bucket.Encryption).To(Equal(factory.EncryptionSSES3))
+		Expect(workspace.Status.Bucket.EncryptionApplied).To(BeTrue())
+	})
+
+	It("changes the encryption, creating its kms key", func() {
+		Expect(handleEncryption(workspace, s3Client)).To(Succeed())
+		workspace.Spec.Bucket.Encryption = &onyxiav1.Encryption{Type: factory.EncryptionSSEKMS, KMSKeyID: "projet-titi", CreateKey: true}
+		Expect(handleEncryption(workspace, s3Client)).To(Succeed())
+		Expect(encryption()).To(Equal(&factory.Encryption{Type: factory.EncryptionSSEKMS, KMSKeyID: "projet-titi"}))
+		Expect(workspace.Status.Bucket.Encryption).To(Equal(factory.EncryptionSSEKMS))
+		Expect(workspace.Status.Bucket.KMSKeyID).To(Equal("projet-titi"))
+	})
+
+	It("removes the encryption it set when the spec empties", func() {
+		Expect(handleEncryption(workspace, s3Client)).To(Succeed())
+		workspace.Spec.Bucket.Encryption = nil
+		Expect(handleEncryption(workspace, s3Client)).To(Succeed())
+		Expect(encryption()).To(BeNil())
+		Expect(workspace.Status.Bucket.Encryption).To(Equal("none"))
+		Expect(workspace.Status.Bucket.EncryptionApplied).To(BeFalse())
+	})
+
+	It("leaves the encryption set by others", func() {
+		Expect(s3Client.SetEncryption("bucket-titi", factory.Encryption{Type: factory.EncryptionSSES3})).To(Succeed())
+		workspace.Spec.Bucket.Encryption = nil
+		Expect(handleEncryption(workspace, s3Client)).To(Succeed())
+		Expect(encryption()).To(Equal(&factory.Encryption{Type: factory.EncryptionSSES3}))
+		Expect(workspace.Status.Bucket.Encryption).To(Equal(factory.EncryptionSSES3))
+	})
+
+	It("reports a sensitive bucket left unencrypted", func() {
+		workspace.Spec.Bucket.Sensitive = true
+		workspace.Spec.Bucket.Encryption = &onyxiav1.Encryption{Type: factory.EncryptionSSEKMS}
+		Expect(handleEncryption(workspace, s3Client)).To(MatchError(ContainSubstring("needs a kms key id")))
+		Expect(meta.IsStatusConditionTrue(workspace.Status.Conditions, "Degraded")).To(BeTrue())
+	})
+})
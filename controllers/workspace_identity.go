@@ -0,0 +1,201 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	onyxiav1 "github.com/inseefrlab/onyxia-onboarding-operator/api/v1"
+	"github.com/inseefrlab/onyxia-onboarding-operator/controllers/s3/factory"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	// finalizer deleting the workspace S3 identity
+	s3IdentityFinalizer = "onyxia.sh/s3-identity"
+	// changing the value of this workspace annotation rotates the S3 identity keys
+	rotateS3CredentialsAnnotation  = "onyxia.sh/rotate-s3-credentials"
+	defaultS3CredentialsSecretName = "onyxia-s3"
+	managedByLabel                 = "app.kubernetes.io/managed-by"
+	managedByValue                 = "onyxia-onboarding-operator"
+	// prefix of the S3 identities created by the operator, it never manages the others
+	s3IdentityPrefix = "onyxia-"
+)
+
+// s3IdentityName returns the name of the workspace S3 identity
+func s3IdentityName(onyxiaWorkspace *onyxiav1.Workspace) string {
+	return s3IdentityPrefix + onyxiaWorkspace.Spec.Bucket.Name
+}
+
+// s3CredentialsSecretName returns the name of the secret holding the workspace S3 keys
+func s3CredentialsSecretName(onyxiaWorkspace *onyxiav1.Workspace) string {
+	if onyxiaWorkspace.Spec.Bucket.Credentials.SecretName != "" {
+		return onyxiaWorkspace.Spec.Bucket.Credentials.SecretName
+	}
+	return defaultS3CredentialsSecretName
+}
+
+// handleIdentity makes sure the workspace has an S3 identity, named after its
+// bucket and only allowed on its bucket or its folder of a shared bucket, and
+// that its keys are in the workspace namespace. Keys are only regenerated when
+// the secret is lost or a rotation is requested. The identity recorded in the
+// status is deleted when the credentials are removed from the spec, or replaced
+// when the bucket is renamed, and follows the bucket location when it changes.
+// Only the identities the operator created, recorded in the status, are managed:
+// an identity of the same name that already exists is refused.
+func (r *WorkspaceReconciler) handleIdentity(ctx context.Context, onyxiaWorkspace *onyxiav1.Workspace, s3Client factory.S3Client) error {
+	recorded := onyxiaWorkspace.Status.Identity
+	if onyxiaWorkspace.Spec.Bucket.Credentials == nil {
+		if recorded == nil {
+			return nil
+		}
+		err := r.deleteIdentity(ctx, onyxiaWorkspace, s3Client, *recorded)
+		if err != nil {
+			return err
+		}
+		onyxiaWorkspace.Status.Identity = nil
+		return nil
+	}
+	name := s3IdentityName(onyxiaWorkspace)
+	secretName := s3CredentialsSecretName(onyxiaWorkspace)
+	bucketname, prefix := bucketLocation(onyxiaWorkspace)
+	if recorded != nil && recorded.Name != name {
+		err := r.deleteIdentity(ctx, onyxiaWorkspace, s3Client, *recorded)
+		if err != nil {
+			return err
+		}
+		recorded = nil
+	} else if recorded != nil && recorded.SecretName != secretName {
+		err := r.deleteSecret(ctx, onyxiaWorkspace, recorded.SecretName)
+		if err != nil {
+			return err
+		}
+	}
+	secret := &v1.Secret{}
+	err := r.Get(ctx, client.ObjectKey{Namespace: onyxiaWorkspace.Spec.Namespace, Name: secretName}, secret)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	secretFound := err == nil
+	found, err := s3Client.IdentityExists(name)
+	if err != nil {
+		return fmt.Errorf("can't check s3 identity %s: %w", name, err)
+	}
+	if found && recorded == nil {
+		return fmt.Errorf("s3 identity %s already exists and was not created for the workspace", name)
+	}
+	rotation := onyxiaWorkspace.Annotations[rotateS3CredentialsAnnotation]
+	var credentials factory.S3Credentials
+	switch {
+	case !found:
+		credentials, err = s3Client.CreateIdentity(name, bucketname, prefix)
+		if err != nil {
+			return fmt.Errorf("can't create s3 identity %s: %w", name, err)
+		}
+	case recorded != nil && (recorded.Bucket != bucketname || recorded.Prefix != prefix):
+		err = s3Client.MoveIdentity(name, recorded.Bucket, recorded.Prefix, bucketname, prefix)
+		if err != nil {
+			return fmt.Errorf("can't move s3 identity %s: %w", name, err)
+		}
+	}
+	onyxiaWorkspace.Status.Identity = &onyxiav1.IdentityStatus{Name: name, Bucket: bucketname, Prefix: prefix, SecretName: secretName}
+	if found {
+		if secretFound && secret.Annotations[rotateS3CredentialsAnnotation] == rotation {
+			return nil
+		}
+		credentials, err = s3Client.RotateIdentity(name)
+		if err != nil {
+			return fmt.Errorf("can't rotate s3 identity %s: %w", name, err)
+		}
+	}
+	log.FromContext(ctx).Info("writing s3 credentials", "identity", name, "namespace", onyxiaWorkspace.Spec.Namespace)
+	secret = &v1.Secret{ObjectMeta: metav1.ObjectMeta{
+		Namespace: onyxiaWorkspace.Spec.Namespace,
+		Name:      secretName,
+	}}
+	_, err = controllerutil.CreateOrUpdate(ctx, r.Client, secret, func() error {
+		if secret.Labels == nil {
+			secret.Labels = map[string]string{}
+		}
+		secret.Labels[managedByLabel] = managedByValue
+		if secret.Annotations == nil {
+			secret.Annotations = map[string]string{}
+		}
+		secret.Annotations[rotateS3CredentialsAnnotation] = rotation
+		// names of the variables expected by the s3 clients of onyxia services
+		secret.StringData = map[string]string{
+			"AWS_ACCESS_KEY_ID":     credentials.AccessKey,
+			"AWS_SECRET_ACCESS_KEY": credentials.SecretKey,
+			"AWS_S3_ENDPOINT":       credentials.Endpoint,
+			"AWS_DEFAULT_REGION":    credentials.Region,
+		}
+		return nil
+	})
+	return err
+}
+
+// deleteIdentity deletes the S3 identity and the secret holding its keys
+func (r *WorkspaceReconciler) deleteIdentity(ctx context.Context, onyxiaWorkspace *onyxiav1.Workspace, s3Client factory.S3Client, identity onyxiav1.IdentityStatus) error {
+	found, err := s3Client.IdentityExists(identity.Name)
+	if err != nil {
+		return fmt.Errorf("can't check s3 identity %s: %w", identity.Name, err)
+	}
+	if found {
+		err = s3Client.DeleteIdentity(identity.Name, identity.Bucket, identity.Prefix)
+		if err != nil {
+			return fmt.Errorf("can't delete s3 identity %s: %w", identity.Name, err)
+		}
+	}
+	return r.deleteSecret(ctx, onyxiaWorkspace, identity.SecretName)
+}
+
+// deleteSecret deletes the secret of the workspace namespace if the operator wrote it
+func (r *WorkspaceReconciler) deleteSecret(ctx context.Context, onyxiaWorkspace *onyxiav1.Workspace, name string) error {
+	secret := &v1.Secret{}
+	err := r.Get(ctx, client.ObjectKey{Namespace: onyxiaWorkspace.Spec.Namespace, Name: name}, secret)
+	if err != nil || secret.Labels[managedByLabel] != managedByValue {
+		return client.IgnoreNotFound(err)
+	}
+	return client.IgnoreNotFound(r.Delete(ctx, secret))
+}
+
+// finalizeIdentity deletes the workspace S3 identity and its secret, then lets the workspace go.
+func (r *WorkspaceReconciler) finalizeIdentity(ctx context.Context, onyxiaWorkspace *onyxiav1.Workspace) error {
+	if !controllerutil.ContainsFinalizer(onyxiaWorkspace, s3IdentityFinalizer) {
+		return nil
+	}
+	// only the identity recorded in the status was created by the operator
+	identity := onyxiaWorkspace.Status.Identity
+	if identity != nil {
+		s3Client, err := r.S3Clients.Get(ctx, onyxiaWorkspace.Spec.Bucket.BackendRef)
+		if err != nil {
+			return err
+		}
+		err = r.deleteIdentity(ctx, onyxiaWorkspace, s3Client, *identity)
+		if err != nil {
+			return err
+		}
+	}
+	controllerutil.RemoveFinalizer(onyxiaWorkspace, s3IdentityFinalizer)
+	return r.Update(ctx, onyxiaWorkspace)
+}
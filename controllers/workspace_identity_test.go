@@ -0,0 +1,189 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	onyxiav1 "github.com/inseefrlab/onyxia-onboarding-operator/api/v1"
+	"github.com/inseefrlab/onyxia-onboarding-operator/controllers/s3/factory"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+var _ = Describe("handleIdentity", func() {
+	var k8sClient client.Client
+	var reconciler *WorkspaceReconciler
+	var s3Client *factory.MockedS3Client
+	var workspace *onyxiav1.Workspace
+
+	BeforeEach(func() {
+		k8sClient = newFakeClient()
+		reconciler = &WorkspaceReconciler{Client: k8sClient, Scheme: scheme.Scheme}
+		s3Client = newMockedS3Client()
+		workspace = newWorkspace("titi")
+		workspace.Spec.Bucket.Credentials = &onyxiav1.BucketCredentials{}
+		workspace.Status.Bucket.Name = "bucket-titi"
+	})
+
+	secret := func(name string) (*v1.Secret, error) {
+		secret := &v1.Secret{}
+		err := k8sClient.Get(context.Background(), client.ObjectKey{Namespace: "titi", Name: name}, secret)
+		return secret, err
+	}
+
+	It("creates the identity, writes its keys and records it", func() {
+		Expect(reconciler.handleIdentity(context.Background(), workspace, s3Client)).To(Succeed())
+
+		found, err := s3Client.IdentityExists("onyxia-bucket-titi")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(found).To(BeTrue())
+		Expect(s3Client.IdentityLocation("onyxia-bucket-titi")).To(Equal("bucket-titi/"))
+		keys, err := secret(defaultS3CredentialsSecretName)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(keys.Labels).To(HaveKeyWithValue(managedByLabel, managedByValue))
+		Expect(workspace.Status.Identity).To(Equal(&onyxiav1.IdentityStatus{
+			Name: "onyxia-bucket-titi", Bucket: "bucket-titi", SecretName: defaultS3CredentialsSecretName,
+		}))
+	})
+
+	It("refuses an identity of the same name it didn't create", func() {
+		_, err := s3Client.CreateIdentity("onyxia-bucket-titi", "bucket-toto", "")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(reconciler.handleIdentity(context.Background(), workspace, s3Client)).To(MatchError(ContainSubstring("already exists")))
+
+		Expect(s3Client.IdentityLocation("onyxia-bucket-titi")).To(Equal("bucket-toto/"))
+		_, err = secret(defaultS3CredentialsSecretName)
+		Expect(apierrors.IsNotFound(err)).To(BeTrue())
+		Expect(workspace.Status.Identity).To(BeNil())
+	})
+
+	It("deletes the identity and its keys when the credentials are removed", func() {
+		Expect(reconciler.handleIdentity(context.Background(), workspace, s3Client)).To(Succeed())
+		workspace.Spec.Bucket.Credentials = nil
+		Expect(reconciler.handleIdentity(context.Background(), workspace, s3Client)).To(Succeed())
+
+		found, err := s3Client.IdentityExists("onyxia-bucket-titi")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(found).To(BeFalse())
+		_, err = secret(defaultS3CredentialsSecretName)
+		Expect(apierrors.IsNotFound(err)).To(BeTrue())
+		Expect(workspace.Status.Identity).To(BeNil())
+	})
+
+	It("allows the identity on the new location of the bucket and keeps its keys", func() {
+		Expect(reconciler.handleIdentity(context.Background(), workspace, s3Client)).To(Succeed())
+		before, err := secret(defaultS3CredentialsSecretName)
+		Expect(err).NotTo(HaveOccurred())
+
+		workspace.Status.Bucket.Name = "bucket-shared"
+		workspace.Status.Bucket.Prefix = "titi/"
+		Expect(reconciler.handleIdentity(context.Background(), workspace, s3Client)).To(Succeed())
+
+		Expect(s3Client.IdentityLocation("onyxia-bucket-titi")).To(Equal("bucket-shared/titi/"))
+		Expect(workspace.Status.Identity.Bucket).To(Equal("bucket-shared"))
+		Expect(workspace.Status.Identity.Prefix).To(Equal("titi/"))
+		after, err := secret(defaultS3CredentialsSecretName)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(after.ResourceVersion).To(Equal(before.ResourceVersion))
+	})
+
+	It("moves the keys to the new secret and deletes the previous one", func() {
+		Expect(reconciler.handleIdentity(context.Background(), workspace, s3Client)).To(Succeed())
+		workspace.Spec.Bucket.Credentials.SecretName = "titi-s3"
+		Expect(reconciler.handleIdentity(context.Background(), workspace, s3Client)).To(Succeed())
+
+		_, err := secret(defaultS3CredentialsSecretName)
+		Expect(apierrors.IsNotFound(err)).To(BeTrue())
+		_, err = secret("titi-s3")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(workspace.Status.Identity.SecretName).To(Equal("titi-s3"))
+	})
+
+	It("replaces the identity of a renamed bucket", func() {
+		Expect(reconciler.handleIdentity(context.Background(), workspace, s3Client)).To(Succeed())
+		workspace.Spec.Bucket.Name = "bucket-titi-v2"
+		workspace.Status.Bucket.Name = "bucket-titi-v2"
+		Expect(reconciler.handleIdentity(context.Background(), workspace, s3Client)).To(Succeed())
+
+		found, err := s3Client.IdentityExists("onyxia-bucket-titi")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(found).To(BeFalse())
+		found, err = s3Client.IdentityExists("onyxia-bucket-titi-v2")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(found).To(BeTrue())
+		_, err = secret(defaultS3CredentialsSecretName)
+		Expect(err).NotTo(HaveOccurred())
+	})
+})
+
+var _ = Describe("s3 identity finalizer", func() {
+	var k8sClient client.Client
+	var reconciler *WorkspaceReconciler
+	var workspace *onyxiav1.Workspace
+
+	BeforeEach(func() {
+		workspace = newWorkspace("titi")
+		workspace.Spec.Bucket.Credentials = &onyxiav1.BucketCredentials{}
+		k8sClient = newFakeClient(workspace)
+		pool := NewS3ClientPool(k8sClient, &factory.S3Config{S3Provider: "mockedS3Provider"}, nil)
+		reconciler = &WorkspaceReconciler{Client: k8sClient, Scheme: scheme.Scheme, S3Clients: pool}
+	})
+
+	reconcile := func() *onyxiav1.Workspace {
+		_, err := reconciler.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKeyFromObject(workspace)})
+		Expect(err).NotTo(HaveOccurred())
+		reconciled := &onyxiav1.Workspace{}
+		Expect(k8sClient.Get(context.Background(), client.ObjectKeyFromObject(workspace), reconciled)).To(Succeed())
+		return reconciled
+	}
+
+	It("is kept until the identity of removed credentials is deleted", func() {
+		reconciled := reconcile()
+		Expect(controllerutil.ContainsFinalizer(reconciled, s3IdentityFinalizer)).To(BeTrue())
+		Expect(reconciled.Status.Identity).NotTo(BeNil())
+
+		reconciled.Spec.Bucket.Credentials = nil
+		Expect(k8sClient.Update(context.Background(), reconciled)).To(Succeed())
+		reconciled = reconcile()
+		Expect(controllerutil.ContainsFinalizer(reconciled, s3IdentityFinalizer)).To(BeTrue())
+		Expect(reconciled.Status.Identity).To(BeNil())
+
+		reconciled = reconcile()
+		Expect(controllerutil.ContainsFinalizer(reconciled, s3IdentityFinalizer)).To(BeFalse())
+	})
+
+	It("leaves the identities it didn't record when the workspace is deleted", func() {
+		s3Client, err := reconciler.S3Clients.Get(context.Background(), "")
+		Expect(err).NotTo(HaveOccurred())
+		_, err = s3Client.CreateIdentity("onyxia-bucket-titi", "bucket-toto", "")
+		Expect(err).NotTo(HaveOccurred())
+		controllerutil.AddFinalizer(workspace, s3IdentityFinalizer)
+		Expect(reconciler.finalizeIdentity(context.Background(), workspace)).To(Succeed())
+
+		found, err := s3Client.IdentityExists("onyxia-bucket-titi")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(found).To(BeTrue())
+	})
+})
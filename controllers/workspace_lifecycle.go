@@ -0,0 +1,103 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+
+	onyxiav1 "github.com/inseefrlab/onyxia-onboarding-operator/api/v1"
+	"github.com/inseefrlab/onyxia-onboarding-operator/controllers/s3/factory"
+)
+
+// lifecycleRules merges the default rules of the operator with the rules of the workspace
+func lifecycleRules(defaults []onyxiav1.LifecycleRule, rules []onyxiav1.LifecycleRule) ([]factory.LifecycleRule, error) {
+	merged := []onyxiav1.LifecycleRule{}
+	index := map[string]int{}
+	for _, rule := range append(append([]onyxiav1.LifecycleRule{}, defaults...), rules...) {
+		if rule.ExpirationDays == 0 && rule.NoncurrentVersionExpirationDays == 0 && rule.AbortIncompleteMultipartUploadDays == 0 {
+			return nil, fmt.Errorf("lifecycle rule %s has no action", rule.ID)
+		}
+		if i, found := index[rule.ID]; found {
+			merged[i] = rule
+			continue
+		}
+		index[rule.ID] = len(merged)
+		merged = append(merged, rule)
+	}
+	lifecycleRules := []factory.LifecycleRule{}
+	for _, rule := range merged {
+		lifecycleRules = append(lifecycleRules, factory.LifecycleRule{
+			ID:                                 rule.ID,
+			Prefix:                             rule.Prefix,
+			ExpirationDays:                     int(rule.ExpirationDays),
+			NoncurrentVersionExpirationDays:    int(rule.NoncurrentVersionExpirationDays),
+			AbortIncompleteMultipartUploadDays: int(rule.AbortIncompleteMultipartUploadDays),
+		})
+	}
+	return lifecycleRules, nil
+}
+
+// handleLifecycle applies the lifecycle rules when the bucket rules differ. The ids of
+// the applied rules are recorded in the status, so that the rules leaving the spec or
+// the defaults are removed. Rules set on the bucket by others are kept.
+func (r *WorkspaceReconciler) handleLifecycle(onyxiaWorkspace *onyxiav1.Workspace, s3Client factory.S3Client) error {
+	bucketname, prefix := bucketLocation(onyxiaWorkspace)
+	if prefix != "" {
+		// the lifecycle of a shared bucket is left to the administrators
+		return nil
+	}
+	rules, err := lifecycleRules(r.Config.DefaultLifecycleRules, onyxiaWorkspace.Spec.Bucket.Lifecycle)
+	if err != nil {
+		return err
+	}
+	if len(rules) == 0 && len(onyxiaWorkspace.Status.Bucket.LifecycleRules) == 0 {
+		return nil
+	}
+	current, err := s3Client.GetLifecycle(bucketname)
+	if err != nil {
+		return fmt.Errorf("can't get lifecycle of bucket %s: %w", bucketname, err)
+	}
+	managed := map[string]bool{}
+	for _, id := range onyxiaWorkspace.Status.Bucket.LifecycleRules {
+		managed[id] = true
+	}
+	ids := []string{}
+	for _, rule := range rules {
+		managed[rule.ID] = true
+		ids = append(ids, rule.ID)
+	}
+	desired := append([]factory.LifecycleRule{}, rules...)
+	for _, rule := range current {
+		if !managed[rule.ID] {
+			desired = append(desired, rule)
+		}
+	}
+	if !reflect.DeepEqual(factory.SortLifecycleRules(current), factory.SortLifecycleRules(desired)) {
+		if len(desired) == 0 {
+			desired = nil
+		}
+		err = s3Client.SetLifecycle(bucketname, desired)
+		if err != nil {
+			return fmt.Errorf("can't set lifecycle of bucket %s: %w", bucketname, err)
+		}
+	}
+	sort.Strings(ids)
+	onyxiaWorkspace.Status.Bucket.LifecycleRules = ids
+	return nil
+}
@@ -0,0 +1,79 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	onyxiav1 "github.com/inseefrlab/onyxia-onboarding-operator/api/v1"
+	"github.com/inseefrlab/onyxia-onboarding-operator/controllers/s3/factory"
+)
+
+var _ = Describe("handleLifecycle", func() {
+	var reconciler *WorkspaceReconciler
+	var s3Client *factory.MockedS3Client
+	var workspace *onyxiav1.Workspace
+
+	BeforeEach(func() {
+		reconciler = &WorkspaceReconciler{Config: OperatorConfig{DefaultLifecycleRules: []onyxiav1.LifecycleRule{
+			{ID: "abort-uploads", AbortIncompleteMultipartUploadDays: 7},
+		}}}
+		s3Client = newMockedS3Client()
+		workspace = newWorkspace("titi")
+		workspace.Status.Bucket.Name = "bucket-titi"
+		workspace.Spec.Bucket.Lifecycle = []onyxiav1.LifecycleRule{{ID: "tmp", Prefix: "tmp/", ExpirationDays: 30}}
+		Expect(s3Client.CreateBucket("bucket-titi")).To(Succeed())
+	})
+
+	ids := func() []string {
+		rules, err := s3Client.GetLifecycle("bucket-titi")
+		Expect(err).NotTo(HaveOccurred())
+		ids := []string{}
+		for _, rule := range rules {
+			ids = append(ids, rule.ID)
+		}
+		return ids
+	}
+
+	It("applies the default and workspace rules and records them", func() {
+		Expect(reconciler.handleLifecycle(workspace, s3Client)).To(Succeed())
+		Expect(ids()).To(ConsistOf("abort-uploads", "tmp"))
+		Expect(workspace.Status.Bucket.LifecycleRules).To(Equal([]string{"abort-uploads", "tmp"}))
+	})
+
+	It("removes the rules leaving the spec and keeps the rules set by others", func() {
+		Expect(s3Client.SetLifecycle("bucket-titi", []factory.LifecycleRule{{ID: "archive", ExpirationDays: 365}})).To(Succeed())
+		Expect(reconciler.handleLifecycle(workspace, s3Client)).To(Succeed())
+		Expect(ids()).To(ConsistOf("abort-uploads", "archive", "tmp"))
+
+		workspace.Spec.Bucket.Lifecycle = nil
+		Expect(reconciler.handleLifecycle(workspace, s3Client)).To(Succeed())
+		Expect(ids()).To(ConsistOf("abort-uploads", "archive"))
+		Expect(workspace.Status.Bucket.LifecycleRules).To(Equal([]string{"abort-uploads"}))
+	})
+
+	It("removes the lifecycle when no managed rule is left", func() {
+		Expect(reconciler.handleLifecycle(workspace, s3Client)).To(Succeed())
+		reconciler.Config.DefaultLifecycleRules = nil
+		workspace.Spec.Bucket.Lifecycle = nil
+		Expect(reconciler.handleLifecycle(workspace, s3Client)).To(Succeed())
+
+		Expect(ids()).To(BeEmpty())
+		Expect(workspace.Status.Bucket.LifecycleRules).To(BeEmpty())
+	})
+})
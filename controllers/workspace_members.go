@@ -0,0 +1,138 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	onyxiav1 "github.com/inseefrlab/onyxia-onboarding-operator/api/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// memberRoles are the roles of the workspace members, each bound by the RoleBinding
+// named after it with the onyxia- prefix, e.g. onyxia-admin
+var memberRoles = []string{"admin", "editor", "viewer"}
+
+const memberRoleBindingPrefix = "onyxia-"
+
+// memberSubjects returns the RBAC subjects of each role, owners being admins
+func memberSubjects(onyxiaWorkspace *onyxiav1.Workspace) (map[string][]rbacv1.Subject, error) {
+	members := []onyxiav1.Member{}
+	for _, owner := range onyxiaWorkspace.Spec.Owners {
+		members = append(members, onyxiav1.Member{Subject: owner, Role: "admin"})
+	}
+	members = append(members, onyxiaWorkspace.Spec.Members...)
+	subjects := map[string][]rbacv1.Subject{}
+	for _, member := range members {
+		role := member.Role
+		if role == "" {
+			role = "viewer"
+		}
+		if !containsString(memberRoles, role) {
+			return nil, fmt.Errorf("role %s of member %s is not one of %v", role, member.Name, memberRoles)
+		}
+		subject := rbacv1.Subject{Kind: member.Kind, Name: member.Name}
+		switch member.Kind {
+		case rbacv1.UserKind, rbacv1.GroupKind:
+			subject.APIGroup = rbacv1.GroupName
+		case rbacv1.ServiceAccountKind:
+			subject.Namespace = member.Namespace
+			if subject.Namespace == "" {
+				subject.Namespace = onyxiaWorkspace.Spec.Namespace
+			}
+		default:
+			return nil, fmt.Errorf("member %s has an unknown kind %s", member.Name, member.Kind)
+		}
+		// a subject listed under several roles gets all of them
+		if !containsSubject(subjects[role], subject) {
+			subjects[role] = append(subjects[role], subject)
+		}
+	}
+	return subjects, nil
+}
+
+func containsSubject(subjects []rbacv1.Subject, subject rbacv1.Subject) bool {
+	for _, s := range subjects {
+		if s == subject {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// handleMembers maintains a RoleBinding per role of the workspace members in its
+// namespace, bound to the ClusterRole of the role. The RoleBinding of a role
+// without members is deleted, so that removed members lose their access.
+func (r *WorkspaceReconciler) handleMembers(ctx context.Context, onyxiaWorkspace *onyxiav1.Workspace) error {
+	subjects, err := memberSubjects(onyxiaWorkspace)
+	if err != nil {
+		return err
+	}
+	for _, role := range memberRoles {
+		roleBinding := &rbacv1.RoleBinding{}
+		key := client.ObjectKey{Namespace: onyxiaWorkspace.Spec.Namespace, Name: memberRoleBindingPrefix + role}
+		err = r.Get(ctx, key, roleBinding)
+		if err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+		found := err == nil
+		if found && roleBinding.Labels[managedByLabel] != managedByValue {
+			return fmt.Errorf("rolebinding %s of namespace %s is not managed by the operator", key.Name, key.Namespace)
+		}
+		roleRef := rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: r.Config.memberClusterRole(role)}
+		// the role of a RoleBinding can't be changed, it is replaced
+		if found && (len(subjects[role]) == 0 || roleBinding.RoleRef != roleRef) {
+			log.FromContext(ctx).Info("deleting rolebinding", "namespace", key.Namespace, "name", key.Name)
+			err = client.IgnoreNotFound(r.Delete(ctx, roleBinding))
+			if err != nil {
+				return err
+			}
+		}
+		if len(subjects[role]) == 0 {
+			continue
+		}
+		roleBinding = &rbacv1.RoleBinding{ObjectMeta: metav1.ObjectMeta{Namespace: key.Namespace, Name: key.Name}}
+		_, err = controllerutil.CreateOrUpdate(ctx, r.Client, roleBinding, func() error {
+			if roleBinding.Labels == nil {
+				roleBinding.Labels = map[string]string{}
+			}
+			roleBinding.Labels[managedByLabel] = managedByValue
+			roleBinding.RoleRef = roleRef
+			roleBinding.Subjects = subjects[role]
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("can't update rolebinding %s of namespace %s: %w", key.Name, key.Namespace, err)
+		}
+	}
+	return nil
+}
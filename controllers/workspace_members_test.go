@@ -0,0 +1,107 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	onyxiav1 "github.com/inseefrlab/onyxia-onboarding-operator/api/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var _ = Describe("handleMembers", func() {
+	var k8sClient client.Client
+	var reconciler *WorkspaceReconciler
+	var workspace *onyxiav1.Workspace
+
+	BeforeEach(func() {
+		k8sClient = newFakeClient()
+		reconciler = &WorkspaceReconciler{Client: k8sClient, Scheme: scheme.Scheme}
+		workspace = newWorkspace("titi")
+		workspace.Spec.Owners = []onyxiav1.Subject{{Kind: "User", Name: "oidc:titi"}}
+		workspace.Spec.Members = []onyxiav1.Member{
+			{Subject: onyxiav1.Subject{Kind: "Group", Name: "oidc:datalab-team"}, Role: "editor"},
+			{Subject: onyxiav1.Subject{Kind: "ServiceAccount", Name: "ci"}},
+		}
+	})
+
+	roleBinding := func(role string) (*rbacv1.RoleBinding, error) {
+		roleBinding := &rbacv1.RoleBinding{}
+		err := k8sClient.Get(context.Background(), client.ObjectKey{Namespace: "titi", Name: memberRoleBindingPrefix + role}, roleBinding)
+		return roleBinding, err
+	}
+
+	It("binds the owners and the members to the ClusterRole of their role", func() {
+		Expect(reconciler.handleMembers(context.Background(), workspace)).To(Succeed())
+
+		admin, err := roleBinding("admin")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(admin.RoleRef).To(Equal(rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: "admin"}))
+		Expect(admin.Subjects).To(Equal([]rbacv1.Subject{{Kind: "User", APIGroup: rbacv1.GroupName, Name: "oidc:titi"}}))
+		Expect(admin.Labels).To(HaveKeyWithValue(managedByLabel, managedByValue))
+
+		editor, err := roleBinding("editor")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(editor.RoleRef.Name).To(Equal("edit"))
+		Expect(editor.Subjects).To(Equal([]rbacv1.Subject{{Kind: "Group", APIGroup: rbacv1.GroupName, Name: "oidc:datalab-team"}}))
+
+		viewer, err := roleBinding("viewer")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(viewer.Subjects).To(Equal([]rbacv1.Subject{{Kind: "ServiceAccount", Name: "ci", Namespace: "titi"}}))
+	})
+
+	It("deletes the RoleBinding of a role without members", func() {
+		Expect(reconciler.handleMembers(context.Background(), workspace)).To(Succeed())
+		workspace.Spec.Members = workspace.Spec.Members[:1]
+		Expect(reconciler.handleMembers(context.Background(), workspace)).To(Succeed())
+
+		_, err := roleBinding("viewer")
+		Expect(apierrors.IsNotFound(err)).To(BeTrue())
+		_, err = roleBinding("editor")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("replaces the RoleBinding when the ClusterRole of the role changes", func() {
+		Expect(reconciler.handleMembers(context.Background(), workspace)).To(Succeed())
+		reconciler.Config.MemberClusterRoles = map[string]string{"editor": "onyxia-editor"}
+		Expect(reconciler.handleMembers(context.Background(), workspace)).To(Succeed())
+
+		editor, err := roleBinding("editor")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(editor.RoleRef.Name).To(Equal("onyxia-editor"))
+	})
+
+	It("refuses RoleBindings the operator doesn't manage", func() {
+		Expect(k8sClient.Create(context.Background(), &rbacv1.RoleBinding{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "titi", Name: "onyxia-admin"},
+			RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: "cluster-admin"},
+		})).To(Succeed())
+		Expect(reconciler.handleMembers(context.Background(), workspace)).To(MatchError(ContainSubstring("not managed by the operator")))
+	})
+
+	It("rejects unknown roles", func() {
+		workspace.Spec.Members[0].Role = "owner"
+		Expect(reconciler.handleMembers(context.Background(), workspace)).To(MatchError(ContainSubstring("role owner")))
+	})
+})
@@ -0,0 +1,97 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	onyxiav1 "github.com/inseefrlab/onyxia-onboarding-operator/api/v1"
+	v1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// applyManagedKeys sets the wanted keys in values and removes the previously managed
+// keys that are no longer wanted. It returns the sorted managed keys and whether
+// values changed.
+func applyManagedKeys(values map[string]string, wanted map[string]string, managed []string) ([]string, bool) {
+	changed := false
+	for _, key := range managed {
+		if _, found := wanted[key]; found {
+			continue
+		}
+		if _, found := values[key]; found {
+			delete(values, key)
+			changed = true
+		}
+	}
+	keys := []string{}
+	for key, value := range wanted {
+		if current, found := values[key]; !found || current != value {
+			values[key] = value
+			changed = true
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys, changed
+}
+
+// handleNamespaceMetadata sets the labels and annotations of namespaceMetadata on the
+// workspace namespace and records their keys in the status, so that the keys later
+// removed from the workspace are removed from the namespace. Keys set by other tools
+// are left untouched.
+func (r *WorkspaceReconciler) handleNamespaceMetadata(ctx context.Context, onyxiaWorkspace *onyxiav1.Workspace) error {
+	metadata := onyxiaWorkspace.Spec.NamespaceMetadata
+	for key := range metadata.Labels {
+		if strings.HasPrefix(key, podSecurityLabelPrefix) || key == managedByLabel {
+			return fmt.Errorf("namespace label %s is set by the operator, it can't be in namespaceMetadata", key)
+		}
+	}
+	namespace := &v1.Namespace{}
+	err := r.Get(ctx, client.ObjectKey{Name: onyxiaWorkspace.Spec.Namespace}, namespace)
+	if err != nil {
+		return err
+	}
+	patch := client.MergeFrom(namespace.DeepCopy())
+	if namespace.Labels == nil {
+		namespace.Labels = map[string]string{}
+	}
+	if namespace.Annotations == nil {
+		namespace.Annotations = map[string]string{}
+	}
+	status := &onyxiaWorkspace.Status.Namespace
+	labels, labelsChanged := applyManagedKeys(namespace.Labels, metadata.Labels, status.ManagedLabels)
+	annotations, annotationsChanged := applyManagedKeys(namespace.Annotations, metadata.Annotations, status.ManagedAnnotations)
+	if labelsChanged || annotationsChanged {
+		err = r.Patch(ctx, namespace, patch)
+		if err != nil {
+			return fmt.Errorf("can't set metadata of namespace %s: %w", namespace.Name, err)
+		}
+	}
+	// nil rather than empty, as read back from the api server
+	status.ManagedLabels, status.ManagedAnnotations = nil, nil
+	if len(labels) > 0 {
+		status.ManagedLabels = labels
+	}
+	if len(annotations) > 0 {
+		status.ManagedAnnotations = annotations
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	onyxiav1 "github.com/inseefrlab/onyxia-onboarding-operator/api/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var _ = Describe("handleNamespaceMetadata", func() {
+	var k8sClient client.Client
+	var reconciler *WorkspaceReconciler
+	var workspace *onyxiav1.Workspace
+
+	BeforeEach(func() {
+		k8sClient = newFakeClient(&v1.Namespace{ObjectMeta: metav1.ObjectMeta{
+			Name:        "titi",
+			Labels:      map[string]string{"team": "datalab"},
+			Annotations: map[string]string{"backup": "daily"},
+		}})
+		reconciler = &WorkspaceReconciler{Client: k8sClient, Scheme: scheme.Scheme}
+		workspace = newWorkspace("titi")
+		workspace.Spec.NamespaceMetadata = onyxiav1.NamespaceMetadata{
+			Labels:      map[string]string{"istio-injection": "enabled", "cost-centre": "42"},
+			Annotations: map[string]string{"scheduler.alpha.kubernetes.io/node-selector": "pool=datalab"},
+		}
+	})
+
+	namespace := func() *v1.Namespace {
+		namespace := &v1.Namespace{}
+		Expect(k8sClient.Get(context.Background(), client.ObjectKey{Name: "titi"}, namespace)).To(Succeed())
+		return namespace
+	}
+
+	It("sets the labels and annotations and records their keys", func() {
+		Expect(reconciler.handleNamespaceMetadata(context.Background(), workspace)).To(Succeed())
+
+		Expect(namespace().Labels).To(Equal(map[string]string{"team": "datalab", "istio-injection": "enabled", "cost-centre": "42"}))
+		Expect(namespace().Annotations).To(Equal(map[string]string{"backup": "daily", "scheduler.alpha.kubernetes.io/node-selector": "pool=datalab"}))
+		Expect(workspace.Status.Namespace.ManagedLabels).To(Equal([]string{"cost-centre", "istio-injection"}))
+		Expect(workspace.Status.Namespace.ManagedAnnotations).To(Equal([]string{"scheduler.alpha.kubernetes.io/node-selector"}))
+	})
+
+	It("removes the keys dropped from the workspace and keeps the keys of other tools", func() {
+		Expect(reconciler.handleNamespaceMetadata(context.Background(), workspace)).To(Succeed())
+		workspace.Spec.NamespaceMetadata = onyxiav1.NamespaceMetadata{Labels: map[string]string{"cost-centre": "43"}}
+		Expect(reconciler.handleNamespaceMetadata(context.Background(), workspace)).To(Succeed())
+
+		Expect(namespace().Labels).To(Equal(map[string]string{"team": "datalab", "cost-centre": "43"}))
+		Expect(namespace().Annotations).To(Equal(map[string]string{"backup": "daily"}))
+		Expect(workspace.Status.Namespace.ManagedLabels).To(Equal([]string{"cost-centre"}))
+		Expect(workspace.Status.Namespace.ManagedAnnotations).To(BeNil())
+	})
+
+	It("rejects the labels set by the operator", func() {
+		workspace.Spec.NamespaceMetadata.Labels[podSecurityLabelPrefix+"enforce"] = "privileged"
+		Expect(reconciler.handleNamespaceMetadata(context.Background(), workspace)).To(MatchError(ContainSubstring("set by the operator")))
+	})
+})
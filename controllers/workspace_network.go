@@ -0,0 +1,157 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	onyxiav1 "github.com/inseefrlab/onyxia-onboarding-operator/api/v1"
+	v1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// allowExtraNetworkPolicyName is the NetworkPolicy allowing the extra traffic of the workspace
+const allowExtraNetworkPolicyName = "onyxia-allow-extra"
+
+// networkPeers returns the peers and ports of an allow rule of the workspace
+func networkPeers(rule onyxiav1.NetworkAllowRule) ([]networkingv1.NetworkPolicyPeer, []networkingv1.NetworkPolicyPort, error) {
+	peers := []networkingv1.NetworkPolicyPeer{}
+	for _, namespace := range rule.Namespaces {
+		peers = append(peers, networkingv1.NetworkPolicyPeer{NamespaceSelector: &metav1.LabelSelector{
+			MatchLabels: map[string]string{v1.LabelMetadataName: namespace},
+		}})
+	}
+	if len(rule.NamespaceLabels) > 0 {
+		peers = append(peers, networkingv1.NetworkPolicyPeer{NamespaceSelector: &metav1.LabelSelector{MatchLabels: rule.NamespaceLabels}})
+	}
+	for _, cidr := range rule.CIDRs {
+		peers = append(peers, networkingv1.NetworkPolicyPeer{IPBlock: &networkingv1.IPBlock{CIDR: cidr}})
+	}
+	if len(peers) == 0 {
+		return nil, nil, fmt.Errorf("network allow rule needs namespaces or cidrs")
+	}
+	// nil rather than empty, as read back from the api server
+	var ports []networkingv1.NetworkPolicyPort
+	for _, port := range rule.Ports {
+		protocol := v1.ProtocolTCP
+		portNumber := intstr.FromInt(int(port))
+		ports = append(ports, networkingv1.NetworkPolicyPort{Protocol: &protocol, Port: &portNumber})
+	}
+	return peers, ports, nil
+}
+
+// networkPolicies returns the NetworkPolicies of the workspace namespace by name: the
+// templates turned on and the policy allowing the extra traffic of the workspace, if any
+func (r *WorkspaceReconciler) networkPolicies(onyxiaWorkspace *onyxiav1.Workspace) (map[string]networkingv1.NetworkPolicySpec, error) {
+	toggles := onyxiaWorkspace.Spec.NetworkPolicies.Toggles
+	for name := range toggles {
+		if _, found := r.networkPolicyTemplate(name); !found {
+			return nil, fmt.Errorf("network policy %s is not a template of the operator configuration", name)
+		}
+	}
+	policies := map[string]networkingv1.NetworkPolicySpec{}
+	for _, template := range r.Config.NetworkPolicies {
+		enabled, toggled := toggles[template.Name]
+		if (toggled && enabled) || (!toggled && !template.Optional) {
+			policies[template.Name] = *template.Spec.DeepCopy()
+		}
+	}
+
+	extra := networkingv1.NetworkPolicySpec{}
+	for _, rule := range onyxiaWorkspace.Spec.NetworkPolicies.AllowIngress {
+		peers, ports, err := networkPeers(rule)
+		if err != nil {
+			return nil, err
+		}
+		extra.Ingress = append(extra.Ingress, networkingv1.NetworkPolicyIngressRule{From: peers, Ports: ports})
+	}
+	for _, rule := range onyxiaWorkspace.Spec.NetworkPolicies.AllowEgress {
+		peers, ports, err := networkPeers(rule)
+		if err != nil {
+			return nil, err
+		}
+		extra.Egress = append(extra.Egress, networkingv1.NetworkPolicyEgressRule{To: peers, Ports: ports})
+	}
+	if len(extra.Ingress) > 0 {
+		extra.PolicyTypes = append(extra.PolicyTypes, networkingv1.PolicyTypeIngress)
+	}
+	if len(extra.Egress) > 0 {
+		extra.PolicyTypes = append(extra.PolicyTypes, networkingv1.PolicyTypeEgress)
+	}
+	if len(extra.PolicyTypes) > 0 {
+		policies[allowExtraNetworkPolicyName] = extra
+	}
+	return policies, nil
+}
+
+func (r *WorkspaceReconciler) networkPolicyTemplate(name string) (NetworkPolicyTemplate, bool) {
+	for _, template := range r.Config.NetworkPolicies {
+		if template.Name == name {
+			return template, true
+		}
+	}
+	return NetworkPolicyTemplate{}, false
+}
+
+// handleNetworkPolicies installs the NetworkPolicies of the workspace in its namespace
+// and deletes the ones the operator installed that are no longer wanted.
+func (r *WorkspaceReconciler) handleNetworkPolicies(ctx context.Context, onyxiaWorkspace *onyxiav1.Workspace) error {
+	policies, err := r.networkPolicies(onyxiaWorkspace)
+	if err != nil {
+		return err
+	}
+	namespace := onyxiaWorkspace.Spec.Namespace
+	for name, spec := range policies {
+		spec := spec
+		networkPolicy := &networkingv1.NetworkPolicy{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name}}
+		_, err = controllerutil.CreateOrUpdate(ctx, r.Client, networkPolicy, func() error {
+			if networkPolicy.Labels == nil {
+				networkPolicy.Labels = map[string]string{}
+			}
+			networkPolicy.Labels[managedByLabel] = managedByValue
+			networkPolicy.Spec = spec
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("can't update network policy %s of namespace %s: %w", name, namespace, err)
+		}
+	}
+
+	installed := &networkingv1.NetworkPolicyList{}
+	err = r.List(ctx, installed, client.InNamespace(namespace), client.MatchingLabels{managedByLabel: managedByValue})
+	if err != nil {
+		return err
+	}
+	for i := range installed.Items {
+		networkPolicy := &installed.Items[i]
+		if _, found := policies[networkPolicy.Name]; found {
+			continue
+		}
+		log.FromContext(ctx).Info("deleting network policy", "namespace", namespace, "name", networkPolicy.Name)
+		err = client.IgnoreNotFound(r.Delete(ctx, networkPolicy))
+		if err != nil {
+			return fmt.Errorf("can't delete network policy %s of namespace %s: %w", networkPolicy.Name, namespace, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	onyxiav1 "github.com/inseefrlab/onyxia-onboarding-operator/api/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var _ = Describe("handleNetworkPolicies", func() {
+	var k8sClient client.Client
+	var reconciler *WorkspaceReconciler
+	var workspace *onyxiav1.Workspace
+
+	BeforeEach(func() {
+		k8sClient = newFakeClient()
+		reconciler = &WorkspaceReconciler{Client: k8sClient, Scheme: scheme.Scheme, Config: OperatorConfig{
+			NetworkPolicies: []NetworkPolicyTemplate{
+				{Name: "onyxia-default-deny-ingress", Spec: networkingv1.NetworkPolicySpec{
+					PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress},
+				}},
+				{Name: "onyxia-restrict-egress", Optional: true, Spec: networkingv1.NetworkPolicySpec{
+					PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeEgress},
+				}},
+			},
+		}}
+		workspace = newWorkspace("titi")
+	})
+
+	installed := func() []string {
+		policies := &networkingv1.NetworkPolicyList{}
+		Expect(k8sClient.List(context.Background(), policies, client.InNamespace("titi"))).To(Succeed())
+		names := []string{}
+		for _, policy := range policies.Items {
+			names = append(names, policy.Name)
+		}
+		return names
+	}
+
+	It("installs the templates that are not optional", func() {
+		Expect(reconciler.handleNetworkPolicies(context.Background(), workspace)).To(Succeed())
+		Expect(installed()).To(ConsistOf("onyxia-default-deny-ingress"))
+	})
+
+	It("turns templates on and off", func() {
+		workspace.Spec.NetworkPolicies.Toggles = map[string]bool{"onyxia-default-deny-ingress": false, "onyxia-restrict-egress": true}
+		Expect(reconciler.handleNetworkPolicies(context.Background(), workspace)).To(Succeed())
+		Expect(installed()).To(ConsistOf("onyxia-restrict-egress"))
+	})
+
+	It("allows the extra traffic of the workspace", func() {
+		workspace.Spec.NetworkPolicies.AllowEgress = []onyxiav1.NetworkAllowRule{{CIDRs: []string{"10.0.0.0/8"}, Ports: []int32{5432}}}
+		Expect(reconciler.handleNetworkPolicies(context.Background(), workspace)).To(Succeed())
+
+		policy := &networkingv1.NetworkPolicy{}
+		Expect(k8sClient.Get(context.Background(), client.ObjectKey{Namespace: "titi", Name: allowExtraNetworkPolicyName}, policy)).To(Succeed())
+		Expect(policy.Spec.PolicyTypes).To(Equal([]networkingv1.PolicyType{networkingv1.PolicyTypeEgress}))
+		Expect(policy.Spec.Egress).To(HaveLen(1))
+		Expect(policy.Spec.Egress[0].To).To(Equal([]networkingv1.NetworkPolicyPeer{{IPBlock: &networkingv1.IPBlock{CIDR: "10.0.0.0/8"}}}))
+		Expect(policy.Spec.Egress[0].Ports[0].Port.IntValue()).To(Equal(5432))
+	})
+
+	It("deletes the policies it installed that are no longer wanted", func() {
+		workspace.Spec.NetworkPolicies.AllowIngress = []onyxiav1.NetworkAllowRule{{Namespaces: []string{"monitoring"}}}
+		Expect(reconciler.handleNetworkPolicies(context.Background(), workspace)).To(Succeed())
+		Expect(k8sClient.Create(context.Background(), &networkingv1.NetworkPolicy{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "titi", Name: "user-policy"},
+		})).To(Succeed())
+
+		workspace.Spec.NetworkPolicies = onyxiav1.NetworkPolicies{Toggles: map[string]bool{"onyxia-default-deny-ingress": false}}
+		Expect(reconciler.handleNetworkPolicies(context.Background(), workspace)).To(Succeed())
+		Expect(installed()).To(ConsistOf("user-policy"))
+	})
+
+	It("rejects unknown templates and rules without peers", func() {
+		workspace.Spec.NetworkPolicies.Toggles = map[string]bool{"onyxia-allow-all": true}
+		Expect(reconciler.handleNetworkPolicies(context.Background(), workspace)).To(MatchError(ContainSubstring("not a template")))
+
+		workspace.Spec.NetworkPolicies = onyxiav1.NetworkPolicies{AllowIngress: []onyxiav1.NetworkAllowRule{{Ports: []int32{80}}}}
+		Expect(reconciler.handleNetworkPolicies(context.Background(), workspace)).To(MatchError(ContainSubstring("needs namespaces or cidrs")))
+	})
+})
@@ -0,0 +1,103 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+
+	onyxiav1 "github.com/inseefrlab/onyxia-onboarding-operator/api/v1"
+	"github.com/inseefrlab/onyxia-onboarding-operator/controllers/s3/factory"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// notifications resolves the targets of the notifications of the workspace, which
+// must be registered in the operator configuration
+func notifications(targets map[string]string, bucketNotifications []onyxiav1.BucketNotification) ([]factory.Notification, error) {
+	notifications := []factory.Notification{}
+	ids := map[string]bool{}
+	for _, n := range bucketNotifications {
+		if ids[n.ID] {
+			return nil, fmt.Errorf("notification %s is declared twice", n.ID)
+		}
+		ids[n.ID] = true
+		arn, found := targets[n.Target]
+		if !found {
+			return nil, fmt.Errorf("notification target %s of notification %s is not registered", n.Target, n.ID)
+		}
+		notifications = append(notifications, factory.Notification{
+			ID:        n.ID,
+			TargetARN: arn,
+			Events:    n.Events,
+			Prefix:    n.Prefix,
+			Suffix:    n.Suffix,
+		})
+	}
+	return notifications, nil
+}
+
+// handleNotifications applies the event notifications when those of the bucket
+// drifted. The ids of the applied notifications are recorded in the status, so that
+// those leaving the spec are removed. Notifications set by others are kept.
+func (r *WorkspaceReconciler) handleNotifications(ctx context.Context, onyxiaWorkspace *onyxiav1.Workspace, s3Client factory.S3Client) error {
+	bucketname, prefix := bucketLocation(onyxiaWorkspace)
+	if prefix != "" {
+		// the notifications of a shared bucket are left to the administrators
+		return nil
+	}
+	workspaceNotifications, err := notifications(r.Config.NotificationTargets, onyxiaWorkspace.Spec.Bucket.Notifications)
+	if err != nil {
+		return err
+	}
+	if len(workspaceNotifications) == 0 && len(onyxiaWorkspace.Status.Bucket.Notifications) == 0 {
+		return nil
+	}
+	current, err := s3Client.GetNotifications(bucketname)
+	if err != nil {
+		return fmt.Errorf("can't get notifications of bucket %s: %w", bucketname, err)
+	}
+	managed := map[string]bool{}
+	for _, id := range onyxiaWorkspace.Status.Bucket.Notifications {
+		managed[id] = true
+	}
+	ids := []string{}
+	for _, n := range workspaceNotifications {
+		managed[n.ID] = true
+		ids = append(ids, n.ID)
+	}
+	desired := append([]factory.Notification{}, workspaceNotifications...)
+	for _, n := range current {
+		if !managed[n.ID] {
+			desired = append(desired, n)
+		}
+	}
+	if !reflect.DeepEqual(factory.SortNotifications(current), factory.SortNotifications(desired)) {
+		log.FromContext(ctx).Info("notifications of bucket drifted, applying them", "bucket", bucketname)
+		if len(desired) == 0 {
+			desired = nil
+		}
+		err = s3Client.SetNotifications(bucketname, desired)
+		if err != nil {
+			return fmt.Errorf("can't set notifications of bucket %s: %w", bucketname, err)
+		}
+	}
+	sort.Strings(ids)
+	onyxiaWorkspace.Status.Bucket.Notifications = ids
+	return nil
+}
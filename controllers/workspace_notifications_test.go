@@ -0,0 +1,86 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	onyxiav1 "github.com/inseefrlab/onyxia-onboarding-operator/api/v1"
+	"github.com/inseefrlab/onyxia-onboarding-operator/controllers/s3/factory"
+)
+
+var _ = Describe("handleNotifications", func() {
+	var reconciler *WorkspaceReconciler
+	var s3Client *factory.MockedS3Client
+	var workspace *onyxiav1.Workspace
+
+	BeforeEach(func() {
+		reconciler = &WorkspaceReconciler{Config: OperatorConfig{NotificationTargets: map[string]string{
+			"pipelines": "arn:minio:sqs::pipelines:webhook",
+		}}}
+		s3Client = newMockedS3Client()
+		workspace = newWorkspace("titi")
+		workspace.Status.Bucket.Name = "bucket-titi"
+		workspace.Spec.Bucket.Notifications = []onyxiav1.BucketNotification{
+			{ID: "csv", Target: "pipelines", Events: []string{"s3:ObjectCreated:*"}, Suffix: ".csv"},
+		}
+		Expect(s3Client.CreateBucket("bucket-titi")).To(Succeed())
+	})
+
+	ids := func() []string {
+		notifications, err := s3Client.GetNotifications("bucket-titi")
+		Expect(err).NotTo(HaveOccurred())
+		ids := []string{}
+		for _, n := range notifications {
+			ids = append(ids, n.ID)
+		}
+		return ids
+	}
+
+	It("applies the notifications of the workspace and records them", func() {
+		Expect(reconciler.handleNotifications(context.Background(), workspace, s3Client)).To(Succeed())
+		Expect(ids()).To(ConsistOf("csv"))
+		Expect(workspace.Status.Bucket.Notifications).To(Equal([]string{"csv"}))
+	})
+
+	It("removes the notifications leaving the spec and keeps those set by others", func() {
+		others := factory.Notification{ID: "audit", TargetARN: "arn:minio:sqs::audit:webhook", Events: []string{"s3:ObjectRemoved:*"}}
+		Expect(s3Client.SetNotifications("bucket-titi", []factory.Notification{others})).To(Succeed())
+		Expect(reconciler.handleNotifications(context.Background(), workspace, s3Client)).To(Succeed())
+		Expect(ids()).To(ConsistOf("audit", "csv"))
+
+		workspace.Spec.Bucket.Notifications = nil
+		Expect(reconciler.handleNotifications(context.Background(), workspace, s3Client)).To(Succeed())
+		Expect(ids()).To(ConsistOf("audit"))
+		Expect(workspace.Status.Bucket.Notifications).To(BeEmpty())
+	})
+
+	It("removes the notifications when none is left", func() {
+		Expect(reconciler.handleNotifications(context.Background(), workspace, s3Client)).To(Succeed())
+		workspace.Spec.Bucket.Notifications = nil
+		Expect(reconciler.handleNotifications(context.Background(), workspace, s3Client)).To(Succeed())
+		Expect(ids()).To(BeEmpty())
+	})
+
+	It("refuses targets missing from the operator configuration", func() {
+		workspace.Spec.Bucket.Notifications[0].Target = "unknown"
+		Expect(reconciler.handleNotifications(context.Background(), workspace, s3Client)).To(MatchError(ContainSubstring("is not registered")))
+	})
+})
@@ -0,0 +1,88 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bytes"
+	"fmt"
+	"text/template"
+
+	onyxiav1 "github.com/inseefrlab/onyxia-onboarding-operator/api/v1"
+	"github.com/inseefrlab/onyxia-onboarding-operator/controllers/s3/factory"
+)
+
+// readmeObjectName is the name of the README object seeded in new paths
+const readmeObjectName = "README.md"
+
+// readmeValues are the values given to the README template of the paths
+type readmeValues struct {
+	Workspace string
+	Namespace string
+	Bucket    string
+	Path      string
+}
+
+// handlePaths creates the folders of the paths of the bucket that don't exist,
+// seeded with the README of the workspace, if any. In a shared bucket, paths
+// are relative to the folder of the workspace.
+func handlePaths(onyxiaWorkspace *onyxiav1.Workspace, s3Client factory.S3Client) error {
+	bucket := onyxiaWorkspace.Spec.Bucket
+	bucketname, prefix := bucketLocation(onyxiaWorkspace)
+	var readme *template.Template
+	if bucket.Readme != "" {
+		var err error
+		readme, err = template.New(readmeObjectName).Option("missingkey=error").Parse(bucket.Readme)
+		if err != nil {
+			return fmt.Errorf("invalid readme template: %w", err)
+		}
+	}
+	for _, path := range bucket.Paths {
+		key, err := factory.FolderKey(prefix + path)
+		if err != nil {
+			return err
+		}
+		found, err := s3Client.PathExists(bucketname, key)
+		if err != nil {
+			return fmt.Errorf("can't check path %s: %w", path, err)
+		}
+		if found {
+			continue
+		}
+		err = s3Client.CreatePath(bucketname, key)
+		if err != nil {
+			return fmt.Errorf("can't create path %s: %w", path, err)
+		}
+		if readme == nil {
+			continue
+		}
+		content := &bytes.Buffer{}
+		err = readme.Execute(content, readmeValues{
+			Workspace: onyxiaWorkspace.Name,
+			Namespace: onyxiaWorkspace.Spec.Namespace,
+			Bucket:    bucketname,
+			Path:      key,
+		})
+		if err != nil {
+			return fmt.Errorf("can't render readme of path %s: %w", path, err)
+		}
+		err = s3Client.PutObject(bucketname, key+readmeObjectName, content.Bytes())
+		if err != nil {
+			return fmt.Errorf("can't create readme of path %s: %w", path, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	onyxiav1 "github.com/inseefrlab/onyxia-onboarding-operator/api/v1"
+	v1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	podSecurityPrivileged  = "privileged"
+	podSecurityLabelPrefix = "pod-security.kubernetes.io/"
+)
+
+// podSecurity returns the Pod Security Standard of the workspace namespace, nil if none.
+// A workspace asking for the privileged standard must be approved by the operator
+// configuration, which workspace editors can't change.
+func (r *WorkspaceReconciler) podSecurity(onyxiaWorkspace *onyxiav1.Workspace) (*onyxiav1.PodSecurity, error) {
+	podSecurity := onyxiaWorkspace.Spec.PodSecurity
+	if podSecurity == nil {
+		return r.Config.PodSecurity, nil
+	}
+	if podSecurity.Level == podSecurityPrivileged && !r.Config.privilegedWorkspace(onyxiaWorkspace) {
+		return nil, fmt.Errorf("privileged pod security needs workspace %s/%s in the privilegedWorkspaces of the operator configuration", onyxiaWorkspace.Namespace, onyxiaWorkspace.Name)
+	}
+	return podSecurity, nil
+}
+
+// podSecurityLabels returns the Pod Security Admission labels enforcing, auditing and
+// warning about the standard
+func podSecurityLabels(podSecurity *onyxiav1.PodSecurity) map[string]string {
+	version := podSecurity.Version
+	if version == "" {
+		version = "latest"
+	}
+	labels := map[string]string{}
+	for _, mode := range []string{"enforce", "audit", "warn"} {
+		labels[podSecurityLabelPrefix+mode] = podSecurity.Level
+		labels[podSecurityLabelPrefix+mode+"-version"] = version
+	}
+	return labels
+}
+
+// handlePodSecurity sets the Pod Security Admission labels of the workspace namespace.
+// Without a standard, the labels the operator set are removed.
+func (r *WorkspaceReconciler) handlePodSecurity(ctx context.Context, onyxiaWorkspace *onyxiav1.Workspace) error {
+	podSecurity, err := r.podSecurity(onyxiaWorkspace)
+	if err != nil {
+		return err
+	}
+	if podSecurity == nil && !onyxiaWorkspace.Status.Namespace.PodSecurityApplied {
+		return nil
+	}
+	namespace := &v1.Namespace{}
+	err = r.Get(ctx, client.ObjectKey{Name: onyxiaWorkspace.Spec.Namespace}, namespace)
+	if err != nil {
+		return err
+	}
+	patch := client.MergeFrom(namespace.DeepCopy())
+	if namespace.Labels == nil {
+		namespace.Labels = map[string]string{}
+	}
+	changed := false
+	if podSecurity == nil {
+		for k := range podSecurityLabels(&onyxiav1.PodSecurity{}) {
+			if _, found := namespace.Labels[k]; found {
+				delete(namespace.Labels, k)
+				changed = true
+			}
+		}
+	} else {
+		for k, v := range podSecurityLabels(podSecurity) {
+			if namespace.Labels[k] != v {
+				namespace.Labels[k] = v
+				changed = true
+			}
+		}
+	}
+	if changed {
+		err = r.Patch(ctx, namespace, patch)
+		if err != nil {
+			return fmt.Errorf("can't set pod security labels of namespace %s: %w", namespace.Name, err)
+		}
+	}
+	onyxiaWorkspace.Status.Namespace.PodSecurityApplied = podSecurity != nil
+	return nil
+}
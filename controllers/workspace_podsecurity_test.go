@@ -0,0 +1,78 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	onyxiav1 "github.com/inseefrlab/onyxia-onboarding-operator/api/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var _ = Describe("handlePodSecurity", func() {
+	var k8sClient client.Client
+	var reconciler *WorkspaceReconciler
+	var workspace *onyxiav1.Workspace
+
+	BeforeEach(func() {
+		k8sClient = newFakeClient(&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "titi", Labels: map[string]string{"team": "titi"}}})
+		reconciler = &WorkspaceReconciler{Client: k8sClient, Scheme: scheme.Scheme}
+		workspace = newWorkspace("titi")
+		workspace.Spec.PodSecurity = &onyxiav1.PodSecurity{Level: "restricted"}
+	})
+
+	labels := func() map[string]string {
+		namespace := &v1.Namespace{}
+		Expect(k8sClient.Get(context.Background(), client.ObjectKey{Name: "titi"}, namespace)).To(Succeed())
+		return namespace.Labels
+	}
+
+	It("labels the namespace with the standard of the workspace", func() {
+		Expect(reconciler.handlePodSecurity(context.Background(), workspace)).To(Succeed())
+		Expect(labels()).To(HaveKeyWithValue("pod-security.kubernetes.io/enforce", "restricted"))
+		Expect(labels()).To(HaveKeyWithValue("pod-security.kubernetes.io/warn-version", "latest"))
+		Expect(workspace.Status.Namespace.PodSecurityApplied).To(BeTrue())
+	})
+
+	It("removes the labels it set when the standard is unset", func() {
+		Expect(reconciler.handlePodSecurity(context.Background(), workspace)).To(Succeed())
+		workspace.Spec.PodSecurity = nil
+		Expect(reconciler.handlePodSecurity(context.Background(), workspace)).To(Succeed())
+		Expect(labels()).To(Equal(map[string]string{"team": "titi"}))
+		Expect(workspace.Status.Namespace.PodSecurityApplied).To(BeFalse())
+	})
+
+	It("refuses the privileged standard to workspaces the configuration doesn't approve", func() {
+		workspace.Spec.PodSecurity.Level = "privileged"
+		workspace.Annotations = map[string]string{"onyxia.sh/privileged-pod-security": "true"}
+		Expect(reconciler.handlePodSecurity(context.Background(), workspace)).NotTo(Succeed())
+		Expect(labels()).NotTo(HaveKey("pod-security.kubernetes.io/enforce"))
+	})
+
+	It("applies the privileged standard to workspaces the configuration approves", func() {
+		workspace.Spec.PodSecurity.Level = "privileged"
+		reconciler.Config.PrivilegedWorkspaces = []string{"onyxia-onboarding-operator-system/titi"}
+		Expect(reconciler.handlePodSecurity(context.Background(), workspace)).To(Succeed())
+		Expect(labels()).To(HaveKeyWithValue("pod-security.kubernetes.io/enforce", "privileged"))
+	})
+})
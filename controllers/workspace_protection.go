@@ -0,0 +1,119 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	onyxiav1 "github.com/inseefrlab/onyxia-onboarding-operator/api/v1"
+	"github.com/inseefrlab/onyxia-onboarding-operator/controllers/s3/factory"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// handleProtection applies the versioning, object lock and legal hold of the bucket.
+// Settings the object store can't apply to an existing bucket are returned, to be
+// reported in the workspace status, instead of failing the reconcile.
+func handleProtection(onyxiaWorkspace *onyxiav1.Workspace, s3Client factory.S3Client) ([]string, error) {
+	if _, prefix := bucketLocation(onyxiaWorkspace); prefix != "" {
+		// settings of a shared bucket, reported by sharedBucketUnapplied
+		return nil, nil
+	}
+	bucket := onyxiaWorkspace.Spec.Bucket
+	unapplied := []string{}
+	objectLock, err := s3Client.GetObjectLock(bucket.Name)
+	if err != nil {
+		return nil, fmt.Errorf("can't get object lock of bucket %s: %w", bucket.Name, err)
+	}
+
+	if bucket.Versioning != "" {
+		versioning, err := s3Client.GetVersioning(bucket.Name)
+		if err != nil {
+			return nil, fmt.Errorf("can't get versioning of bucket %s: %w", bucket.Name, err)
+		}
+		switch {
+		case versioning == bucket.Versioning:
+		case objectLock != nil && bucket.Versioning == factory.VersioningSuspended:
+			unapplied = append(unapplied, "versioning can't be suspended on bucket "+bucket.Name+", it has object lock enabled")
+		default:
+			err = s3Client.SetVersioning(bucket.Name, bucket.Versioning)
+			if err != nil {
+				return nil, fmt.Errorf("can't set versioning of bucket %s: %w", bucket.Name, err)
+			}
+		}
+	}
+
+	if bucket.ObjectLock != nil {
+		desired := factory.ObjectLock{
+			Mode:  bucket.ObjectLock.Mode,
+			Days:  uint(bucket.ObjectLock.Days),
+			Years: uint(bucket.ObjectLock.Years),
+		}
+		switch {
+		case desired.Mode != "" && (desired.Days == 0) == (desired.Years == 0):
+			return nil, fmt.Errorf("object lock of bucket %s needs a retention period in either days or years", bucket.Name)
+		case objectLock == nil:
+			unapplied = append(unapplied, "object lock can only be enabled when the bucket is created, bucket "+bucket.Name+" was created without it")
+		case *objectLock != desired:
+			err = s3Client.SetObjectLock(bucket.Name, desired)
+			if err != nil {
+				return nil, fmt.Errorf("can't set object lock of bucket %s: %w", bucket.Name, err)
+			}
+		}
+	}
+
+	// the legal hold is written on every object, only when it changes
+	if bucket.LegalHold != "" && bucket.LegalHold != onyxiaWorkspace.Status.Bucket.LegalHold {
+		if objectLock == nil {
+			unapplied = append(unapplied, "legal hold needs object lock, which is not enabled on bucket "+bucket.Name)
+		} else {
+			err = s3Client.SetLegalHold(bucket.Name, bucket.LegalHold)
+			if err != nil {
+				return nil, fmt.Errorf("can't set legal hold on objects of bucket %s: %w", bucket.Name, err)
+			}
+			onyxiaWorkspace.Status.Bucket.LegalHold = bucket.LegalHold
+		}
+	}
+	return unapplied, nil
+}
+
+// unsupported tells whether the error reports settings the object store can't apply,
+// to be reported in the BucketSettingsApplied condition
+func unsupported(err error) bool {
+	return errors.Is(err, factory.ErrUnsupported)
+}
+
+// setBucketSettingsCondition reports the bucket settings that could not be applied
+func setBucketSettingsCondition(onyxiaWorkspace *onyxiav1.Workspace, unapplied []string) {
+	condition := metav1.Condition{
+		Type:               "BucketSettingsApplied",
+		Status:             metav1.ConditionTrue,
+		Reason:             "ReasonSucceeded",
+		LastTransitionTime: metav1.NewTime(time.Now()),
+		Message:            "bucket settings applied",
+		ObservedGeneration: onyxiaWorkspace.GetGeneration(),
+	}
+	if len(unapplied) > 0 {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "ReasonUnsupported"
+		condition.Message = strings.Join(unapplied, "; ")
+	}
+	meta.SetStatusCondition(&onyxiaWorkspace.Status.Conditions, condition)
+}
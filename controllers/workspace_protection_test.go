@@ -0,0 +1,73 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	onyxiav1 "github.com/inseefrlab/onyxia-onboarding-operator/api/v1"
+	"github.com/inseefrlab/onyxia-onboarding-operator/controllers/s3/factory"
+)
+
+var _ = Describe("handleProtection", func() {
+	var s3Client *factory.MockedS3Client
+	var workspace *onyxiav1.Workspace
+
+	BeforeEach(func() {
+		s3Client = newMockedS3Client()
+		workspace = newWorkspace("titi")
+		workspace.Status.Bucket.Name = "bucket-titi"
+		workspace.Spec.Bucket.LegalHold = factory.LegalHoldOn
+		Expect(s3Client.CreateBucketWithObjectLock("bucket-titi")).To(Succeed())
+	})
+
+	It("only writes the legal hold on the objects when it changes", func() {
+		unapplied, err := handleProtection(workspace, s3Client)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(unapplied).To(BeEmpty())
+		Expect(workspace.Status.Bucket.LegalHold).To(Equal(factory.LegalHoldOn))
+
+		s3Client.Failures = map[string]error{"SetLegalHold": fmt.Errorf("legal hold written again")}
+		_, err = handleProtection(workspace, s3Client)
+		Expect(err).NotTo(HaveOccurred())
+
+		workspace.Spec.Bucket.LegalHold = factory.LegalHoldOff
+		_, err = handleProtection(workspace, s3Client)
+		Expect(err).To(MatchError(ContainSubstring("legal hold written again")))
+		Expect(workspace.Status.Bucket.LegalHold).To(Equal(factory.LegalHoldOn))
+
+		s3Client.Failures = nil
+		_, err = handleProtection(workspace, s3Client)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(workspace.Status.Bucket.LegalHold).To(Equal(factory.LegalHoldOff))
+	})
+
+	It("reports the legal hold of a bucket without object lock", func() {
+		workspace = newWorkspace("tata")
+		workspace.Status.Bucket.Name = "bucket-tata"
+		workspace.Spec.Bucket.LegalHold = factory.LegalHoldOn
+		Expect(s3Client.CreateBucket("bucket-tata")).To(Succeed())
+
+		unapplied, err := handleProtection(workspace, s3Client)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(unapplied).To(ConsistOf(ContainSubstring("legal hold needs object lock")))
+		Expect(workspace.Status.Bucket.LegalHold).To(BeEmpty())
+	})
+})
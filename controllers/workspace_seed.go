@@ -0,0 +1,115 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	onyxiav1 "github.com/inseefrlab/onyxia-onboarding-operator/api/v1"
+	"github.com/inseefrlab/onyxia-onboarding-operator/controllers/s3/factory"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	seedPhaseCopying   = "Copying"
+	seedPhaseCompleted = "Completed"
+	// objects copied by a reconcile, the copy of larger seeds goes on in the next reconciles
+	seedBatchSize = 100
+)
+
+// seeding reports whether seed objects are left to copy
+func seeding(onyxiaWorkspace *onyxiav1.Workspace) bool {
+	seed := onyxiaWorkspace.Status.Bucket.Seed
+	return seed != nil && seed.Phase == seedPhaseCopying && onyxiaWorkspace.Spec.Bucket.SeedFrom != nil
+}
+
+// handleSeed copies the next batch of seed objects, in key order, and records the
+// progress in the status so that the copy resumes where it stopped. The seed starts
+// when the status has none yet, so a seed whose start wasn't recorded is not lost.
+// The source objects are copied server-side when they are on the backend of the
+// workspace, streamed from the other backend otherwise.
+func (r *WorkspaceReconciler) handleSeed(ctx context.Context, onyxiaWorkspace *onyxiav1.Workspace, s3Client factory.S3Client) error {
+	seedFrom := onyxiaWorkspace.Spec.Bucket.SeedFrom
+	if seedFrom == nil {
+		return nil
+	}
+	if onyxiaWorkspace.Status.Bucket.Seed == nil {
+		onyxiaWorkspace.Status.Bucket.Seed = &onyxiav1.SeedStatus{Phase: seedPhaseCopying}
+	}
+	if !seeding(onyxiaWorkspace) {
+		return nil
+	}
+	if !r.Config.seedSourceAllowed(onyxiaWorkspace) {
+		return fmt.Errorf("seed source %s/%s is not allowed by the operator configuration", seedFrom.Bucket, seedFrom.Prefix)
+	}
+	status := onyxiaWorkspace.Status.Bucket.Seed
+	bucketname, prefix := bucketLocation(onyxiaWorkspace)
+	if seedFrom.Path != "" {
+		path, err := factory.FolderKey(seedFrom.Path)
+		if err != nil {
+			return err
+		}
+		prefix += path
+	}
+	srcClient := s3Client
+	if seedFrom.BackendRef != "" && seedFrom.BackendRef != onyxiaWorkspace.Spec.Bucket.BackendRef {
+		var err error
+		srcClient, err = r.S3Clients.Get(ctx, seedFrom.BackendRef)
+		if err != nil {
+			return err
+		}
+	}
+	objects, err := srcClient.ListObjects(seedFrom.Bucket, seedFrom.Prefix, status.LastCopiedKey, seedBatchSize)
+	if err != nil {
+		return fmt.Errorf("can't list seed objects of bucket %s: %w", seedFrom.Bucket, err)
+	}
+	for _, object := range objects {
+		key := prefix + strings.TrimPrefix(strings.TrimPrefix(object.Key, seedFrom.Prefix), "/")
+		switch {
+		case key == "":
+			// folder marker of the source prefix, the root of the bucket needs none
+		case srcClient == s3Client:
+			err = s3Client.CopyObject(seedFrom.Bucket, object.Key, bucketname, key)
+		default:
+			err = streamObject(srcClient, seedFrom.Bucket, object, s3Client, bucketname, key)
+		}
+		if err != nil {
+			return fmt.Errorf("can't seed object %s of bucket %s: %w", object.Key, seedFrom.Bucket, err)
+		}
+		status.LastCopiedKey = object.Key
+		status.CopiedObjects++
+		status.CopiedBytes += object.Size
+	}
+	if len(objects) < seedBatchSize {
+		now := metav1.Now()
+		status.Phase = seedPhaseCompleted
+		status.CompletionTime = &now
+	}
+	return nil
+}
+
+// streamObject copies the object from another object store, without holding its content in memory
+func streamObject(srcClient factory.S3Client, srcBucketname string, object factory.Object, s3Client factory.S3Client, bucketname string, key string) error {
+	reader, err := srcClient.OpenObject(srcBucketname, object.Key)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	return s3Client.UploadObject(bucketname, key, reader, object.Size)
+}
@@ -0,0 +1,155 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	onyxiav1 "github.com/inseefrlab/onyxia-onboarding-operator/api/v1"
+	"github.com/inseefrlab/onyxia-onboarding-operator/controllers/s3/factory"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = Describe("handleSeed", func() {
+	var reconciler *WorkspaceReconciler
+	var s3Client *factory.MockedS3Client
+	var archive *factory.MockedS3Client
+	var workspace *onyxiav1.Workspace
+
+	BeforeEach(func() {
+		secret := &v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "onyxia-onboarding-operator-system", Name: "archive-credentials"},
+			Data:       map[string][]byte{onyxiav1.S3BackendAccessKeyKey: []byte("access"), onyxiav1.S3BackendSecretKeyKey: []byte("secret")},
+		}
+		backend := &onyxiav1.S3Backend{
+			ObjectMeta: metav1.ObjectMeta{Name: "archive"},
+			Spec: onyxiav1.S3BackendSpec{Provider: "mockedS3Provider", CredentialsSecretRef: v1.SecretReference{
+				Namespace: secret.Namespace, Name: secret.Name,
+			}},
+		}
+		pool := NewS3ClientPool(newFakeClient(secret, backend), &factory.S3Config{S3Provider: "mockedS3Provider"}, nil)
+		reconciler = &WorkspaceReconciler{S3Clients: pool, Config: OperatorConfig{SeedSources: []SeedSource{
+			{Bucket: "datasets", Prefix: "courses/"},
+			{BackendRef: "archive", Bucket: "archives"},
+		}}}
+		s3, err := pool.Get(context.Background(), "")
+		Expect(err).NotTo(HaveOccurred())
+		s3Client = s3.(*factory.MockedS3Client)
+		s3, err = pool.Get(context.Background(), "archive")
+		Expect(err).NotTo(HaveOccurred())
+		archive = s3.(*factory.MockedS3Client)
+
+		Expect(s3Client.CreateBucket("datasets")).To(Succeed())
+		for _, key := range []string{"courses/a.csv", "courses/b.csv", "other.csv"} {
+			Expect(s3Client.PutObject("datasets", key, []byte(key))).To(Succeed())
+		}
+		Expect(archive.CreateBucket("archives")).To(Succeed())
+		Expect(archive.PutObject("archives", "2023/results.csv", []byte("results"))).To(Succeed())
+
+		workspace = newWorkspace("titi")
+		workspace.Spec.Bucket.SeedFrom = &onyxiav1.BucketSeed{Bucket: "datasets", Prefix: "courses/", Path: "diffusion"}
+	})
+
+	It("seeds the bucket once, whatever reconcile created it", func() {
+		Expect(s3Client.CreateBucket("bucket-titi")).To(Succeed())
+		_, err := handleBucket(workspace, s3Client)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(workspace.Status.Bucket.Seed).To(BeNil())
+
+		Expect(reconciler.handleSeed(context.Background(), workspace, s3Client)).To(Succeed())
+		Expect(workspace.Status.Bucket.Seed.Phase).To(Equal(seedPhaseCompleted))
+		Expect(workspace.Status.Bucket.Seed.CopiedObjects).To(Equal(int64(2)))
+		Expect(workspace.Status.Bucket.Seed.CompletionTime).NotTo(BeNil())
+		content, err := s3Client.GetObject("bucket-titi", "diffusion/a.csv")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(content)).To(Equal("courses/a.csv"))
+		_, err = s3Client.GetObject("bucket-titi", "diffusion/other.csv")
+		Expect(err).To(HaveOccurred())
+
+		Expect(s3Client.PutObject("datasets", "courses/c.csv", []byte("courses/c.csv"))).To(Succeed())
+		Expect(reconciler.handleSeed(context.Background(), workspace, s3Client)).To(Succeed())
+		Expect(workspace.Status.Bucket.Seed.CopiedObjects).To(Equal(int64(2)))
+		_, err = s3Client.GetObject("bucket-titi", "diffusion/c.csv")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("copies the objects of the backend of the workspace server-side", func() {
+		Expect(s3Client.CreateBucket("bucket-titi")).To(Succeed())
+		workspace.Status.Bucket.Name = "bucket-titi"
+		s3Client.Failures["OpenObject"] = errors.New("objects of the same backend are copied server-side")
+
+		Expect(reconciler.handleSeed(context.Background(), workspace, s3Client)).To(Succeed())
+		Expect(workspace.Status.Bucket.Seed.CopiedObjects).To(Equal(int64(2)))
+	})
+
+	It("streams the objects of another backend", func() {
+		Expect(s3Client.CreateBucket("bucket-titi")).To(Succeed())
+		workspace.Status.Bucket.Name = "bucket-titi"
+		workspace.Spec.Bucket.SeedFrom = &onyxiav1.BucketSeed{Bucket: "archives", BackendRef: "archive"}
+		archive.Failures["GetObject"] = errors.New("objects of another backend are streamed")
+		s3Client.Failures["PutObject"] = errors.New("objects of another backend are streamed")
+
+		Expect(reconciler.handleSeed(context.Background(), workspace, s3Client)).To(Succeed())
+		Expect(workspace.Status.Bucket.Seed.Phase).To(Equal(seedPhaseCompleted))
+		Expect(workspace.Status.Bucket.Seed.CopiedBytes).To(Equal(int64(7)))
+		content, err := s3Client.GetObject("bucket-titi", "2023/results.csv")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(content)).To(Equal("results"))
+	})
+
+	It("copies large seeds in batches", func() {
+		Expect(s3Client.CreateBucket("bucket-titi")).To(Succeed())
+		workspace.Status.Bucket.Name = "bucket-titi"
+		for i := 0; i < seedBatchSize; i++ {
+			Expect(s3Client.PutObject("datasets", fmt.Sprintf("courses/part-%03d.csv", i), []byte("part"))).To(Succeed())
+		}
+
+		Expect(reconciler.handleSeed(context.Background(), workspace, s3Client)).To(Succeed())
+		Expect(workspace.Status.Bucket.Seed.Phase).To(Equal(seedPhaseCopying))
+		Expect(workspace.Status.Bucket.Seed.CopiedObjects).To(Equal(int64(seedBatchSize)))
+		Expect(seeding(workspace)).To(BeTrue())
+
+		Expect(reconciler.handleSeed(context.Background(), workspace, s3Client)).To(Succeed())
+		Expect(workspace.Status.Bucket.Seed.Phase).To(Equal(seedPhaseCompleted))
+		Expect(workspace.Status.Bucket.Seed.CopiedObjects).To(Equal(int64(seedBatchSize + 2)))
+	})
+
+	It("refuses the sources the configuration doesn't allow", func() {
+		Expect(s3Client.CreateBucket("bucket-titi")).To(Succeed())
+		workspace.Status.Bucket.Name = "bucket-titi"
+		for _, seedFrom := range []onyxiav1.BucketSeed{
+			{Bucket: "datasets"},
+			{Bucket: "bucket-toto"},
+			{Bucket: "archives"},
+			{Bucket: "datasets", Prefix: "courses/", BackendRef: "archive"},
+		} {
+			seed := seedFrom
+			workspace.Spec.Bucket.SeedFrom = &seed
+			workspace.Status.Bucket.Seed = nil
+			Expect(reconciler.handleSeed(context.Background(), workspace, s3Client)).To(MatchError(ContainSubstring("is not allowed by the operator configuration")))
+		}
+		usage, err := s3Client.GetBucketUsage("bucket-titi")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(usage.Objects).To(BeZero())
+	})
+})
@@ -0,0 +1,155 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+	"strings"
+
+	onyxiav1 "github.com/inseefrlab/onyxia-onboarding-operator/api/v1"
+	"github.com/inseefrlab/onyxia-onboarding-operator/controllers/s3/factory"
+)
+
+// sharedBucket returns the bucket the workspace gets a folder in, empty when it has its own bucket
+func (r *WorkspaceReconciler) sharedBucket(onyxiaWorkspace *onyxiav1.Workspace) string {
+	if onyxiaWorkspace.Spec.Bucket.SharedBucket != "" {
+		return onyxiaWorkspace.Spec.Bucket.SharedBucket
+	}
+	return r.Config.SharedBucket
+}
+
+// sharedBucketClaimsPrefix is where the shared buckets keep, out of reach of the workspace
+// identities, the object claiming each folder for its workspace
+const sharedBucketClaimsPrefix = ".onyxia-claims/"
+
+// bucketLocation returns the bucket holding the data of the workspace and the
+// prefix of the workspace in it, as set in the status by the bucket step
+func bucketLocation(onyxiaWorkspace *onyxiav1.Workspace) (string, string) {
+	return onyxiaWorkspace.Status.Bucket.Name, onyxiaWorkspace.Status.Bucket.Prefix
+}
+
+// handleSharedBucket makes sure the shared bucket exists, with the folder of the
+// workspace and its paths. Only the shared buckets of the operator configuration
+// can be used, and the folder must be claimed by the workspace. The shared bucket
+// is neither tagged nor given a quota, its settings belong to the operator administrators.
+func (r *WorkspaceReconciler) handleSharedBucket(onyxiaWorkspace *onyxiav1.Workspace, s3Client factory.S3Client, sharedBucket string) error {
+	if !r.Config.sharedBucketAllowed(sharedBucket) {
+		return fmt.Errorf("bucket %s is not a shared bucket of the operator configuration", sharedBucket)
+	}
+	prefix, err := factory.FolderKey(onyxiaWorkspace.Spec.Bucket.Name)
+	if err != nil {
+		return err
+	}
+	found, err := s3Client.BucketExists(sharedBucket)
+	if err != nil {
+		return fmt.Errorf("can't check shared bucket %s: %w", sharedBucket, err)
+	}
+	if found {
+		tags, err := s3Client.GetBucketTags(sharedBucket)
+		if err != nil {
+			return fmt.Errorf("can't get tags of shared bucket %s: %w", sharedBucket, err)
+		}
+		if owner, namespace := bucketOwner(tags); owner != "" {
+			return fmt.Errorf("bucket %s belongs to workspace %s of namespace %s, it can't be shared", sharedBucket, owner, namespace)
+		}
+	} else {
+		err = s3Client.CreateBucket(sharedBucket)
+		if err != nil {
+			return fmt.Errorf("can't create shared bucket %s: %w", sharedBucket, err)
+		}
+	}
+	found, err = s3Client.PathExists(sharedBucket, prefix)
+	if err != nil {
+		return fmt.Errorf("can't check folder %s of shared bucket %s: %w", prefix, sharedBucket, err)
+	}
+	err = claimFolder(onyxiaWorkspace, s3Client, sharedBucket, prefix, found)
+	if err != nil {
+		return err
+	}
+	onyxiaWorkspace.Status.Bucket.Name = sharedBucket
+	onyxiaWorkspace.Status.Bucket.Prefix = prefix
+	if !found {
+		err = s3Client.CreatePath(sharedBucket, prefix)
+		if err != nil {
+			return fmt.Errorf("can't create folder %s of shared bucket %s: %w", prefix, sharedBucket, err)
+		}
+	}
+	return handlePaths(onyxiaWorkspace, s3Client)
+}
+
+// claimFolder makes sure the folder of the shared bucket belongs to the workspace,
+// claiming it if it is new or if the workspace already used it. The claim is kept
+// with the folder when the workspace goes.
+func claimFolder(onyxiaWorkspace *onyxiav1.Workspace, s3Client factory.S3Client, sharedBucket string, prefix string, folderFound bool) error {
+	key := sharedBucketClaimsPrefix + strings.TrimSuffix(prefix, "/")
+	claimant := onyxiaWorkspace.Namespace + "/" + onyxiaWorkspace.Name
+	objects, err := s3Client.ListObjects(sharedBucket, key, "", 1)
+	if err != nil {
+		return fmt.Errorf("can't check claim of folder %s of shared bucket %s: %w", prefix, sharedBucket, err)
+	}
+	if len(objects) > 0 && objects[0].Key == key {
+		claim, err := s3Client.GetObject(sharedBucket, key)
+		if err != nil {
+			return fmt.Errorf("can't read claim of folder %s of shared bucket %s: %w", prefix, sharedBucket, err)
+		}
+		if string(claim) != claimant {
+			return fmt.Errorf("folder %s of shared bucket %s is claimed by workspace %s", prefix, sharedBucket, claim)
+		}
+		return nil
+	}
+	bucketname, previousPrefix := bucketLocation(onyxiaWorkspace)
+	if folderFound && (bucketname != sharedBucket || previousPrefix != prefix) {
+		return fmt.Errorf("folder %s of shared bucket %s already exists and is not claimed by the workspace", prefix, sharedBucket)
+	}
+	err = s3Client.PutObject(sharedBucket, key, []byte(claimant))
+	if err != nil {
+		return fmt.Errorf("can't claim folder %s of shared bucket %s: %w", prefix, sharedBucket, err)
+	}
+	return nil
+}
+
+// sharedBucketUnapplied returns the bucket settings of the workspace that can't
+// apply to a folder of a shared bucket
+func sharedBucketUnapplied(onyxiaWorkspace *onyxiav1.Workspace) []string {
+	bucketname, prefix := bucketLocation(onyxiaWorkspace)
+	if prefix == "" {
+		return nil
+	}
+	bucket := onyxiaWorkspace.Spec.Bucket
+	unapplied := []string{}
+	settings := []struct {
+		name string
+		set  bool
+	}{
+		{"versioning", bucket.Versioning != ""},
+		{"object lock", bucket.ObjectLock != nil},
+		{"legal hold", bucket.LegalHold != ""},
+		{"lifecycle", len(bucket.Lifecycle) > 0},
+		{"cors", len(bucket.CORS) > 0},
+		{"notifications", len(bucket.Notifications) > 0},
+		{"encryption", bucket.Encryption != nil},
+	}
+	for _, setting := range settings {
+		if setting.set {
+			unapplied = append(unapplied, setting.name+" is a setting of the shared bucket "+bucketname+", not applied to folder "+prefix)
+		}
+	}
+	if bucketQuota(bucket) != (factory.Quota{}) {
+		unapplied = append(unapplied, "quotas of folders of the shared bucket "+bucketname+" are soft, only reported in the status")
+	}
+	return unapplied
+}
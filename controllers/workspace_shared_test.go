@@ -0,0 +1,86 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	onyxiav1 "github.com/inseefrlab/onyxia-onboarding-operator/api/v1"
+	"github.com/inseefrlab/onyxia-onboarding-operator/controllers/s3/factory"
+)
+
+var _ = Describe("handleSharedBucket", func() {
+	var reconciler *WorkspaceReconciler
+	var s3Client *factory.MockedS3Client
+	var workspace *onyxiav1.Workspace
+
+	BeforeEach(func() {
+		reconciler = &WorkspaceReconciler{Config: OperatorConfig{SharedBucket: "onyxia-workspaces", SharedBuckets: []string{"onyxia-courses"}}}
+		s3Client = newMockedS3Client()
+		workspace = newWorkspace("titi")
+	})
+
+	It("gives the workspace a folder it claims in the shared bucket", func() {
+		Expect(reconciler.handleSharedBucket(workspace, s3Client, "onyxia-workspaces")).To(Succeed())
+		Expect(workspace.Status.Bucket.Name).To(Equal("onyxia-workspaces"))
+		Expect(workspace.Status.Bucket.Prefix).To(Equal("bucket-titi/"))
+		found, err := s3Client.PathExists("onyxia-workspaces", "bucket-titi/")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(found).To(BeTrue())
+		claim, err := s3Client.GetObject("onyxia-workspaces", ".onyxia-claims/bucket-titi")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(claim)).To(Equal("onyxia-onboarding-operator-system/titi"))
+
+		Expect(reconciler.handleSharedBucket(workspace, s3Client, "onyxia-workspaces")).To(Succeed())
+	})
+
+	It("accepts the other shared buckets of the configuration only", func() {
+		Expect(reconciler.handleSharedBucket(workspace, s3Client, "onyxia-courses")).To(Succeed())
+		Expect(reconciler.handleSharedBucket(workspace, s3Client, "bucket-toto")).To(MatchError(ContainSubstring("not a shared bucket")))
+	})
+
+	It("refuses a folder claimed by another workspace", func() {
+		Expect(reconciler.handleSharedBucket(workspace, s3Client, "onyxia-workspaces")).To(Succeed())
+		other := newWorkspace("toto")
+		other.Spec.Bucket.Name = "bucket-titi"
+		Expect(reconciler.handleSharedBucket(other, s3Client, "onyxia-workspaces")).To(MatchError(ContainSubstring("is claimed by workspace onyxia-onboarding-operator-system/titi")))
+		Expect(other.Status.Bucket.Name).To(BeEmpty())
+	})
+
+	It("refuses a folder that exists without claim", func() {
+		Expect(s3Client.CreateBucket("onyxia-workspaces")).To(Succeed())
+		Expect(s3Client.CreatePath("onyxia-workspaces", "bucket-titi")).To(Succeed())
+		Expect(reconciler.handleSharedBucket(workspace, s3Client, "onyxia-workspaces")).To(MatchError(ContainSubstring("not claimed by the workspace")))
+	})
+
+	It("refuses the bucket of a workspace", func() {
+		reconciler.Config.SharedBuckets = append(reconciler.Config.SharedBuckets, "bucket-toto")
+		Expect(s3Client.CreateBucket("bucket-toto")).To(Succeed())
+		Expect(s3Client.SetBucketTags("bucket-toto", map[string]string{workspaceTagKey: "toto", namespaceTagKey: "toto"})).To(Succeed())
+		Expect(reconciler.handleSharedBucket(workspace, s3Client, "bucket-toto")).To(MatchError(ContainSubstring("belongs to workspace toto")))
+	})
+
+	It("reports the bucket settings that don't apply to a folder", func() {
+		Expect(reconciler.handleSharedBucket(workspace, s3Client, "onyxia-workspaces")).To(Succeed())
+		workspace.Spec.Bucket.Versioning = factory.VersioningEnabled
+		Expect(sharedBucketUnapplied(workspace)).To(ContainElements(
+			"versioning is a setting of the shared bucket onyxia-workspaces, not applied to folder bucket-titi/",
+			"quotas of folders of the shared bucket onyxia-workspaces are soft, only reported in the status",
+		))
+	})
+})
@@ -0,0 +1,88 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+	"reflect"
+
+	onyxiav1 "github.com/inseefrlab/onyxia-onboarding-operator/api/v1"
+	"github.com/inseefrlab/onyxia-onboarding-operator/controllers/s3/factory"
+)
+
+const (
+	// tags linking a bucket to its workspace
+	workspaceTagKey = "onyxia.sh/workspace"
+	namespaceTagKey = "onyxia.sh/namespace"
+	// tags copied from the workspace labels of the same key
+	ownerTagKey      = "onyxia.sh/owner"
+	classTagKey      = "onyxia.sh/class"
+	costCentreTagKey = "onyxia.sh/cost-centre"
+)
+
+// bucketTags returns the tags the operator maintains on the bucket of the workspace,
+// an empty value means the tag must be removed
+func bucketTags(onyxiaWorkspace *onyxiav1.Workspace) map[string]string {
+	tags := map[string]string{
+		workspaceTagKey: onyxiaWorkspace.Name,
+		namespaceTagKey: onyxiaWorkspace.Spec.Namespace,
+		managedByLabel:  managedByValue,
+	}
+	for _, key := range []string{ownerTagKey, classTagKey, costCentreTagKey} {
+		tags[key] = onyxiaWorkspace.Labels[key]
+	}
+	return tags
+}
+
+// bucketOwner returns the workspace and the namespace the bucket is tagged with, empty
+// for untagged buckets. Buckets tagged before the namespace tag have no namespace.
+func bucketOwner(tags map[string]string) (string, string) {
+	return tags[workspaceTagKey], tags[namespaceTagKey]
+}
+
+// handleBucketTags refuses buckets tagged with another workspace, then updates the
+// tags maintained by the operator, keeping the other tags of the bucket.
+func handleBucketTags(onyxiaWorkspace *onyxiav1.Workspace, s3Client factory.S3Client) error {
+	bucketname := onyxiaWorkspace.Spec.Bucket.Name
+	current, err := s3Client.GetBucketTags(bucketname)
+	if err != nil {
+		return fmt.Errorf("can't get tags of bucket %s: %w", bucketname, err)
+	}
+	owner, namespace := bucketOwner(current)
+	if owner != "" && (owner != onyxiaWorkspace.Name || namespace != "" && namespace != onyxiaWorkspace.Spec.Namespace) {
+		return fmt.Errorf("bucket %s belongs to workspace %s of namespace %s", bucketname, owner, namespace)
+	}
+	tags := map[string]string{}
+	for k, v := range current {
+		tags[k] = v
+	}
+	for k, v := range bucketTags(onyxiaWorkspace) {
+		if v == "" {
+			delete(tags, k)
+		} else {
+			tags[k] = v
+		}
+	}
+	if reflect.DeepEqual(tags, current) {
+		return nil
+	}
+	err = s3Client.SetBucketTags(bucketname, tags)
+	if err != nil {
+		return fmt.Errorf("can't set tags of bucket %s: %w", bucketname, err)
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+	"time"
+
+	onyxiav1 "github.com/inseefrlab/onyxia-onboarding-operator/api/v1"
+	"github.com/inseefrlab/onyxia-onboarding-operator/controllers/s3/factory"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// handleUsage refreshes the bucket usage in the status once per refresh interval,
+// warns when it gets close to the quota and reports when it goes over it.
+func (r *WorkspaceReconciler) handleUsage(onyxiaWorkspace *onyxiav1.Workspace, s3Client factory.S3Client) error {
+	bucket := onyxiaWorkspace.Spec.Bucket
+	status := &onyxiaWorkspace.Status.Bucket
+	if status.UsageUpdateTime == nil || time.Since(status.UsageUpdateTime.Time) >= r.Config.usageRefreshInterval() {
+		bucketname, prefix := bucketLocation(onyxiaWorkspace)
+		var usage factory.Usage
+		var err error
+		if prefix != "" {
+			usage, err = s3Client.GetPrefixUsage(bucketname, prefix)
+		} else {
+			usage, err = s3Client.GetBucketUsage(bucketname)
+		}
+		if err != nil {
+			return fmt.Errorf("can't get usage of bucket %s: %w", bucketname, err)
+		}
+		now := metav1.Now()
+		status.UsedBytes = usage.Bytes
+		status.ObjectCount = usage.Objects
+		status.UsageUpdateTime = &now
+	}
+	// the quota may have changed since the last refresh
+	quota := quotaBytes(bucket)
+	status.PercentOfQuota = 0
+	if quota > 0 {
+		status.PercentOfQuota = int32(status.UsedBytes * 100 / quota)
+	}
+
+	condition := metav1.Condition{
+		Type:               "QuotaWarning",
+		Status:             metav1.ConditionFalse,
+		Reason:             "UsageBelowThreshold",
+		LastTransitionTime: metav1.NewTime(time.Now()),
+		Message:            fmt.Sprintf("bucket %s uses %d%% of its quota", bucket.Name, status.PercentOfQuota),
+		ObservedGeneration: onyxiaWorkspace.GetGeneration(),
+	}
+	if quota > 0 && status.PercentOfQuota >= r.Config.usageWarningThreshold() {
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = "UsageAboveThreshold"
+	}
+	meta.SetStatusCondition(&onyxiaWorkspace.Status.Conditions, condition)
+
+	// the object stores without native quota, such as AWS, only get this condition
+	exceeded := metav1.Condition{
+		Type:               "QuotaExceeded",
+		Status:             metav1.ConditionFalse,
+		Reason:             "UsageWithinQuota",
+		LastTransitionTime: metav1.NewTime(time.Now()),
+		Message:            fmt.Sprintf("bucket %s uses %d bytes in %d objects", bucket.Name, status.UsedBytes, status.ObjectCount),
+		ObservedGeneration: onyxiaWorkspace.GetGeneration(),
+	}
+	if (quota > 0 && status.UsedBytes > quota) || (bucket.ObjectQuota > 0 && status.ObjectCount > bucket.ObjectQuota) {
+		exceeded.Status = metav1.ConditionTrue
+		exceeded.Reason = "UsageAboveQuota"
+	}
+	meta.SetStatusCondition(&onyxiaWorkspace.Status.Conditions, exceeded)
+	return nil
+}
@@ -0,0 +1,68 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/inseefrlab/onyxia-onboarding-operator/controllers/s3/factory"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+var _ = Describe("handleUsage", func() {
+	var s3Client *factory.MockedS3Client
+	var reconciler *WorkspaceReconciler
+
+	BeforeEach(func() {
+		s3Client = newMockedS3Client()
+		Expect(s3Client.CreateBucket("bucket-titi")).To(Succeed())
+		Expect(s3Client.PutObject("bucket-titi", "diffusion/data.csv", make([]byte, 900))).To(Succeed())
+		reconciler = &WorkspaceReconciler{}
+	})
+
+	It("warns when the usage gets close to the quota", func() {
+		workspace := newWorkspace("titi")
+		workspace.Status.Bucket.Name = "bucket-titi"
+		quota := resource.MustParse("1000")
+		workspace.Spec.Bucket.Quota = &quota
+		Expect(reconciler.handleUsage(workspace, s3Client)).To(Succeed())
+
+		Expect(workspace.Status.Bucket.UsedBytes).To(Equal(int64(900)))
+		Expect(workspace.Status.Bucket.PercentOfQuota).To(Equal(int32(90)))
+		Expect(meta.IsStatusConditionTrue(workspace.Status.Conditions, "QuotaWarning")).To(BeTrue())
+		Expect(meta.IsStatusConditionFalse(workspace.Status.Conditions, "QuotaExceeded")).To(BeTrue())
+	})
+
+	It("reports the usage over the size or the object quota", func() {
+		workspace := newWorkspace("titi")
+		workspace.Status.Bucket.Name = "bucket-titi"
+		quota := resource.MustParse("800")
+		workspace.Spec.Bucket.Quota = &quota
+		Expect(reconciler.handleUsage(workspace, s3Client)).To(Succeed())
+		Expect(meta.IsStatusConditionTrue(workspace.Status.Conditions, "QuotaExceeded")).To(BeTrue())
+
+		workspace = newWorkspace("titi")
+		workspace.Status.Bucket.Name = "bucket-titi"
+		workspace.Spec.Bucket.Quota = nil
+		workspace.Spec.Bucket.ObjectQuota = 1
+		Expect(s3Client.PutObject("bucket-titi", "diffusion/other.csv", nil)).To(Succeed())
+		Expect(reconciler.handleUsage(workspace, s3Client)).To(Succeed())
+		Expect(meta.IsStatusConditionTrue(workspace.Status.Conditions, "QuotaExceeded")).To(BeTrue())
+	})
+})
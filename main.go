@@ -17,18 +17,23 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"os"
+	"strings"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
@@ -49,6 +54,12 @@ var (
 	watchNamespaceEnvVar = "WATCH_NAMESPACE"
 )
 
+const (
+	// built-in s3 credentials, only accepted with --insecure-default-credentials
+	defaultAccessKey = "ROOTNAME"
+	defaultSecretKey = "CHANGEME123"
+)
+
 func init() {
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
 
@@ -67,18 +78,38 @@ func main() {
 	var region string
 	var s3Provider string
 	var useSsl bool
+	var rgwAdminPath string
+	var credentialsPath string
+	var credentialsSecret string
+	var insecureDefaultCredentials bool
+	var caBundleFile string
+	var clientCertFile string
+	var clientKeyFile string
+	var tlsServerName string
+	var insecureSkipVerify bool
+	var configFile string
 
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
-	flag.StringVar(&s3Provider, "s3-provider", "minio", "provider s3")
+	flag.StringVar(&s3Provider, "s3-provider", "minio", "provider s3 (minio, aws, ceph-rgw)")
 	flag.StringVar(&s3EndpointUrl, "s3-endpoint-url", "localhost:9000", "adress of s3")
-	flag.StringVar(&accessKey, "s3-access-key", "ROOTNAME", "The accessKey of the acount")
-	flag.StringVar(&secretKey, "s3-secret-key", "CHANGEME123", "The secretKey of the acount")
+	flag.StringVar(&accessKey, "s3-access-key", defaultAccessKey, "The accessKey of the acount, prefer --s3-credentials-path or --s3-credentials-secret")
+	flag.StringVar(&secretKey, "s3-secret-key", defaultSecretKey, "The secretKey of the acount, prefer --s3-credentials-path or --s3-credentials-secret")
+	flag.StringVar(&credentialsPath, "s3-credentials-path", "", "directory holding the accessKey and secretKey files, e.g. a mounted secret, read again at each reconcile")
+	flag.StringVar(&credentialsSecret, "s3-credentials-secret", "", "namespace/name of the secret holding accessKey and secretKey, reloaded on change")
+	flag.BoolVar(&insecureDefaultCredentials, "insecure-default-credentials", false, "allow to start with the built-in default s3 credentials")
 	flag.StringVar(&region, "region", "use-east-1", "The region")
-	flag.BoolVar(&useSsl, "useSsl", false, "ssl or not ")
+	flag.BoolVar(&useSsl, "useSsl", false, "use TLS with the s3 endpoint, the aws endpoints always use it")
+	flag.StringVar(&caBundleFile, "s3-ca-bundle", "", "PEM file of the certificate authorities to trust for s3, in addition to the system ones")
+	flag.StringVar(&clientCertFile, "s3-client-cert", "", "PEM client certificate presented to s3")
+	flag.StringVar(&clientKeyFile, "s3-client-key", "", "PEM key of the s3 client certificate")
+	flag.StringVar(&tlsServerName, "s3-tls-server-name", "", "server name used for SNI and certificate verification, the endpoint host by default")
+	flag.BoolVar(&insecureSkipVerify, "s3-insecure-skip-verify", false, "do not verify the s3 certificate, for tests only")
+	flag.StringVar(&rgwAdminPath, "rgw-admin-path", "admin", "path of the Ceph RGW admin api (ceph-rgw provider)")
+	flag.StringVar(&configFile, "config", "", "yaml file of the operator configuration, defaults applied to every workspace")
 
 	opts := zap.Options{
 		Development: true,
@@ -121,20 +152,59 @@ func main() {
 		os.Exit(1)
 	}
 
-	s3Config := &factory.S3Config{S3Provider: s3Provider, S3UrlEndpoint: s3EndpointUrl, Region: region, AccessKey: accessKey, SecretKey: secretKey}
-	s3Client, err := factory.GetS3Client(s3Config.S3Provider, s3Config)
+	// without credentials, there is no default backend and every workspace needs an S3Backend
+	var s3Config *factory.S3Config
+	var s3CredentialsSecret *types.NamespacedName
+	if s3Provider == "mockedS3Provider" || credentialsPath != "" || credentialsSecret != "" ||
+		accessKey != defaultAccessKey || secretKey != defaultSecretKey || insecureDefaultCredentials {
+		s3Config = &factory.S3Config{S3Provider: s3Provider, S3UrlEndpoint: s3EndpointUrl, Region: region, AccessKey: accessKey, SecretKey: secretKey, UseSsl: useSsl, RgwAdminPath: rgwAdminPath, CredentialsPath: credentialsPath}
+		s3Config.TLS, err = loadTLSConfig(caBundleFile, clientCertFile, clientKeyFile)
+		if err != nil {
+			setupLog.Error(err, "unable to load s3 tls configuration")
+			os.Exit(1)
+		}
+		s3Config.TLS.ServerName = tlsServerName
+		s3Config.TLS.InsecureSkipVerify = insecureSkipVerify
+		if credentialsSecret != "" {
+			namespace, name, found := strings.Cut(credentialsSecret, "/")
+			if !found {
+				setupLog.Error(nil, "--s3-credentials-secret must be namespace/name")
+				os.Exit(1)
+			}
+			s3CredentialsSecret = &types.NamespacedName{Namespace: namespace, Name: name}
+		}
+		err = checkS3Credentials(mgr.GetAPIReader(), *s3Config, s3CredentialsSecret, insecureDefaultCredentials)
+		if err != nil {
+			log.Log.Error(err, err.Error())
+			os.Exit(1)
+		}
+	} else {
+		setupLog.Info("no s3 credentials configured, workspaces must reference an S3Backend")
+	}
+	operatorConfig, err := controllers.LoadOperatorConfig(configFile)
 	if err != nil {
-		log.Log.Error(err, err.Error())
+		setupLog.Error(err, "unable to load operator configuration")
 		os.Exit(1)
 	}
+	s3Clients := controllers.NewS3ClientPool(mgr.GetClient(), s3Config, s3CredentialsSecret)
 	if err = (&controllers.WorkspaceReconciler{
-		Client:   mgr.GetClient(),
-		Scheme:   mgr.GetScheme(),
-		S3Client: &s3Client,
+		Client:    mgr.GetClient(),
+		Scheme:    mgr.GetScheme(),
+		S3Clients: s3Clients,
+		Config:    operatorConfig,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "Workspace")
 		os.Exit(1)
 	}
+	if err = (&controllers.BucketAccessGrantReconciler{
+		Client:    mgr.GetClient(),
+		Scheme:    mgr.GetScheme(),
+		S3Clients: s3Clients,
+		Config:    operatorConfig,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "BucketAccessGrant")
+		os.Exit(1)
+	}
 	//+kubebuilder:scaffold:builder
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
 		setupLog.Error(err, "unable to set up health check")
@@ -152,6 +222,59 @@ func main() {
 	}
 }
 
+// loadTLSConfig reads the optional CA bundle and client certificate files.
+func loadTLSConfig(caBundleFile string, clientCertFile string, clientKeyFile string) (factory.TLSConfig, error) {
+	tlsConfig := factory.TLSConfig{}
+	var err error
+	if caBundleFile != "" {
+		tlsConfig.CABundle, err = os.ReadFile(caBundleFile)
+		if err != nil {
+			return tlsConfig, err
+		}
+	}
+	if clientCertFile != "" || clientKeyFile != "" {
+		tlsConfig.ClientCert, err = os.ReadFile(clientCertFile)
+		if err != nil {
+			return tlsConfig, err
+		}
+		tlsConfig.ClientKey, err = os.ReadFile(clientKeyFile)
+		if err != nil {
+			return tlsConfig, err
+		}
+	}
+	return tlsConfig, nil
+}
+
+// checkS3Credentials makes sure the default s3 credentials can be read and
+// are not the built-in ones, unless explicitly allowed.
+func checkS3Credentials(reader client.Reader, s3Config factory.S3Config, secret *types.NamespacedName, insecure bool) error {
+	if s3Config.S3Provider == "mockedS3Provider" {
+		return nil
+	}
+	if secret != nil {
+		credentials := &corev1.Secret{}
+		err := reader.Get(context.Background(), *secret, credentials)
+		if err != nil {
+			return fmt.Errorf("can't read s3 credentials secret %s: %w", secret, err)
+		}
+		s3Config.AccessKey = string(credentials.Data[onyxiav1.S3BackendAccessKeyKey])
+		s3Config.SecretKey = string(credentials.Data[onyxiav1.S3BackendSecretKeyKey])
+	} else {
+		err := s3Config.LoadCredentials()
+		if err != nil {
+			return err
+		}
+	}
+	if s3Config.AccessKey == defaultAccessKey && s3Config.SecretKey == defaultSecretKey {
+		if !insecure {
+			return fmt.Errorf("refusing to start with the built-in default s3 credentials, " +
+				"use --s3-credentials-path or --s3-credentials-secret, or --insecure-default-credentials")
+		}
+		setupLog.Info("WARNING: using the built-in default s3 credentials")
+	}
+	return nil
+}
+
 func getWatchNamespace() (string, error) {
 
 	ns, found := os.LookupEnv(watchNamespaceEnvVar)